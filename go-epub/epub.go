@@ -28,18 +28,18 @@ package epub
 import (
 	"fmt"
 	"io"
-	"net/http"
+	"io/fs"
 	"net/url"
-	"os"
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/gofrs/uuid"
 )
 
-// FilenameAlreadyUsedError is thrown by AddCSS, AddFont, AddImage, or AddSection
-// if the same filename is used more than once.
+// FilenameAlreadyUsedError is thrown by AddCSS, AddFont, AddImage, AddSection
+// or AddSubSection if the same filename is used more than once.
 type FilenameAlreadyUsedError struct {
 	Filename string // Filename that caused the error
 }
@@ -48,8 +48,18 @@ func (e *FilenameAlreadyUsedError) Error() string {
 	return fmt.Sprintf("Filename already used: %s", e.Filename)
 }
 
-// FileRetrievalError is thrown by AddCSS, AddFont, AddImage, or Write if there was a
-// problem retrieving the source file that was provided.
+// ParentDoesNotExistError is thrown by AddSubSection if the parent section
+// with the given filename hasn't been added.
+type ParentDoesNotExistError struct {
+	Filename string // Filename of the parent section
+}
+
+func (e *ParentDoesNotExistError) Error() string {
+	return fmt.Sprintf("Parent with the internal filename %s does not exist", e.Filename)
+}
+
+// FileRetrievalError is thrown by AddCSS, AddFont, AddImage, SetCover, Write or
+// WriteTo if there was a problem retrieving the source file that was provided.
 type FileRetrievalError struct {
 	Source string // The source of the file whose retrieval failed
 	Err    error  // The underlying error that was thrown
@@ -59,11 +69,16 @@ func (e *FileRetrievalError) Error() string {
 	return fmt.Sprintf("Error retrieving %q from source: %+v", e.Source, e.Err)
 }
 
+func (e *FileRetrievalError) Unwrap() error {
+	return e.Err
+}
+
 // Folder names used for resources inside the EPUB
 const (
 	CSSFolderName   = "css"
 	FontFolderName  = "fonts"
 	ImageFolderName = "images"
+	MediaFolderName = "media"
 )
 
 const (
@@ -89,45 +104,108 @@ img {
 	defaultEpubLang           = "en"
 	fontFileFormat            = "font%04d%s"
 	imageFileFormat           = "image%04d%s"
+	mediaFileFormat           = "media%04d%s"
 	sectionFileFormat         = "section%04d.xhtml"
 	urnUUIDPrefix             = "urn:uuid:"
 )
 
 // Epub implements an EPUB file.
 type Epub struct {
-	author string
-	cover  *epubCover
-	// The key is the css filename, the value is the css source
-	css map[string]string
-	// The key is the font filename, the value is the font source
-	fonts      map[string]string
+	cover *epubCover
+	// The TOC page added with SetTocPage
+	tocPage epubTocPage
+	// The key is the css filename, the value is the css file
+	css map[string]*epubMedia
+	// The key is the font filename, the value is the font file
+	fonts      map[string]*epubMedia
 	identifier string
-	// The key is the image filename, the value is the image source
-	images map[string]string
+	// The key is the image filename, the value is the image file
+	images map[string]*epubMedia
+	// The key is the media filename, the value is the media file
+	media map[string]*epubMedia
 	// Language
 	lang string
 	// Description
 	desc string
 	// Page progression direction
 	ppd string
+	// Dublin Core metadata added with the setters in metadata.go
+	creators     []Contributor
+	contributors []Contributor
+	collections  []Collection
+	identifiers  []Identifier
+	subjects     []string
+	date         string
+	publisher    string
+	rights       string
+	source       string
+	subtitle     string
+	titleFileAs  string
 	// The package file (package.opf)
 	pkg      *pkg
-	sections []epubSection
+	sections []*epubSection
 	title    string
 	// Table of contents
 	toc *toc
+	// Retrieves remote media sources
+	fetcher Fetcher
+	// Local media sources are opened from here if set
+	fsys fs.FS
+	// Modification time written to the EPUB; the time of writing if zero
+	modified time.Time
+	// Whether the output only depends on the content of the EPUB
+	reproducible bool
+	// Whether the identifier was generated rather than set
+	autoIdentifier bool
+	// Whether fonts are obfuscated when the EPUB is written
+	fontObfuscation bool
+	// Rendition layout and spread
+	layout string
+	spread string
+	// The stylesheet set with SetDefaultCSS
+	defaultCSS string
+	// The stylesheets set with SetPageStylesheets
+	pageCSS []string
 }
 
 type epubCover struct {
 	cssFilename   string
-	cssTempFile   string
 	imageFilename string
 	xhtmlFilename string
 }
 
+type epubTocPage struct {
+	enabled bool
+	cssPath string
+	// The stylesheet added by SetTocPage, if no stylesheet was provided
+	defaultCSSFilename string
+}
+
+// epubMedia is a CSS, font, image or other media file. It is either retrieved
+// from its source when the EPUB is written or held in memory.
+type epubMedia struct {
+	source    string
+	data      []byte
+	mediaType string
+	// Manifest id of the item reading systems use if they can't show this one
+	fallback string
+	// Whether the file stays on the web at its source and is only listed in
+	// the manifest
+	remote bool
+}
+
 type epubSection struct {
 	filename string
 	xhtml    *xhtml
+	children []*epubSection
+	// Side of the spread of a pre-paginated page
+	pageSpread string
+	// Whether the section is outside the main reading order (linear="no")
+	nonLinear bool
+	// Other properties of the spine item
+	properties []string
+	// Properties of the manifest item
+	manifestProperties []string
 }
 
 // NewEpub returns a new Epub.
@@ -135,19 +213,26 @@ func NewEpub(title string) *Epub {
 	e := &Epub{}
 	e.cover = &epubCover{
 		cssFilename:   "",
-		cssTempFile:   "",
 		imageFilename: "",
 		xhtmlFilename: "",
 	}
-	e.css = make(map[string]string)
-	e.fonts = make(map[string]string)
-	e.images = make(map[string]string)
+	e.css = make(map[string]*epubMedia)
+	e.fonts = make(map[string]*epubMedia)
+	e.images = make(map[string]*epubMedia)
+	e.media = make(map[string]*epubMedia)
 	e.pkg = newPackage()
 	e.toc = newToc()
+	e.fetcher = NewHTTPFetcher(defaultFetchTimeout)
 	// Set minimal required attributes
 	e.SetIdentifier(urnUUIDPrefix + uuid.Must(uuid.NewV4()).String())
+	e.autoIdentifier = true
 	e.SetLang(defaultEpubLang)
 	e.SetTitle(title)
+	// Honour https://reproducible-builds.org/specs/source-date-epoch/
+	if t, ok := sourceDateEpoch(); ok {
+		e.SetReproducible(true)
+		e.SetModified(t)
+	}
 
 	return e
 }
@@ -157,14 +242,38 @@ func NewEpub(title string) *Epub {
 // ../CSSFolderName/internalFilename
 //
 // The CSS source should either be a URL or a path to a local file; in either
-// case, the CSS file will be retrieved and stored in the EPUB.
+// case, the CSS file will be retrieved and stored in the EPUB. URLs are
+// retrieved right away using the fetcher set with SetFetcher, local files are
+// read from the file system set with SetFS when the EPUB is written.
 //
 // The internal filename will be used when storing the CSS file in the EPUB
 // and must be unique among all CSS files. If the same filename is used more
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddCSS(source string, internalFilename string) (string, error) {
-	return addMedia(source, internalFilename, cssFileFormat, CSSFolderName, e.css)
+	return e.addMedia(source, internalFilename, cssFileFormat, CSSFolderName, e.css)
+}
+
+// AddCSSBytes adds a CSS file held in memory to the EPUB and returns a relative
+// path to the CSS file in the same format as AddCSS.
+//
+// The internal filename follows the same rules as for AddCSS, except that if
+// no filename is provided, one will be generated. The media type is optional
+// and defaults to text/css.
+func (e *Epub) AddCSSBytes(data []byte, internalFilename string, mediaType string) (string, error) {
+	if mediaType == "" {
+		mediaType = mediaTypeCSS
+	}
+	return addMediaBytes(data, internalFilename, mediaType, cssFileFormat, CSSFolderName, e.css)
+}
+
+// AddCSSReader is like AddCSSBytes, but reads the CSS file from r.
+func (e *Epub) AddCSSReader(r io.Reader, internalFilename string, mediaType string) (string, error) {
+	data, err := readMedia(r, internalFilename)
+	if err != nil {
+		return "", err
+	}
+	return e.AddCSSBytes(data, internalFilename, mediaType)
 }
 
 // AddFont adds a font file to the EPUB and returns a relative path to the font
@@ -172,14 +281,35 @@ func (e *Epub) AddCSS(source string, internalFilename string) (string, error) {
 // ../FontFolderName/internalFilename
 //
 // The font source should either be a URL or a path to a local file; in either
-// case, the font file will be retrieved and stored in the EPUB.
+// case, the font file will be retrieved and stored in the EPUB. URLs are
+// retrieved right away using the fetcher set with SetFetcher, local files are
+// read from the file system set with SetFS when the EPUB is written.
 //
 // The internal filename will be used when storing the font file in the EPUB
 // and must be unique among all font files. If the same filename is used more
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddFont(source string, internalFilename string) (string, error) {
-	return addMedia(source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+	return e.addMedia(source, internalFilename, fontFileFormat, FontFolderName, e.fonts)
+}
+
+// AddFontBytes adds a font file held in memory to the EPUB and returns a
+// relative path to the font file in the same format as AddFont.
+//
+// The internal filename follows the same rules as for AddFont, except that if
+// no filename is provided, one will be generated. The media type (e.g.
+// font/woff2) is optional if it can be determined from the filename extension.
+func (e *Epub) AddFontBytes(data []byte, internalFilename string, mediaType string) (string, error) {
+	return addMediaBytes(data, internalFilename, mediaType, fontFileFormat, FontFolderName, e.fonts)
+}
+
+// AddFontReader is like AddFontBytes, but reads the font file from r.
+func (e *Epub) AddFontReader(r io.Reader, internalFilename string, mediaType string) (string, error) {
+	data, err := readMedia(r, internalFilename)
+	if err != nil {
+		return "", err
+	}
+	return e.AddFontBytes(data, internalFilename, mediaType)
 }
 
 // AddImage adds an image to the EPUB and returns a relative path to the image
@@ -187,14 +317,87 @@ func (e *Epub) AddFont(source string, internalFilename string) (string, error) {
 // ../ImageFolderName/internalFilename
 //
 // The image source should either be a URL or a path to a local file; in either
-// case, the image file will be retrieved and stored in the EPUB.
+// case, the image file will be retrieved and stored in the EPUB. URLs are
+// retrieved right away using the fetcher set with SetFetcher, local files are
+// read from the file system set with SetFS when the EPUB is written.
 //
 // The internal filename will be used when storing the image file in the EPUB
 // and must be unique among all image files. If the same filename is used more
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 func (e *Epub) AddImage(source string, imageFilename string) (string, error) {
-	return addMedia(source, imageFilename, imageFileFormat, ImageFolderName, e.images)
+	return e.addMedia(source, imageFilename, imageFileFormat, ImageFolderName, e.images)
+}
+
+// AddImageBytes adds an image held in memory to the EPUB and returns a
+// relative path to the image file in the same format as AddImage.
+//
+// The internal filename follows the same rules as for AddImage, except that if
+// no filename is provided, one will be generated. The media type (e.g.
+// image/png) is optional if it can be determined from the filename extension.
+func (e *Epub) AddImageBytes(data []byte, imageFilename string, mediaType string) (string, error) {
+	return addMediaBytes(data, imageFilename, mediaType, imageFileFormat, ImageFolderName, e.images)
+}
+
+// AddImageReader is like AddImageBytes, but reads the image from r.
+func (e *Epub) AddImageReader(r io.Reader, imageFilename string, mediaType string) (string, error) {
+	data, err := readMedia(r, imageFilename)
+	if err != nil {
+		return "", err
+	}
+	return e.AddImageBytes(data, imageFilename, mediaType)
+}
+
+// AddMedia adds any other media file, such as audio or video, to the EPUB and
+// returns a relative path to the file that can be used in EPUB sections in the
+// format:
+// ../MediaFolderName/internalFilename
+//
+// The source and internal filename follow the same rules as for AddImage.
+func (e *Epub) AddMedia(source string, internalFilename string) (string, error) {
+	return e.addMedia(source, internalFilename, mediaFileFormat, MediaFolderName, e.media)
+}
+
+// AddMediaBytes adds any other media file held in memory to the EPUB and
+// returns a relative path to the file in the same format as AddMedia.
+//
+// The internal filename follows the same rules as for AddImageBytes. The media
+// type is optional if it can be determined from the filename extension.
+func (e *Epub) AddMediaBytes(data []byte, internalFilename string, mediaType string) (string, error) {
+	return addMediaBytes(data, internalFilename, mediaType, mediaFileFormat, MediaFolderName, e.media)
+}
+
+// AddMediaReader is like AddMediaBytes, but reads the media file from r.
+func (e *Epub) AddMediaReader(r io.Reader, internalFilename string, mediaType string) (string, error) {
+	data, err := readMedia(r, internalFilename)
+	if err != nil {
+		return "", err
+	}
+	return e.AddMediaBytes(data, internalFilename, mediaType)
+}
+
+// AddRemoteMedia lists a resource that stays on the web, such as an image
+// that sections show from a web server, in the manifest of the EPUB and
+// returns a relative path to the manifest entry in the same format as
+// AddMedia. Sections keep referring to the resource by its URL and need the
+// ManifestRemoteResources property (see ManifestProperties).
+//
+// The source must be an http or https URL; otherwise FileRetrievalError is
+// returned. The media type (e.g. image/png) is optional if it can be
+// determined from the extension of the URL path; otherwise
+// UnknownMediaTypeError is returned.
+func (e *Epub) AddRemoteMedia(source string, mediaType string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil || !isRemoteSource(source) {
+		return "", &FileRetrievalError{Source: source, Err: fmt.Errorf("not a remote source")}
+	}
+	if mediaType == "" {
+		mediaType = mediaTypeOf(u.Path)
+	}
+	if mediaType == "" {
+		return "", &UnknownMediaTypeError{Filename: source}
+	}
+	return e.addRemoteMedia(source, mediaType), nil
 }
 
 // AddSection adds a new section (chapter, etc) to the EPUB and returns a
@@ -214,27 +417,71 @@ func (e *Epub) AddImage(source string, imageFilename string) (string, error) {
 // than once, FilenameAlreadyUsedError will be returned. The internal filename is
 // optional; if no filename is provided, one will be generated.
 //
+// Page breaks of a print edition can be marked in the body with an element
+// that has an id and either epub:type="pagebreak" or role="doc-pagebreak",
+// such as <span epub:type="pagebreak" id="page12" aria-label="12" />. They are
+// listed in the page list of the EPUB in reading order, labelled with their
+// aria-label or title attribute, or else with their text.
+//
 // The internal path to an already-added CSS file (as returned by AddCSS) to be
 // used for the section is optional.
-func (e *Epub) AddSection(body string, sectionTitle string, internalFilename string, internalCSSPath string) (string, error) {
+//
+// Options set how the section appears in the reading order, for example
+// NonLinear for footnotes or answers that are only reached through links, and
+// add more stylesheets or other elements to its head, with Stylesheets and
+// HeadElements.
+func (e *Epub) AddSection(body string, sectionTitle string, internalFilename string, internalCSSPath string, options ...SectionOption) (string, error) {
+	return e.addSection(nil, body, sectionTitle, internalFilename, internalCSSPath, options)
+}
+
+// AddSubSection adds a section below the section with the given parent
+// filename (as returned by AddSection or AddSubSection) and returns the
+// internal filename of the subsection. Subsections follow their parent and its
+// earlier subsections in the reading order, and are nested below their parent
+// in the table of contents. Subsections may have subsections of their own.
+//
+// The body, title, internal filename, CSS path and options follow the same
+// rules as for AddSection; the internal filename must be unique among all
+// sections and subsections. If the parent section hasn't been added,
+// ParentDoesNotExistError will be returned.
+func (e *Epub) AddSubSection(parentFilename string, body string, sectionTitle string, internalFilename string, internalCSSPath string, options ...SectionOption) (string, error) {
+	parent := findSection(e.sections, parentFilename)
+	if parent == nil {
+		return "", &ParentDoesNotExistError{Filename: parentFilename}
+	}
+
+	return e.addSection(parent, body, sectionTitle, internalFilename, internalCSSPath, options)
+}
+
+// Add a section at the top level, or below parent if it isn't nil
+func (e *Epub) addSection(parent *epubSection, body string, sectionTitle string, internalFilename string, internalCSSPath string, options []SectionOption) (string, error) {
+	s, err := e.newSection(body, sectionTitle, internalFilename, internalCSSPath, options)
+	if err != nil {
+		return "", err
+	}
+
+	if parent == nil {
+		e.sections = append(e.sections, s)
+	} else {
+		parent.children = append(parent.children, s)
+	}
+
+	return s.filename, nil
+}
+
+// Create a section with a filename unique among all sections
+func (e *Epub) newSection(body string, sectionTitle string, internalFilename string, internalCSSPath string, options []SectionOption) (*epubSection, error) {
 	// Generate a filename if one isn't provided
 	if internalFilename == "" {
 		index := 1
 		for internalFilename == "" {
 			internalFilename = fmt.Sprintf(sectionFileFormat, index)
-			for _, section := range e.sections {
-				if section.filename == internalFilename {
-					internalFilename, index = "", index+1
-					break
-				}
-			}
-		}
-	} else {
-		for _, section := range e.sections {
-			if section.filename == internalFilename {
-				return "", &FilenameAlreadyUsedError{Filename: internalFilename}
+			if findSection(e.sections, internalFilename) != nil {
+				internalFilename, index = "", index+1
 			}
 		}
+	} else if findSection(e.sections, internalFilename) != nil {
+		return nil, &FilenameAlreadyUsedError{Filename: internalFilename}
 	}
 
 	x := newXhtml(body)
@@ -244,18 +491,29 @@ func (e *Epub) AddSection(body string, sectionTitle string, internalFilename str
 		x.setCSS(internalCSSPath)
 	}
 
-	s := epubSection{
+	s := &epubSection{
 		filename: internalFilename,
 		xhtml:    x,
 	}
-	e.sections = append(e.sections, s)
+	for _, option := range options {
+		option(s)
+	}
 
-	return internalFilename, nil
+	return s, nil
 }
 
-// Author returns the author of the EPUB.
+// Author returns the author of the EPUB: the first creator with the author
+// role, or the first creator if none has it.
 func (e *Epub) Author() string {
-	return e.author
+	for _, c := range e.creators {
+		if c.Role == RoleAuthor {
+			return c.Name
+		}
+	}
+	if len(e.creators) > 0 {
+		return e.creators[0].Name
+	}
+	return ""
 }
 
 // Identifier returns the unique identifier of the EPUB.
@@ -278,10 +536,11 @@ func (e *Epub) Ppd() string {
 	return e.ppd
 }
 
-// SetAuthor sets the author of the EPUB.
+// SetAuthor sets the author of the EPUB, replacing any creators added with
+// AddCreator.
 func (e *Epub) SetAuthor(author string) {
-	e.author = author
-	e.pkg.setAuthor(author)
+	e.creators = []Contributor{{Name: author, Role: RoleAuthor}}
+	e.pkg.setCreators(e.creators)
 }
 
 // SetCover sets the cover page for the EPUB using the provided image source and
@@ -293,7 +552,9 @@ func (e *Epub) SetAuthor(author string) {
 // The internal path to an already-added CSS file (as returned by AddCSS) to be
 // used for the cover is optional. If the CSS path isn't provided, default CSS
 // will be used.
-func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) {
+//
+// If the cover page cannot be added, the error is returned.
+func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) error {
 	// If a cover already exists
 	if e.cover.xhtmlFilename != "" {
 		// Remove the xhtml file
@@ -310,9 +571,7 @@ func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) {
 		// Remove the CSS
 		delete(e.css, e.cover.cssFilename)
 
-		if e.cover.cssTempFile != "" {
-			os.Remove(e.cover.cssTempFile)
-		}
+		e.cover.xhtmlFilename = ""
 	}
 
 	e.cover.imageFilename = filepath.Base(internalImagePath)
@@ -320,42 +579,14 @@ func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) {
 
 	// Use default cover stylesheet if one isn't provided
 	if internalCSSPath == "" {
-		// Create a temporary file to hold the default cover CSS
-		tempFile, err := os.CreateTemp("", tempDirPrefix)
-		if err != nil {
-			panic(fmt.Sprintf("Error creating temp file: %s", err))
-		}
-		defer func() {
-			if err := tempFile.Close(); err != nil {
-				panic(fmt.Sprintf("Error closing temp file: %s", err))
-			}
-		}()
-		e.cover.cssTempFile = tempFile.Name()
-
-		// Write the default cover CSS to the temp file
-		if _, err = tempFile.WriteString(defaultCoverCSSContent); err != nil {
-			panic(fmt.Sprintf("Error writing CSS file: %s", err))
-		}
-
-		internalCSSPath, err = e.AddCSS(e.cover.cssTempFile, defaultCoverCSSFilename)
+		var err error
+		internalCSSPath, err = e.AddCSSBytes([]byte(defaultCoverCSSContent), defaultCoverCSSFilename, "")
 		// If that doesn't work, generate a filename
 		if _, ok := err.(*FilenameAlreadyUsedError); ok {
-			coverCSSFilename := fmt.Sprintf(
-				cssFileFormat,
-				len(e.css)+1,
-				".css",
-			)
-
-			internalCSSPath, err = e.AddCSS(e.cover.cssTempFile, coverCSSFilename)
-			if _, ok := err.(*FilenameAlreadyUsedError); ok {
-				// This shouldn't cause an error
-				panic(fmt.Sprintf("Error adding default cover CSS file: %s", err))
-			}
+			internalCSSPath, err = e.AddCSSBytes([]byte(defaultCoverCSSContent), "", "")
 		}
 		if err != nil {
-			if _, ok := err.(*FilenameAlreadyUsedError); !ok {
-				panic(fmt.Sprintf("DEBUG %+v", err))
-			}
+			return err
 		}
 	}
 	e.cover.cssFilename = filepath.Base(internalCSSPath)
@@ -367,18 +598,24 @@ func (e *Epub) SetCover(internalImagePath string, internalCSSPath string) {
 	// If that doesn't work, generate a filename
 	if _, ok := err.(*FilenameAlreadyUsedError); ok {
 		coverPath, err = e.AddSection(coverBody, "", "", internalCSSPath)
-		if _, ok := err.(*FilenameAlreadyUsedError); ok {
-			// This shouldn't cause an error since we're not specifying a filename
-			panic(fmt.Sprintf("Error adding default cover XHTML file: %s", err))
-		}
+	}
+	if err != nil {
+		return err
 	}
 	e.cover.xhtmlFilename = filepath.Base(coverPath)
+
+	return nil
 }
 
 // SetIdentifier sets the unique identifier of the EPUB, such as a UUID, DOI,
 // ISBN or ISSN. If no identifier is set, a UUID will be automatically
-// generated.
+// generated; in reproducible mode, it is derived from the content of the EPUB.
 func (e *Epub) SetIdentifier(identifier string) {
+	e.setIdentifier(identifier)
+	e.autoIdentifier = false
+}
+
+func (e *Epub) setIdentifier(identifier string) {
 	e.identifier = identifier
 	e.pkg.setIdentifier(identifier)
 	e.toc.setIdentifier(identifier)
@@ -414,35 +651,80 @@ func (e *Epub) Title() string {
 	return e.title
 }
 
+// SectionTitles returns the titles of the sections and subsections in reading
+// order. The cover and sections without a title have an empty title; they are
+// not shown in the table of contents.
+func (e *Epub) SectionTitles() []string {
+	var titles []string
+	walkSections(e.sections, func(section *epubSection) {
+		title := ""
+		if section.filename != e.cover.xhtmlFilename {
+			title = section.xhtml.Title()
+		}
+		titles = append(titles, title)
+	})
+	return titles
+}
+
 // Add a media file to the EPUB and return the path relative to the EPUB section
 // files
-func addMedia(source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]string) (string, error) {
-	err := validateFileSource(source)
+func (e *Epub) addMedia(source string, internalFilename string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]*epubMedia) (string, error) {
+	if internalFilename == "" {
+		// If a filename isn't provided, use the filename from the source
+		internalFilename = filepath.Base(source)
+		// If that's already used, try to generate a unique filename
+		if _, ok := mediaMap[internalFilename]; ok {
+			internalFilename = uniqueMediaFilename(mediaFileFormat, strings.ToLower(filepath.Ext(source)), mediaMap)
+		}
+	}
+
+	if _, ok := mediaMap[internalFilename]; ok {
+		return "", &FilenameAlreadyUsedError{Filename: internalFilename}
+	}
+
+	media, err := e.retrieveSource(source)
 	if err != nil {
 		return "", &FileRetrievalError{
 			Source: source,
 			Err:    err,
 		}
 	}
+	mediaMap[internalFilename] = media
+
+	return path.Join(
+		"..",
+		mediaFolderName,
+		internalFilename,
+	), nil
+}
 
+// Add a media file held in memory to the EPUB and return the path relative to
+// the EPUB section files
+func addMediaBytes(data []byte, internalFilename string, mediaType string, mediaFileFormat string, mediaFolderName string, mediaMap map[string]*epubMedia) (string, error) {
 	if internalFilename == "" {
-		// If a filename isn't provided, use the filename from the source
-		internalFilename = filepath.Base(source)
-		// If that's already used, try to generate a unique filename
-		if _, ok := mediaMap[internalFilename]; ok {
-			internalFilename = fmt.Sprintf(
-				mediaFileFormat,
-				len(mediaMap)+1,
-				strings.ToLower(filepath.Ext(source)),
-			)
-		}
+		internalFilename = uniqueMediaFilename(mediaFileFormat, extensionOf(mediaType), mediaMap)
 	}
 
 	if _, ok := mediaMap[internalFilename]; ok {
 		return "", &FilenameAlreadyUsedError{Filename: internalFilename}
 	}
 
-	mediaMap[internalFilename] = source
+	if mediaType == "" {
+		mediaType = mediaTypeOf(internalFilename)
+	}
+	if mediaType == "" {
+		return "", &UnknownMediaTypeError{Filename: internalFilename}
+	}
+
+	// Media without data is read from its source, which isn't a file here
+	if data == nil {
+		data = []byte{}
+	}
+	mediaMap[internalFilename] = &epubMedia{
+		source:    internalFilename,
+		data:      data,
+		mediaType: mediaType,
+	}
 
 	return path.Join(
 		"..",
@@ -451,34 +733,37 @@ func addMedia(source string, internalFilename string, mediaFileFormat string, me
 	), nil
 }
 
-func validateFileSource(source string) error {
-	u, err := url.Parse(source)
-	if err != nil {
-		return err
+// Add a media file that stays on the web, such as a remote resource of a
+// section read from an EPUB, and return its path relative to the EPUB section
+// files. Sections refer to it by its URL.
+func (e *Epub) addRemoteMedia(source string, mediaType string) string {
+	filename := uniqueMediaFilename(mediaFileFormat, extensionOf(mediaType), e.media)
+	e.media[filename] = &epubMedia{
+		source:    source,
+		mediaType: mediaType,
+		remote:    true,
 	}
 
-	var r io.ReadCloser
-	var resp *http.Response
-	// If it's a URL
-	if u.Scheme == "http" || u.Scheme == "https" {
-		resp, err = http.Get(source)
-		if err != nil {
-			return err
-		}
-		r = resp.Body
+	return path.Join("..", MediaFolderName, filename)
+}
 
-		// Otherwise, assume it's a local file
-	} else {
-		r, err = os.Open(source)
+// Generate a filename that isn't used yet by any media file of the same kind
+func uniqueMediaFilename(mediaFileFormat string, ext string, mediaMap map[string]*epubMedia) string {
+	index := len(mediaMap) + 1
+	for {
+		filename := fmt.Sprintf(mediaFileFormat, index, ext)
+		if _, ok := mediaMap[filename]; !ok {
+			return filename
+		}
+		index++
 	}
+}
+
+// Read a media file given as an io.Reader
+func readMedia(r io.Reader, internalFilename string) ([]byte, error) {
+	data, err := io.ReadAll(r)
 	if err != nil {
-		return err
+		return nil, &FileRetrievalError{Source: internalFilename, Err: err}
 	}
-	defer func() {
-		if err := r.Close(); err != nil {
-			panic(err)
-		}
-	}()
-
-	return nil
+	return data, nil
 }
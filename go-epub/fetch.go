@@ -0,0 +1,144 @@
+package epub
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Timeout of the default fetcher used for remote media sources
+const defaultFetchTimeout = 2 * time.Minute
+
+// Fetcher retrieves remote media sources (http and https URLs) added with
+// AddCSS, AddFont, AddImage or AddMedia. Remote sources are fetched once, when
+// they are added, and kept in memory until the EPUB is written.
+type Fetcher interface {
+	Fetch(url string) (io.ReadCloser, error)
+}
+
+// FetcherFunc allows an ordinary function to be used as a Fetcher.
+type FetcherFunc func(url string) (io.ReadCloser, error)
+
+// Fetch calls f(url).
+func (f FetcherFunc) Fetch(url string) (io.ReadCloser, error) {
+	return f(url)
+}
+
+// HTTPFetcher is a Fetcher that retrieves sources with an http.Client and
+// treats any response other than 200 OK as an error.
+type HTTPFetcher struct {
+	Client *http.Client // The client to use; http.DefaultClient if nil
+	Header http.Header  // Extra headers sent with every request
+}
+
+// NewHTTPFetcher returns an HTTPFetcher using a client with the given timeout.
+func NewHTTPFetcher(timeout time.Duration) *HTTPFetcher {
+	return &HTTPFetcher{
+		Client: &http.Client{Timeout: timeout},
+	}
+}
+
+// Fetch retrieves the source at url.
+func (f *HTTPFetcher) Fetch(url string) (io.ReadCloser, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range f.Header {
+		req.Header[key] = values
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// SetFetcher sets the Fetcher used to retrieve remote media sources. By
+// default, sources are retrieved over HTTP with a timeout of two minutes.
+func (e *Epub) SetFetcher(f Fetcher) {
+	e.fetcher = f
+}
+
+// SetFS sets the file system local media sources are opened from. Sources are
+// then interpreted as fs.FS paths (slash-separated and unrooted). By default,
+// sources are paths on the local file system.
+func (e *Epub) SetFS(fsys fs.FS) {
+	e.fsys = fsys
+}
+
+// Whether the media source is a remote URL to be retrieved by the fetcher
+func isRemoteSource(source string) bool {
+	u, err := url.Parse(source)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https")
+}
+
+// Fetch a remote media source
+func (e *Epub) fetchSource(source string) ([]byte, error) {
+	if e.fetcher == nil {
+		return nil, fmt.Errorf("no fetcher set for remote source")
+	}
+	r, err := e.fetcher.Fetch(source)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// Open a local media source
+func (e *Epub) openSource(source string) (io.ReadCloser, error) {
+	if e.fsys == nil {
+		return os.Open(source)
+	}
+	return e.fsys.Open(strings.TrimPrefix(filepath.ToSlash(source), "/"))
+}
+
+// Retrieve a media source: remote sources are fetched and kept in memory,
+// local sources are only checked to exist
+func (e *Epub) retrieveSource(source string) (*epubMedia, error) {
+	media := &epubMedia{source: source}
+
+	if isRemoteSource(source) {
+		data, err := e.fetchSource(source)
+		if err != nil {
+			return nil, err
+		}
+		media.data = data
+		return media, nil
+	}
+
+	r, err := e.openSource(source)
+	if err != nil {
+		return nil, err
+	}
+	r.Close()
+
+	return media, nil
+}
+
+// Open a media file, from memory if it is held there
+func (e *Epub) openMedia(media *epubMedia) (io.ReadCloser, error) {
+	if media.data != nil {
+		return io.NopCloser(bytes.NewReader(media.data)), nil
+	}
+	return e.openSource(media.source)
+}
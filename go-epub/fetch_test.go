@@ -0,0 +1,55 @@
+package epub
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestAddEmptyBytes(t *testing.T) {
+	// Files named like the media in the working directory must not be read
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	for _, name := range []string{"empty.css", "empty.ttf", "empty.png", "empty.txt", "reader.txt"} {
+		if err := os.WriteFile(name, []byte("unrelated"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := NewEpub("Empty")
+	var refs []string
+	for _, add := range []func() (string, error){
+		func() (string, error) { return e.AddCSSBytes(nil, "empty.css", "") },
+		func() (string, error) { return e.AddFontBytes(nil, "empty.ttf", "") },
+		func() (string, error) { return e.AddImageBytes(nil, "empty.png", "") },
+		func() (string, error) { return e.AddMediaBytes(nil, "empty.txt", "text/plain") },
+		func() (string, error) { return e.AddMediaReader(bytes.NewReader(nil), "reader.txt", "text/plain") },
+	} {
+		ref, err := add()
+		if err != nil {
+			t.Fatal(err)
+		}
+		refs = append(refs, ref)
+	}
+
+	files := zipFiles(t, writeTestEpub(t, e))
+	for _, ref := range refs {
+		name := path.Join("EPUB", path.Dir(ref)[len("../"):], path.Base(ref))
+		data, ok := files[name]
+		if !ok {
+			t.Errorf("no file %s", name)
+			continue
+		}
+		if len(data) != 0 {
+			t.Errorf("%s holds %q, want it empty", name, data)
+		}
+	}
+}
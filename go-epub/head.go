@@ -0,0 +1,57 @@
+package epub
+
+// Stylesheets links the section to more already-added CSS files (as returned
+// by AddCSS), after the CSS file given to AddSection. Later stylesheets take
+// precedence over earlier ones.
+func Stylesheets(internalCSSPaths ...string) SectionOption {
+	return func(s *epubSection) {
+		for _, internalCSSPath := range internalCSSPaths {
+			if internalCSSPath != "" {
+				s.xhtml.addCSS(internalCSSPath)
+			}
+		}
+	}
+}
+
+// HeadElements adds elements to the <head> of the section, after its title and
+// stylesheets, such as <meta name="color-scheme" content="light dark" />, a
+// <style> element or a <link> to an alternate stylesheet. Each element must be
+// valid XHTML; it will not be validated.
+func HeadElements(elements ...string) SectionOption {
+	return func(s *epubSection) {
+		for _, element := range elements {
+			s.xhtml.addHeadElement(element)
+		}
+	}
+}
+
+// SetDefaultCSS sets an already-added CSS file (as returned by AddCSS) that
+// every section except the cover page links to when the EPUB is written, before
+// its own stylesheets, so it need not be given to each section. An empty path
+// removes the default stylesheet.
+func (e *Epub) SetDefaultCSS(internalCSSPath string) {
+	e.defaultCSS = internalCSSPath
+}
+
+// DefaultCSS returns the stylesheet set with SetDefaultCSS.
+func (e *Epub) DefaultCSS() string {
+	return e.defaultCSS
+}
+
+// SetPageStylesheets links the cover page and the TOC page, which are
+// generated rather than added with AddSection, to more already-added CSS files
+// (as returned by AddCSS), after their own stylesheet, for example to use the
+// fonts of the sections. Calling it again replaces the stylesheets.
+func (e *Epub) SetPageStylesheets(internalCSSPaths ...string) {
+	e.pageCSS = nil
+	for _, internalCSSPath := range internalCSSPaths {
+		if internalCSSPath != "" {
+			e.pageCSS = append(e.pageCSS, internalCSSPath)
+		}
+	}
+}
+
+// PageStylesheets returns the stylesheets set with SetPageStylesheets.
+func (e *Epub) PageStylesheets() []string {
+	return e.pageCSS
+}
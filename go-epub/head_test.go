@@ -0,0 +1,149 @@
+package epub
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var testLinkRegexp = regexp.MustCompile(`<link rel="stylesheet" type="text/css" href="([^"]*)"`)
+
+// Return the stylesheets linked from the head of a written XHTML file
+func linkedCSS(t *testing.T, files map[string][]byte, name string) []string {
+	t.Helper()
+	data, ok := files[name]
+	if !ok {
+		t.Fatalf("no file %s", name)
+	}
+	var paths []string
+	for _, m := range testLinkRegexp.FindAllSubmatch(data, -1) {
+		paths = append(paths, string(m[1]))
+	}
+	return paths
+}
+
+func TestPageStylesheets(t *testing.T) {
+	e := newSectionBook(t)
+	fonts, err := e.AddCSSBytes([]byte(`body { font-family: serif; }`), "fonts.css", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "cover.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetTocPage(""); err != nil {
+		t.Fatal(err)
+	}
+	e.SetPageStylesheets(fonts, "")
+
+	files := zipFiles(t, writeTestEpub(t, e))
+	for name, want := range map[string][]string{
+		"EPUB/xhtml/cover.xhtml": {"../css/cover.css", "../css/fonts.css"},
+		"EPUB/xhtml/toc.xhtml":   {"../css/toc.css", "../css/fonts.css"},
+		"EPUB/xhtml/a.xhtml":     nil,
+	} {
+		if got := linkedCSS(t, files, name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s links %q, want %q", name, got, want)
+		}
+	}
+
+	// the cover page of a book read back links the stylesheet once
+	e = readTestEpub(t, writeTestEpub(t, e))
+	files = zipFiles(t, writeTestEpub(t, e))
+	if got := linkedCSS(t, files, "EPUB/xhtml/cover.xhtml"); len(got) != 2 {
+		t.Errorf("cover page of the read book links %q", got)
+	}
+}
+
+func TestStylesheets(t *testing.T) {
+	e := newSectionBook(t)
+	var css []string
+	for _, name := range []string{"base.css", "one.css", "two.css"} {
+		path, err := e.AddCSSBytes([]byte(`p { margin: 0; }`), name, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		css = append(css, path)
+	}
+	base, one, two := css[0], css[1], css[2]
+	image, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "cover.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	e.SetDefaultCSS(base)
+	if e.DefaultCSS() != base {
+		t.Errorf("default stylesheet %q, want %q", e.DefaultCSS(), base)
+	}
+
+	if _, err := e.AddSection("<p>S</p>", "S", "s.xhtml", one, Stylesheets(two, "")); err != nil {
+		t.Fatal(err)
+	}
+	// a section linking the default stylesheet itself links it once
+	if _, err := e.AddSection("<p>D</p>", "D", "d.xhtml", one, Stylesheets(base)); err != nil {
+		t.Fatal(err)
+	}
+
+	files := zipFiles(t, writeTestEpub(t, e))
+	for name, want := range map[string][]string{
+		"EPUB/xhtml/s.xhtml": {"../css/base.css", "../css/one.css", "../css/two.css"},
+		"EPUB/xhtml/d.xhtml": {"../css/one.css", "../css/base.css"},
+		"EPUB/xhtml/a.xhtml": {"../css/base.css"},
+		// the cover page keeps to its own stylesheet
+		"EPUB/xhtml/cover.xhtml": {"../css/cover.css"},
+	} {
+		if got := linkedCSS(t, files, name); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s links %q, want %q", name, got, want)
+		}
+	}
+
+	// the stylesheets of the sections are left as they were
+	if got := findSection(e.sections, "s.xhtml").xhtml.css(); !reflect.DeepEqual(got, []string{one, two}) {
+		t.Errorf("section links %q after writing", got)
+	}
+
+	e.SetDefaultCSS("")
+	files = zipFiles(t, writeTestEpub(t, e))
+	if got := linkedCSS(t, files, "EPUB/xhtml/a.xhtml"); got != nil {
+		t.Errorf("a.xhtml links %q after removing the default stylesheet", got)
+	}
+}
+
+func TestHeadElements(t *testing.T) {
+	e := newSectionBook(t)
+	css, err := e.AddCSSBytes([]byte(`p { margin: 0; }`), "s.css", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection("<p>H</p>", "H", "h.xhtml", css, HeadElements(`<meta name="color-scheme" content="light dark" />`, `  <style>p { color: red; }</style>  `)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection("<p>E</p>", "E", "e.xhtml", "", HeadElements(`<link rel="alternate stylesheet" epub:type="x" href="../css/s.css" />`)); err != nil {
+		t.Fatal(err)
+	}
+
+	files := zipFiles(t, writeTestEpub(t, e))
+	page := string(files["EPUB/xhtml/h.xhtml"])
+	// after the title and the stylesheets, in order
+	title := strings.Index(page, "<title>H</title>")
+	link := strings.Index(page, `href="../css/s.css"`)
+	meta := strings.Index(page, `<meta name="color-scheme" content="light dark" />`)
+	style := strings.Index(page, `<style>p { color: red; }</style>`)
+	if title < 0 || link < title || meta < link || style < meta {
+		t.Errorf("head elements out of place:\n%s", page)
+	}
+	if strings.Contains(page, "xmlns:epub") {
+		t.Errorf("epub namespace declared without epub: attributes:\n%s", page)
+	}
+
+	if page := string(files["EPUB/xhtml/e.xhtml"]); !strings.Contains(page, `xmlns:epub="http://www.idpf.org/2007/ops"`) {
+		t.Errorf("no epub namespace for a head element with epub: attributes:\n%s", page)
+	}
+}
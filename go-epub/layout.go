@@ -0,0 +1,154 @@
+package epub
+
+import (
+	"image"
+	// Register the formats whose size is needed for the cover page viewport
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"strconv"
+	"strings"
+)
+
+// Layouts of the EPUB, set with SetLayout.
+const (
+	LayoutReflowable   = "reflowable"    // The content reflows to fit the screen
+	LayoutPrePaginated = "pre-paginated" // Each section is a page of a fixed size
+)
+
+// Spreads of a pre-paginated EPUB, set with SetSpread.
+const (
+	SpreadAuto      = "auto"      // The reading system decides
+	SpreadNone      = "none"      // Pages are always shown one at a time
+	SpreadLandscape = "landscape" // Two pages are shown side by side in landscape orientation
+	SpreadBoth      = "both"      // Two pages are shown side by side in any orientation
+)
+
+// Sides of the spread a page of a pre-paginated EPUB is shown on, set with
+// SetSectionPageSpread.
+const (
+	PageSpreadLeft   = "page-spread-left"
+	PageSpreadRight  = "page-spread-right"
+	PageSpreadCenter = "rendition:page-spread-center" // The page takes up the whole spread
+)
+
+const (
+	pkgLayoutProperty = "rendition:layout"
+	pkgSpreadProperty = "rendition:spread"
+	// Properties of spine items overriding the layout of the EPUB
+	pkgLayoutPrePaginatedProperty = "rendition:layout-pre-paginated"
+	pkgLayoutReflowableProperty   = "rendition:layout-reflowable"
+	xhtmlViewportFormat           = "width=%d, height=%d"
+	xhtmlViewportName             = "viewport"
+)
+
+// SetLayout sets whether the content of the EPUB reflows to fit the screen
+// (LayoutReflowable, the default) or is laid out in pages of a fixed size
+// (LayoutPrePaginated), as comics and scanned pages need. Each section of a
+// pre-paginated EPUB is one page, whose size is set with SetSectionViewport.
+//
+// The cover page of a pre-paginated EPUB takes the size of the cover image
+// unless its viewport is set, and the TOC page added with SetTocPage reflows.
+func (e *Epub) SetLayout(layout string) {
+	e.layout = layout
+	e.pkg.setLayout(layout)
+}
+
+// Layout returns the layout set with SetLayout.
+func (e *Epub) Layout() string {
+	return e.layout
+}
+
+// SetSpread sets whether reading systems show the pages of a pre-paginated
+// EPUB two at a time, side by side, as in a printed book. It is one of
+// SpreadAuto, SpreadNone, SpreadLandscape or SpreadBoth; if it isn't set, the
+// reading system decides.
+func (e *Epub) SetSpread(spread string) {
+	e.spread = spread
+	e.pkg.setSpread(spread)
+}
+
+// Spread returns the spread set with SetSpread.
+func (e *Epub) Spread() string {
+	return e.spread
+}
+
+// SetSectionViewport sets the size in CSS pixels of the section with the given
+// filename, which is one page of a pre-paginated EPUB. It is written as a
+// viewport <meta> element in the head of the section. A width or height of 0
+// removes the viewport.
+//
+// If there is no section with the given filename, SectionDoesNotExistError
+// will be returned.
+func (e *Epub) SetSectionViewport(filename string, width int, height int) error {
+	s := findSection(e.sections, filename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: filename}
+	}
+	s.xhtml.setViewport(width, height)
+
+	return nil
+}
+
+// SetSectionPageSpread sets the side of the spread the section with the given
+// filename is shown on when the pages of a pre-paginated EPUB are shown side
+// by side: PageSpreadLeft, PageSpreadRight or PageSpreadCenter. An empty spread
+// lets the page follow the previous one.
+//
+// If there is no section with the given filename, SectionDoesNotExistError
+// will be returned.
+func (e *Epub) SetSectionPageSpread(filename string, spread string) error {
+	s := findSection(e.sections, filename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: filename}
+	}
+	s.pageSpread = spread
+
+	return nil
+}
+
+// Set the viewport of the cover page of a pre-paginated EPUB to the size of
+// the cover image, if it has none and the size can be read
+func (e *Epub) setCoverViewport(section *epubSection) {
+	if e.layout != LayoutPrePaginated || section.xhtml.xml.Head.Viewport != nil {
+		return
+	}
+	media, ok := e.images[e.cover.imageFilename]
+	if !ok {
+		return
+	}
+	r, err := e.openMedia(media)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return
+	}
+	section.xhtml.setViewport(config.Width, config.Height)
+}
+
+// Return the width and height of a viewport <meta> element, or zeros if they
+// aren't set
+// Ex: width=1200, height=1600
+func parseViewport(content string) (width int, height int) {
+	for _, field := range strings.FieldsFunc(content, func(r rune) bool { return r == ',' || r == ';' }) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(kv[1]))
+		if err != nil {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "width":
+			width = n
+		case "height":
+			height = n
+		}
+	}
+	return
+}
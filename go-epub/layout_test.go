@@ -0,0 +1,178 @@
+package epub
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// Return a PNG image of the given size
+func testPNG(t *testing.T, width int, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// Return the content of the viewport <meta> element of a section, or "" if it
+// has none
+func sectionViewport(e *Epub, filename string) string {
+	s := findSection(e.sections, filename)
+	if s == nil || s.xhtml.xml.Head.Viewport == nil {
+		return ""
+	}
+	return s.xhtml.xml.Head.Viewport.Content
+}
+
+func TestLayout(t *testing.T) {
+	e := newSectionBook(t)
+	e.SetLayout(LayoutPrePaginated)
+	e.SetSpread(SpreadLandscape)
+	if err := e.SetSectionViewport("a.xhtml", 600, 800); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetSectionViewport("b.xhtml", 1200, 1600); err != nil {
+		t.Fatal(err)
+	}
+	// a width or height of 0 removes the viewport
+	if err := e.SetSectionViewport("b.xhtml", 0, 1600); err != nil {
+		t.Fatal(err)
+	}
+	for filename, spread := range map[string]string{"a.xhtml": PageSpreadLeft, "a1.xhtml": PageSpreadRight, "c.xhtml": PageSpreadCenter} {
+		if err := e.SetSectionPageSpread(filename, spread); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := writeTestEpub(t, e)
+	files := zipFiles(t, data)
+	opf := string(files["EPUB/package.opf"])
+	for _, want := range []string{
+		`<meta property="rendition:layout">pre-paginated</meta>`,
+		`<meta property="rendition:spread">landscape</meta>`,
+		`<itemref idref="a.xhtml" properties="page-spread-left"></itemref>`,
+		`<itemref idref="a1.xhtml" properties="page-spread-right"></itemref>`,
+		`<itemref idref="a2.xhtml"></itemref>`,
+		`<itemref idref="c.xhtml" properties="rendition:page-spread-center"></itemref>`,
+	} {
+		if !strings.Contains(opf, want) {
+			t.Errorf("package file has no %s:\n%s", want, opf)
+		}
+	}
+	if page := string(files["EPUB/xhtml/a.xhtml"]); !strings.Contains(page, `<meta name="viewport" content="width=600, height=800"></meta>`) {
+		t.Errorf("no viewport:\n%s", page)
+	}
+	if page := string(files["EPUB/xhtml/b.xhtml"]); strings.Contains(page, "viewport") {
+		t.Errorf("viewport not removed:\n%s", page)
+	}
+
+	// all of it is read back
+	r := readTestEpub(t, data)
+	if r.Layout() != LayoutPrePaginated || r.Spread() != SpreadLandscape {
+		t.Errorf("read layout %q and spread %q", r.Layout(), r.Spread())
+	}
+	if got := sectionViewport(r, "a.xhtml"); got != "width=600, height=800" {
+		t.Errorf("read viewport %q", got)
+	}
+	if got := sectionViewport(r, "b.xhtml"); got != "" {
+		t.Errorf("read viewport %q of a section without one", got)
+	}
+	for filename, want := range map[string]string{"a.xhtml": PageSpreadLeft, "a1.xhtml": PageSpreadRight, "a2.xhtml": "", "c.xhtml": PageSpreadCenter} {
+		if s := findSection(r.sections, filename); s == nil || s.pageSpread != want {
+			t.Errorf("%s: read page spread %v, want %q", filename, s, want)
+		}
+	}
+
+	var notExist *SectionDoesNotExistError
+	if err := e.SetSectionViewport("missing.xhtml", 1, 1); !errors.As(err, &notExist) {
+		t.Errorf("got %v, want SectionDoesNotExistError", err)
+	}
+	if err := e.SetSectionPageSpread("missing.xhtml", PageSpreadLeft); !errors.As(err, &notExist) {
+		t.Errorf("got %v, want SectionDoesNotExistError", err)
+	}
+}
+
+func TestReflowableLayout(t *testing.T) {
+	opf := string(zipFiles(t, writeTestEpub(t, newSectionBook(t)))["EPUB/package.opf"])
+	if strings.Contains(opf, "rendition:") {
+		t.Errorf("rendition properties in a reflowable EPUB:\n%s", opf)
+	}
+}
+
+func TestCoverViewport(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		layout   string
+		viewport [2]int
+		want     string
+	}{
+		{"pre-paginated", LayoutPrePaginated, [2]int{}, "width=30, height=50"},
+		{"viewport set", LayoutPrePaginated, [2]int{300, 500}, "width=300, height=500"},
+		{"reflowable", LayoutReflowable, [2]int{}, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newSectionBook(t)
+			e.SetLayout(tt.layout)
+			image, err := e.AddImageBytes(testPNG(t, 30, 50), "cover.png", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := e.SetCover(image, ""); err != nil {
+				t.Fatal(err)
+			}
+			if tt.viewport[0] > 0 {
+				if err := e.SetSectionViewport(e.cover.xhtmlFilename, tt.viewport[0], tt.viewport[1]); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			page := string(zipFiles(t, writeTestEpub(t, e))["EPUB/xhtml/"+e.cover.xhtmlFilename])
+			if tt.want == "" {
+				if strings.Contains(page, "viewport") {
+					t.Errorf("viewport on the cover page:\n%s", page)
+				}
+				return
+			}
+			if !strings.Contains(page, `<meta name="viewport" content="`+tt.want+`">`) {
+				t.Errorf("cover page has no viewport %s:\n%s", tt.want, page)
+			}
+		})
+	}
+
+	// without a readable size, the cover page has no viewport
+	e := newSectionBook(t)
+	e.SetLayout(LayoutPrePaginated)
+	image, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "cover.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	if page := string(zipFiles(t, writeTestEpub(t, e))["EPUB/xhtml/"+e.cover.xhtmlFilename]); strings.Contains(page, "viewport") {
+		t.Errorf("viewport on the cover page of a truncated image:\n%s", page)
+	}
+}
+
+func TestParseViewport(t *testing.T) {
+	for _, tt := range []struct {
+		content       string
+		width, height int
+	}{
+		{"width=1200, height=1600", 1200, 1600},
+		{"height = 20; width = 10", 10, 20},
+		{"width=device-width, initial-scale=1", 0, 0},
+		{"width=600", 600, 0},
+		{"", 0, 0},
+	} {
+		width, height := parseViewport(tt.content)
+		if width != tt.width || height != tt.height {
+			t.Errorf("parseViewport(%q) = %d, %d, want %d, %d", tt.content, width, height, tt.width, tt.height)
+		}
+	}
+}
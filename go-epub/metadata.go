@@ -0,0 +1,214 @@
+package epub
+
+// MARC relator codes for common roles of creators and contributors. Any other
+// code from https://id.loc.gov/vocabulary/relators can be used as well.
+const (
+	RoleAuthor      = "aut"
+	RoleEditor      = "edt"
+	RoleIllustrator = "ill"
+	RoleTranslator  = "trl"
+	RoleNarrator    = "nrt"
+	RolePublisher   = "pbl"
+)
+
+// Types of collections an EPUB can belong to.
+const (
+	CollectionSeries = "series" // A sequence of related works
+	CollectionSet    = "set"    // A finite set of works forming a single unit
+)
+
+// Identifier schemes that are written as ONIX code list 5 identifier types.
+// Other schemes, such as URL or UUID, are written as they are.
+var onixIdentifierTypes = map[string]string{
+	"ISBN-10": "02",
+	"GTIN-13": "03",
+	"DOI":     "06",
+	"LCCN":    "13",
+	"ISBN":    "15",
+	"ISBN-13": "15",
+	"URN":     "22",
+}
+
+// Contributor is a person or organisation responsible for the content of the
+// EPUB, such as an author, editor or translator.
+type Contributor struct {
+	Name   string // Ex: Hingle McCringleberry
+	FileAs string // Name used for sorting, ex: McCringleberry, Hingle
+	Role   string // MARC relator code, ex: RoleAuthor
+}
+
+// Identifier is an identifier of the EPUB added with AddIdentifier.
+type Identifier struct {
+	Identifier string // Ex: 9780000000000
+	Scheme     string // Ex: ISBN
+}
+
+// Collection is a collection the EPUB belongs to, such as a series.
+type Collection struct {
+	Name     string // Ex: Monthly Reading
+	Type     string // CollectionSeries, CollectionSet or empty
+	Position string // Position of the EPUB in the collection, ex: 3
+}
+
+// AddCreator adds a creator of the EPUB, such as an author or illustrator.
+// Creators are listed in the order they were added; the first one is the
+// primary creator.
+func (e *Epub) AddCreator(creator Contributor) {
+	e.creators = append(e.creators, creator)
+	e.pkg.setCreators(e.creators)
+}
+
+// Creators returns the creators of the EPUB.
+func (e *Epub) Creators() []Contributor {
+	return append([]Contributor(nil), e.creators...)
+}
+
+// AddContributor adds a contributor to the EPUB whose role is secondary to
+// that of the creators, such as an editor or translator.
+func (e *Epub) AddContributor(contributor Contributor) {
+	e.contributors = append(e.contributors, contributor)
+	e.pkg.setContributors(e.contributors)
+}
+
+// Contributors returns the contributors of the EPUB.
+func (e *Epub) Contributors() []Contributor {
+	return append([]Contributor(nil), e.contributors...)
+}
+
+// AddIdentifier adds an identifier of the EPUB in addition to the unique
+// identifier set with SetIdentifier, such as an ISBN or the URL of the
+// original publication. The scheme (e.g. ISBN, DOI, URL or UUID) is optional.
+func (e *Epub) AddIdentifier(identifier string, scheme string) {
+	e.identifiers = append(e.identifiers, Identifier{Identifier: identifier, Scheme: scheme})
+	e.pkg.addIdentifier(identifier, scheme)
+}
+
+// Identifiers returns the identifiers added with AddIdentifier.
+func (e *Epub) Identifiers() []Identifier {
+	return append([]Identifier(nil), e.identifiers...)
+}
+
+// AddSubject adds a subject, keyword or tag describing the topic of the EPUB.
+func (e *Epub) AddSubject(subject string) {
+	e.subjects = append(e.subjects, subject)
+	e.pkg.setSubjects(e.subjects)
+}
+
+// Subjects returns the subjects of the EPUB.
+func (e *Epub) Subjects() []string {
+	return append([]string(nil), e.subjects...)
+}
+
+// SetDate sets the publication date of the EPUB, in the format YYYY, YYYY-MM,
+// YYYY-MM-DD or a full timestamp such as 2011-01-01T12:00:00Z.
+func (e *Epub) SetDate(date string) {
+	e.date = date
+	e.pkg.setDate(date)
+}
+
+// Date returns the publication date of the EPUB.
+func (e *Epub) Date() string {
+	return e.date
+}
+
+// SetPublisher sets the publisher of the EPUB.
+func (e *Epub) SetPublisher(publisher string) {
+	e.publisher = publisher
+	e.pkg.setPublisher(publisher)
+}
+
+// Publisher returns the publisher of the EPUB.
+func (e *Epub) Publisher() string {
+	return e.publisher
+}
+
+// SetRights sets the copyright or license statement of the EPUB.
+func (e *Epub) SetRights(rights string) {
+	e.rights = rights
+	e.pkg.setRights(rights)
+}
+
+// Rights returns the copyright or license statement of the EPUB.
+func (e *Epub) Rights() string {
+	return e.rights
+}
+
+// SetSource sets the source the EPUB was derived from, such as the URL or
+// ISBN of the original publication.
+func (e *Epub) SetSource(source string) {
+	e.source = source
+	e.pkg.setSource(source)
+}
+
+// Source returns the source the EPUB was derived from.
+func (e *Epub) Source() string {
+	return e.source
+}
+
+// SetSubtitle sets the subtitle of the EPUB, which is added as a second title
+// after the main title.
+func (e *Epub) SetSubtitle(subtitle string) {
+	e.subtitle = subtitle
+	e.pkg.setSubtitle(subtitle)
+}
+
+// Subtitle returns the subtitle of the EPUB.
+func (e *Epub) Subtitle() string {
+	return e.subtitle
+}
+
+// SetTitleFileAs sets the form of the title used for sorting, e.g. "Hobbit,
+// The" for "The Hobbit".
+func (e *Epub) SetTitleFileAs(fileAs string) {
+	e.titleFileAs = fileAs
+	e.pkg.setTitleFileAs(fileAs)
+}
+
+// TitleFileAs returns the form of the title used for sorting.
+func (e *Epub) TitleFileAs() string {
+	return e.titleFileAs
+}
+
+// AddCollection adds a collection the EPUB belongs to.
+func (e *Epub) AddCollection(collection Collection) {
+	e.collections = append(e.collections, collection)
+	e.pkg.setCollections(e.collections)
+}
+
+// Collections returns the collections the EPUB belongs to.
+func (e *Epub) Collections() []Collection {
+	return append([]Collection(nil), e.collections...)
+}
+
+// SetSeries sets the series the EPUB belongs to and its position in the series
+// (e.g. 3 or 2.5), replacing any series added with AddCollection. The first
+// series is also written as Calibre series metadata for older reading systems.
+// If the name is empty, the EPUB no longer belongs to a series.
+func (e *Epub) SetSeries(name string, position string) {
+	var collections []Collection
+	for _, c := range e.collections {
+		if c.Type != CollectionSeries {
+			collections = append(collections, c)
+		}
+	}
+	if name != "" {
+		collections = append(collections, Collection{
+			Name:     name,
+			Type:     CollectionSeries,
+			Position: position,
+		})
+	}
+	e.collections = collections
+	e.pkg.setCollections(e.collections)
+}
+
+// Series returns the name of the first series the EPUB belongs to and its
+// position in the series.
+func (e *Epub) Series() (name string, position string) {
+	for _, c := range e.collections {
+		if c.Type == CollectionSeries {
+			return c.Name, c.Position
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,180 @@
+package epub
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	encryptionFilename = "encryption.xml"
+	xmlnsEnc           = "http://www.w3.org/2001/04/xmlenc#"
+
+	// The IDPF font obfuscation algorithm, which XORs the first 1040 bytes of
+	// a font with the SHA-1 hash of the unique identifier
+	//
+	// Spec: https://www.w3.org/TR/epub-33/#sec-font-obfuscation
+	obfuscationAlgorithmIDPF = "http://www.idpf.org/2008/embedding"
+	obfuscationLengthIDPF    = 1040
+
+	// The older Adobe algorithm, which XORs the first 1024 bytes of a font with
+	// the 16 bytes of the UUID in the unique identifier. It is only supported
+	// when reading an EPUB.
+	obfuscationAlgorithmAdobe = "http://ns.adobe.com/pdf/enc#RC"
+	obfuscationLengthAdobe    = 1024
+)
+
+// UnsupportedEncryptionError is wrapped in the UnableToReadEpubError thrown by
+// Open or Read if a file of the EPUB is encrypted with an algorithm other than
+// font obfuscation, such as DRM.
+type UnsupportedEncryptionError struct {
+	Filename  string // The encrypted file inside the EPUB
+	Algorithm string // The encryption algorithm of the file
+}
+
+func (e *UnsupportedEncryptionError) Error() string {
+	return fmt.Sprintf("Unsupported encryption of %s: %s", e.Filename, e.Algorithm)
+}
+
+// The encryption file (META-INF/encryption.xml), which lists the obfuscated
+// fonts
+//
+// Spec: https://www.w3.org/TR/epub-33/#sec-container-metainf-encryption.xml
+type encryptionRoot struct {
+	XMLName       xml.Name                  `xml:"urn:oasis:names:tc:opendocument:xmlns:container encryption"`
+	XmlnsEnc      string                    `xml:"xmlns:enc,attr"`
+	EncryptedData []encryptionEncryptedData `xml:"enc:EncryptedData"`
+}
+
+type encryptionEncryptedData struct {
+	EncryptionMethod struct {
+		Algorithm string `xml:"Algorithm,attr"`
+	} `xml:"enc:EncryptionMethod"`
+	CipherReference struct {
+		URI string `xml:"URI,attr"`
+	} `xml:"enc:CipherData>enc:CipherReference"`
+}
+
+// The encryption file, as read by Read
+type readEncryption struct {
+	EncryptedData []struct {
+		EncryptionMethod struct {
+			Algorithm string `xml:"Algorithm,attr"`
+		} `xml:"EncryptionMethod"`
+		CipherReference struct {
+			URI string `xml:"URI,attr"`
+		} `xml:"CipherData>CipherReference"`
+	} `xml:"EncryptedData"`
+}
+
+// SetFontObfuscation sets whether the fonts of the EPUB are obfuscated when it
+// is written, as the licenses of many fonts require. Obfuscated fonts are
+// listed in META-INF/encryption.xml and can only be used by reading systems
+// once the EPUB's unique identifier is known, so they cannot easily be
+// extracted from the EPUB.
+//
+// Fonts are obfuscated with the IDPF font obfuscation algorithm, keyed on the
+// unique identifier set with SetIdentifier.
+func (e *Epub) SetFontObfuscation(obfuscate bool) {
+	e.fontObfuscation = obfuscate
+}
+
+// FontObfuscation returns whether the fonts of the EPUB are obfuscated when it
+// is written.
+func (e *Epub) FontObfuscation() bool {
+	return e.fontObfuscation
+}
+
+// Write the encryption file listing the obfuscated fonts to the EPUB
+func (e *Epub) writeEncryptionFile(z *zipWriter) error {
+	encryptionFilePath := path.Join(metaInfFolderName, encryptionFilename)
+
+	fontFilenames := make([]string, 0, len(e.fonts))
+	for fontFilename := range e.fonts {
+		fontFilenames = append(fontFilenames, fontFilename)
+	}
+	sort.Strings(fontFilenames)
+
+	enc := &encryptionRoot{
+		XmlnsEnc: xmlnsEnc,
+	}
+	for _, fontFilename := range fontFilenames {
+		var data encryptionEncryptedData
+		data.EncryptionMethod.Algorithm = obfuscationAlgorithmIDPF
+		// The URI is relative to the root of the container
+		data.CipherReference.URI = path.Join(contentFolderName, FontFolderName, fontFilename)
+		enc.EncryptedData = append(enc.EncryptedData, data)
+	}
+
+	encryptionFileContent, err := xml.MarshalIndent(enc, "", "  ")
+	if err != nil {
+		return &UnableToWriteEpubError{Filename: encryptionFilePath, Err: err}
+	}
+
+	// Add the xml header to the output
+	encryptionFileContent = append([]byte(xml.Header), encryptionFileContent...)
+	// It's generally nice to have files end with a newline
+	encryptionFileContent = append(encryptionFileContent, "\n"...)
+
+	return writeZipFile(z, encryptionFilePath, encryptionFileContent)
+}
+
+// Return the key of the IDPF algorithm: the SHA-1 hash of the unique
+// identifier without whitespace
+func idpfObfuscationKey(identifier string) []byte {
+	identifier = strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			return -1
+		}
+		return r
+	}, identifier)
+
+	key := sha1.Sum([]byte(identifier))
+	return key[:]
+}
+
+// Return the key of the Adobe algorithm: the bytes of the UUID in the unique
+// identifier, or nil if it isn't a UUID
+func adobeObfuscationKey(identifier string) []byte {
+	identifier = strings.TrimPrefix(strings.TrimSpace(identifier), urnUUIDPrefix)
+	key, err := hex.DecodeString(strings.ReplaceAll(identifier, "-", ""))
+	if err != nil || len(key) != 16 {
+		return nil
+	}
+	return key
+}
+
+// XOR the first length bytes of data, which start at the given offset in the
+// file, with the key. Obfuscating and de-obfuscating are the same operation.
+func obfuscate(data []byte, offset int, key []byte, length int) {
+	for i := range data {
+		if offset+i >= length {
+			break
+		}
+		data[i] ^= key[(offset+i)%len(key)]
+	}
+}
+
+// obfuscatingWriter obfuscates the start of a font as it is written
+type obfuscatingWriter struct {
+	w      io.Writer
+	key    []byte
+	length int
+	offset int
+}
+
+func (o *obfuscatingWriter) Write(p []byte) (int, error) {
+	if o.offset < o.length {
+		p = append([]byte(nil), p...)
+		obfuscate(p, o.offset, o.key, o.length)
+	}
+	n, err := o.w.Write(p)
+	o.offset += n
+	return n, err
+}
@@ -0,0 +1,140 @@
+package epub
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+const testObfuscationID = "urn:uuid:7c4e2a5e-4b1d-4a4e-9a57-5c3e8d0f1a2b"
+
+// SHA-1 of testObfuscationID, computed with a separate tool
+const testObfuscationKey = "b3cb97c9d755bb4b7fe518b7409dc7318b78f749"
+
+// Obfuscate data the way the spec describes it, independently of obfuscate
+func referenceObfuscation(t *testing.T, data []byte, keyHex string, length int) []byte {
+	t.Helper()
+	key, err := hex.DecodeString(keyHex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := append([]byte(nil), data...)
+	for i := 0; i < length && i < len(out); i++ {
+		out[i] = out[i] ^ key[i%len(key)]
+	}
+	return out
+}
+
+func TestIDPFObfuscationKey(t *testing.T) {
+	for _, id := range []string{
+		testObfuscationID,
+		"  " + testObfuscationID + "\n",
+		"urn:uuid: 7c4e2a5e-4b1d-4a4e-9a57-5c3e8d0f1a2b\t",
+		"\r\nurn:uuid:7c4e2a5e-4b1d-\n4a4e-9a57-5c3e8d0f1a2b",
+	} {
+		if got := hex.EncodeToString(idpfObfuscationKey(id)); got != testObfuscationKey {
+			t.Errorf("key of %q is %s, want %s", id, got, testObfuscationKey)
+		}
+	}
+	if got := hex.EncodeToString(idpfObfuscationKey("urn:isbn:9780000000000")); got != "bdb0da4f4297369fd6d39753bc9ebdf59574f027" {
+		t.Errorf("key of an ISBN is %s", got)
+	}
+}
+
+func TestObfuscatingWriter(t *testing.T) {
+	want := referenceObfuscation(t, goregular.TTF, testObfuscationKey, obfuscationLengthIDPF)
+	if bytes.Equal(want[:obfuscationLengthIDPF], goregular.TTF[:obfuscationLengthIDPF]) {
+		t.Fatal("reference obfuscation changed nothing")
+	}
+
+	// The result doesn't depend on how the font is split into writes
+	for _, size := range []int{1, 7, 20, 1039, 1040, 1041, len(goregular.TTF)} {
+		var buf bytes.Buffer
+		w := &obfuscatingWriter{w: &buf, key: idpfObfuscationKey(testObfuscationID), length: obfuscationLengthIDPF}
+		for data := goregular.TTF; len(data) > 0; {
+			n := size
+			if n > len(data) {
+				n = len(data)
+			}
+			if _, err := w.Write(data[:n]); err != nil {
+				t.Fatal(err)
+			}
+			data = data[n:]
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("writes of %d bytes: obfuscated font differs from the reference", size)
+		}
+	}
+
+	// Obfuscating again de-obfuscates
+	data := append([]byte(nil), want...)
+	obfuscate(data, 0, idpfObfuscationKey(testObfuscationID), obfuscationLengthIDPF)
+	if !bytes.Equal(data, goregular.TTF) {
+		t.Error("de-obfuscated font differs from the original")
+	}
+}
+
+// Write a book with an obfuscated font
+func writeObfuscatedEpub(t *testing.T) []byte {
+	t.Helper()
+	e := NewEpub("Obfuscated")
+	e.SetIdentifier(testObfuscationID)
+	e.SetFontObfuscation(true)
+	if _, err := e.AddFontBytes(goregular.TTF, "go.ttf", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection("<p>Text</p>", "Text", "text.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	return writeTestEpub(t, e)
+}
+
+func TestFontObfuscationIDPF(t *testing.T) {
+	data := writeObfuscatedEpub(t)
+	files := zipFiles(t, data)
+
+	want := referenceObfuscation(t, goregular.TTF, testObfuscationKey, 1040)
+	if !bytes.Equal(files["EPUB/fonts/go.ttf"], want) {
+		t.Error("written font differs from the reference obfuscation")
+	}
+	enc := string(files["META-INF/encryption.xml"])
+	if !strings.Contains(enc, `Algorithm="http://www.idpf.org/2008/embedding"`) || !strings.Contains(enc, `URI="EPUB/fonts/go.ttf"`) {
+		t.Errorf("encryption.xml doesn't list the font:\n%s", enc)
+	}
+
+	e := readTestEpub(t, data)
+	if font := e.fonts["go.ttf"]; font == nil || !bytes.Equal(font.data, goregular.TTF) {
+		t.Error("read font differs from the original")
+	}
+}
+
+func TestFontObfuscationAdobe(t *testing.T) {
+	// The Adobe key is the 16 bytes of the UUID, over the first 1024 bytes
+	obfuscated := referenceObfuscation(t, goregular.TTF, "7c4e2a5e4b1d4a4e9a575c3e8d0f1a2b", 1024)
+
+	data := writeObfuscatedEpub(t)
+	data = editZipFile(t, data, "EPUB/fonts/go.ttf", func(string) string {
+		return string(obfuscated)
+	})
+	data = editZipFile(t, data, "META-INF/encryption.xml", func(enc string) string {
+		return strings.Replace(enc, obfuscationAlgorithmIDPF, "http://ns.adobe.com/pdf/enc#RC", 1)
+	})
+
+	e := readTestEpub(t, data)
+	if font := e.fonts["go.ttf"]; font == nil || !bytes.Equal(font.data, goregular.TTF) {
+		t.Error("read font differs from the original")
+	}
+}
+
+func TestFontObfuscationUnsupported(t *testing.T) {
+	data := editZipFile(t, writeObfuscatedEpub(t), "META-INF/encryption.xml", func(enc string) string {
+		return strings.Replace(enc, obfuscationAlgorithmIDPF, "http://www.w3.org/2001/04/xmlenc#aes128-cbc", 1)
+	})
+	_, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err == nil || !strings.Contains(err.Error(), "aes128-cbc") {
+		t.Errorf("got %v, want an unsupported encryption error", err)
+	}
+}
@@ -3,25 +3,23 @@ package epub
 import (
 	"encoding/xml"
 	"fmt"
-	"os"
-	"path/filepath"
-	"time"
+	"path"
+	"strings"
 )
 
 const (
-	pkgAuthorID       = "role"
-	pkgAuthorData     = "aut"
-	pkgAuthorProperty = "role"
-	pkgAuthorRefines  = "#creator"
-	pkgAuthorScheme   = "marc:relators"
-	pkgCreatorID      = "creator"
-	pkgFileTemplate   = `<?xml version="1.0" encoding="UTF-8"?>
+	pkgCalibreSeries       = "calibre:series"
+	pkgCalibreSeriesIndex  = "calibre:series_index"
+	pkgCollectionIDFormat  = "collection%02d"
+	pkgCollectionProperty  = "belongs-to-collection"
+	pkgCollectionType      = "collection-type"
+	pkgContributorIDFormat = "contributor%02d"
+	pkgCreatorIDFormat     = "creator%02d"
+	pkgFileAsProperty      = "file-as"
+	pkgGroupPosition       = "group-position"
+	pkgFileTemplate        = `<?xml version="1.0" encoding="UTF-8"?>
 <package version="3.0" unique-identifier="pub-id" xmlns="http://www.idpf.org/2007/opf">
   <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
-    <dc:identifier id="pub-id"></dc:identifier>
-    <dc:title></dc:title>
-    <dc:language></dc:language>
-    <dc:description></dc:description>
   </metadata>
   <manifest>
   </manifest>
@@ -29,8 +27,18 @@ const (
   </spine>
 </package>
 `
-	pkgModifiedProperty = "dcterms:modified"
-	pkgUniqueIdentifier = "pub-id"
+	pkgIdentifierIDFormat     = "id%02d"
+	pkgIdentifierTypeProperty = "identifier-type"
+	pkgModifiedProperty       = "dcterms:modified"
+	pkgOnixCodelist5Scheme    = "onix:codelist5"
+	pkgRoleProperty           = "role"
+	pkgRoleScheme             = "marc:relators"
+	pkgSubtitleID             = "subtitle"
+	pkgTitleID                = "title"
+	pkgTitleTypeMain          = "main"
+	pkgTitleTypeProperty      = "title-type"
+	pkgTitleTypeSubtitle      = "subtitle"
+	pkgUniqueIdentifier       = "pub-id"
 
 	xmlnsDc = "http://purl.org/dc/elements/1.1/"
 )
@@ -43,32 +51,46 @@ const (
 // Spec: http://www.idpf.org/epub/301/spec/epub-publications.html
 type pkg struct {
 	xml          *pkgRoot
-	authorMeta   *pkgMeta
 	coverMeta    *pkgMeta
 	modifiedMeta *pkgMeta
+
+	// Metadata that is refined by <meta> elements; it is added to xml when the
+	// package file is written
+	identifier   string
+	identifiers  []pkgAltIdentifier
+	title        string
+	titleFileAs  string
+	subtitle     string
+	creators     []Contributor
+	contributors []Contributor
+	collections  []Collection
+	layout       string
+	spread       string
 }
 
-// This holds the actual XML for the package file
-type pkgRoot struct {
-	XMLName          xml.Name    `xml:"http://www.idpf.org/2007/opf package"`
-	UniqueIdentifier string      `xml:"unique-identifier,attr"`
-	Version          string      `xml:"version,attr"`
-	Metadata         pkgMetadata `xml:"metadata"`
-	ManifestItems    []pkgItem   `xml:"manifest>item"`
-	Spine            pkgSpine    `xml:"spine"`
+// An identifier other than the unique identifier, with its scheme
+type pkgAltIdentifier struct {
+	identifier string
+	scheme     string
 }
 
-// <dc:creator>, e.g. the author
-type pkgCreator struct {
-	XMLName xml.Name `xml:"dc:creator"`
-	ID      string   `xml:"id,attr"`
-	Data    string   `xml:",chardata"`
+// This holds the actual XML for the package file
+type pkgRoot struct {
+	XMLName          xml.Name            `xml:"http://www.idpf.org/2007/opf package"`
+	UniqueIdentifier string              `xml:"unique-identifier,attr"`
+	Version          string              `xml:"version,attr"`
+	Metadata         pkgMetadata         `xml:"metadata"`
+	ManifestItems    []pkgItem           `xml:"manifest>item"`
+	Spine            pkgSpine            `xml:"spine"`
+	Guide            []pkgGuideReference `xml:"guide>reference"`
 }
 
-// <dc:identifier>, where the unique identifier is stored
+// A Dublin Core element that can be refined by <meta> elements, such as
+// <dc:identifier>, <dc:title> or <dc:creator>
 // Ex: <dc:identifier id="pub-id">urn:uuid:fe93046f-af57-475a-a0cb-a0d4bc99ba6d</dc:identifier>
-type pkgIdentifier struct {
-	ID   string `xml:"id,attr"`
+//     <dc:creator id="creator01">Hingle McCringleberry</dc:creator>
+type pkgDcElement struct {
+	ID   string `xml:"id,attr,omitempty"`
 	Data string `xml:",chardata"`
 }
 
@@ -76,26 +98,41 @@ type pkgIdentifier struct {
 // Ex: <item id="nav" href="nav.xhtml" media-type="application/xhtml+xml" properties="nav" />
 //     <item id="ncx" href="toc.ncx" media-type="application/x-dtbncx+xml" />
 //     <item id="section0001.xhtml" href="xhtml/section0001.xhtml" media-type="application/xhtml+xml" />
+//     <item id="media0001.webm" href="media/media0001.webm" media-type="video/webm" fallback="section0002.xhtml" />
 type pkgItem struct {
 	ID         string `xml:"id,attr"`
 	Href       string `xml:"href,attr"`
 	MediaType  string `xml:"media-type,attr"`
+	Fallback   string `xml:"fallback,attr,omitempty"`
 	Properties string `xml:"properties,attr,omitempty"`
 }
 
 // <itemref> elements, which define the reading order
 // Ex: <itemref idref="section0001.xhtml" />
+//     <itemref idref="section0002.xhtml" properties="page-spread-left" />
+//     <itemref idref="section0003.xhtml" linear="no" />
 type pkgItemref struct {
-	Idref string `xml:"idref,attr"`
+	Idref      string `xml:"idref,attr"`
+	Linear     string `xml:"linear,attr,omitempty"`
+	Properties string `xml:"properties,attr,omitempty"`
+}
+
+// <reference> elements of the EPUB 2 guide, which point to the key parts of
+// the EPUB
+// Ex: <reference type="toc" title="Table of Contents" href="nav.xhtml" />
+type pkgGuideReference struct {
+	Type  string `xml:"type,attr"`
+	Title string `xml:"title,attr,omitempty"`
+	Href  string `xml:"href,attr"`
 }
 
 // The <meta> element, which contains modified date, role of the creator (e.g.
 // author), etc
-// Ex: <meta refines="#creator" property="role" scheme="marc:relators" id="role">aut</meta>
+// Ex: <meta refines="#creator01" property="role" scheme="marc:relators">aut</meta>
 //     <meta property="dcterms:modified">2011-01-01T12:00:00Z</meta>
 type pkgMeta struct {
 	Refines  string `xml:"refines,attr,omitempty"`
-	Property string `xml:"property,attr"`
+	Property string `xml:"property,attr,omitempty"`
 	Scheme   string `xml:"scheme,attr,omitempty"`
 	ID       string `xml:"id,attr,omitempty"`
 	Data     string `xml:",chardata"`
@@ -105,15 +142,22 @@ type pkgMeta struct {
 
 // The <metadata> element
 type pkgMetadata struct {
-	XmlnsDc    string        `xml:"xmlns:dc,attr"`
-	Identifier pkgIdentifier `xml:"dc:identifier"`
+	XmlnsDc     string         `xml:"xmlns:dc,attr"`
+	Identifiers []pkgDcElement `xml:"dc:identifier"`
 	// Ex: <dc:title>Your title here</dc:title>
-	Title string `xml:"dc:title"`
+	Titles []pkgDcElement `xml:"dc:title"`
 	// Ex: <dc:language>en</dc:language>
-	Language    string `xml:"dc:language"`
-	Description string `xml:"dc:description,omitempty"`
-	Creator     *pkgCreator
-	Meta        []pkgMeta `xml:"meta"`
+	Language     string         `xml:"dc:language"`
+	Creators     []pkgDcElement `xml:"dc:creator"`
+	Contributors []pkgDcElement `xml:"dc:contributor"`
+	Description  string         `xml:"dc:description,omitempty"`
+	Publisher    string         `xml:"dc:publisher,omitempty"`
+	// Ex: <dc:date>2011-01-01</dc:date>
+	Date     string    `xml:"dc:date,omitempty"`
+	Subjects []string  `xml:"dc:subject"`
+	Rights   string    `xml:"dc:rights,omitempty"`
+	Source   string    `xml:"dc:source,omitempty"`
+	Meta     []pkgMeta `xml:"meta"`
 }
 
 // The <spine> element
@@ -129,9 +173,6 @@ func newPackage() *pkg {
 		xml: &pkgRoot{
 			Metadata: pkgMetadata{
 				XmlnsDc: xmlnsDc,
-				Identifier: pkgIdentifier{
-					ID: pkgUniqueIdentifier,
-				},
 			},
 		},
 	}
@@ -150,51 +191,72 @@ func newPackage() *pkg {
 	return p
 }
 
-func (p *pkg) addToManifest(id string, href string, mediaType string, properties string) {
-	href = filepath.ToSlash(href)
+func (p *pkg) addToManifest(id string, href string, mediaType string, fallback string, properties string) {
 	i := &pkgItem{
 		ID:         id,
 		Href:       href,
 		MediaType:  mediaType,
+		Fallback:   fallback,
 		Properties: properties,
 	}
 	p.xml.ManifestItems = append(p.xml.ManifestItems, *i)
 }
 
-func (p *pkg) addToSpine(id string) {
+// Remove all manifest, spine and guide items so they can be added again
+func (p *pkg) clearItems() {
+	p.xml.ManifestItems = nil
+	p.xml.Spine.Items = nil
+	p.xml.Guide = nil
+}
+
+func (p *pkg) addToGuide(guideType string, title string, href string) {
+	p.xml.Guide = append(p.xml.Guide, pkgGuideReference{
+		Type:  guideType,
+		Title: title,
+		Href:  href,
+	})
+}
+
+func (p *pkg) addToSpine(id string, linear string, properties string) {
 	i := &pkgItemref{
-		Idref: id,
+		Idref:      id,
+		Linear:     linear,
+		Properties: properties,
 	}
 
 	p.xml.Spine.Items = append(p.xml.Spine.Items, *i)
 }
 
-func (p *pkg) setAuthor(author string) {
-	p.xml.Metadata.Creator = &pkgCreator{
-		Data: author,
-		ID:   pkgCreatorID,
-	}
-	p.authorMeta = &pkgMeta{
-		Data:     pkgAuthorData,
-		ID:       pkgAuthorID,
-		Property: pkgAuthorProperty,
-		Refines:  pkgAuthorRefines,
-		Scheme:   pkgAuthorScheme,
-	}
+func (p *pkg) setCreators(creators []Contributor) {
+	p.creators = creators
+}
+
+func (p *pkg) setContributors(contributors []Contributor) {
+	p.contributors = contributors
+}
 
-	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, p.authorMeta)
+func (p *pkg) setCollections(collections []Collection) {
+	p.collections = collections
+}
+
+func (p *pkg) addIdentifier(identifier string, scheme string) {
+	p.identifiers = append(p.identifiers, pkgAltIdentifier{
+		identifier: identifier,
+		scheme:     scheme,
+	})
 }
 
 func (p *pkg) setCover(coverRef string) {
+	old := p.coverMeta
 	p.coverMeta = &pkgMeta{
 		Name:    "cover",
 		Content: coverRef,
 	}
-	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, p.coverMeta)
+	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, old, p.coverMeta)
 }
 
 func (p *pkg) setIdentifier(identifier string) {
-	p.xml.Metadata.Identifier.Data = identifier
+	p.identifier = identifier
 }
 
 func (p *pkg) setLang(lang string) {
@@ -209,27 +271,164 @@ func (p *pkg) setPpd(direction string) {
 	p.xml.Spine.Ppd = direction
 }
 
+func (p *pkg) setLayout(layout string) {
+	p.layout = layout
+}
+
+func (p *pkg) setSpread(spread string) {
+	p.spread = spread
+}
+
 func (p *pkg) setModified(timestamp string) {
+	old := p.modifiedMeta
 	p.modifiedMeta = &pkgMeta{
 		Data:     timestamp,
 		Property: pkgModifiedProperty,
 	}
 
-	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, p.modifiedMeta)
+	p.xml.Metadata.Meta = updateMeta(p.xml.Metadata.Meta, old, p.modifiedMeta)
 }
 
 func (p *pkg) setTitle(title string) {
-	p.xml.Metadata.Title = title
+	p.title = title
+}
+
+func (p *pkg) setTitleFileAs(fileAs string) {
+	p.titleFileAs = fileAs
+}
+
+func (p *pkg) setSubtitle(subtitle string) {
+	p.subtitle = subtitle
+}
+
+func (p *pkg) setPublisher(publisher string) {
+	p.xml.Metadata.Publisher = publisher
+}
+
+func (p *pkg) setDate(date string) {
+	p.xml.Metadata.Date = date
+}
+
+func (p *pkg) setSubjects(subjects []string) {
+	p.xml.Metadata.Subjects = subjects
+}
+
+func (p *pkg) setRights(rights string) {
+	p.xml.Metadata.Rights = rights
 }
 
-// Update the <meta> element
-func updateMeta(a []pkgMeta, m *pkgMeta) []pkgMeta {
+func (p *pkg) setSource(source string) {
+	p.xml.Metadata.Source = source
+}
+
+// Return the <metadata> element with the identifiers, titles, creators and
+// contributors added, the <meta> elements refining them placed before the
+// other <meta> elements, and the rendition properties last
+func (p *pkg) buildMetadata() pkgMetadata {
+	m := p.xml.Metadata
+	var refines []pkgMeta
+
+	m.Identifiers = []pkgDcElement{{ID: pkgUniqueIdentifier, Data: p.identifier}}
+	for i, alt := range p.identifiers {
+		id := fmt.Sprintf(pkgIdentifierIDFormat, i+2)
+		m.Identifiers = append(m.Identifiers, pkgDcElement{ID: id, Data: alt.identifier})
+		if alt.scheme == "" {
+			continue
+		}
+		identifierType := pkgMeta{
+			Refines:  "#" + id,
+			Property: pkgIdentifierTypeProperty,
+			Data:     alt.scheme,
+		}
+		if code, ok := onixIdentifierTypes[strings.ToUpper(alt.scheme)]; ok {
+			identifierType.Scheme = pkgOnixCodelist5Scheme
+			identifierType.Data = code
+		}
+		refines = append(refines, identifierType)
+	}
+
+	m.Titles = []pkgDcElement{{ID: pkgTitleID, Data: p.title}}
+	if p.subtitle != "" {
+		m.Titles = append(m.Titles, pkgDcElement{ID: pkgSubtitleID, Data: p.subtitle})
+		refines = append(refines,
+			pkgMeta{Refines: "#" + pkgTitleID, Property: pkgTitleTypeProperty, Data: pkgTitleTypeMain},
+			pkgMeta{Refines: "#" + pkgSubtitleID, Property: pkgTitleTypeProperty, Data: pkgTitleTypeSubtitle},
+		)
+	}
+	if p.titleFileAs != "" {
+		refines = append(refines, pkgMeta{Refines: "#" + pkgTitleID, Property: pkgFileAsProperty, Data: p.titleFileAs})
+	}
+
+	m.Creators, refines = buildContributors(p.creators, pkgCreatorIDFormat, refines)
+	m.Contributors, refines = buildContributors(p.contributors, pkgContributorIDFormat, refines)
+	refines = buildCollections(p.collections, refines)
+
+	m.Meta = append(refines, m.Meta...)
+
+	if p.layout != "" {
+		m.Meta = append(m.Meta, pkgMeta{Property: pkgLayoutProperty, Data: p.layout})
+	}
+	if p.spread != "" {
+		m.Meta = append(m.Meta, pkgMeta{Property: pkgSpreadProperty, Data: p.spread})
+	}
+
+	return m
+}
+
+// Add the <meta> elements of the collections to refines, followed by Calibre
+// series metadata for the first series
+func buildCollections(collections []Collection, refines []pkgMeta) []pkgMeta {
+	for i, c := range collections {
+		id := fmt.Sprintf(pkgCollectionIDFormat, i+1)
+		refines = append(refines, pkgMeta{ID: id, Property: pkgCollectionProperty, Data: c.Name})
+		if c.Type != "" {
+			refines = append(refines, pkgMeta{Refines: "#" + id, Property: pkgCollectionType, Data: c.Type})
+		}
+		if c.Position != "" {
+			refines = append(refines, pkgMeta{Refines: "#" + id, Property: pkgGroupPosition, Data: c.Position})
+		}
+	}
+
+	for _, c := range collections {
+		if c.Type != CollectionSeries {
+			continue
+		}
+		refines = append(refines, pkgMeta{Name: pkgCalibreSeries, Content: c.Name})
+		if c.Position != "" {
+			refines = append(refines, pkgMeta{Name: pkgCalibreSeriesIndex, Content: c.Position})
+		}
+		break
+	}
+
+	return refines
+}
+
+// Return the <dc:creator> or <dc:contributor> elements of the contributors
+// and add the <meta> elements refining them to refines
+func buildContributors(contributors []Contributor, idFormat string, refines []pkgMeta) ([]pkgDcElement, []pkgMeta) {
+	var elements []pkgDcElement
+	for i, c := range contributors {
+		id := fmt.Sprintf(idFormat, i+1)
+		elements = append(elements, pkgDcElement{ID: id, Data: c.Name})
+		if c.Role != "" {
+			refines = append(refines, pkgMeta{Refines: "#" + id, Property: pkgRoleProperty, Scheme: pkgRoleScheme, Data: c.Role})
+		}
+		if c.FileAs != "" {
+			refines = append(refines, pkgMeta{Refines: "#" + id, Property: pkgFileAsProperty, Data: c.FileAs})
+		}
+	}
+	return elements, refines
+}
+
+// Update the <meta> element, replacing the previous version of it (old) if
+// there is one
+func updateMeta(a []pkgMeta, old *pkgMeta, m *pkgMeta) []pkgMeta {
 	indexToReplace := -1
 
-	if len(a) > 0 {
-		// If we've already added the modified meta element to the meta array
+	if len(a) > 0 && old != nil {
+		// If we've already added the meta element to the meta array
 		for i, meta := range a {
-			if meta == *m {
+			if meta == *old {
 				indexToReplace = i
 				break
 			}
@@ -250,27 +449,22 @@ func updateMeta(a []pkgMeta, m *pkgMeta) []pkgMeta {
 	return a
 }
 
-// Write the package file to the temporary directory
-func (p *pkg) write(tempDir string) {
-	now := time.Now().UTC().Format("2006-01-02T15:04:05Z")
-	p.setModified(now)
+// Write the package file to the EPUB
+func (p *pkg) write(z *zipWriter) error {
+	p.setModified(z.modified.UTC().Format("2006-01-02T15:04:05Z"))
 
-	pkgFilePath := filepath.Join(tempDir, contentFolderName, pkgFilename)
+	pkgFilePath := path.Join(contentFolderName, pkgFilename)
 
-	output, err := xml.MarshalIndent(p.xml, "", "  ")
+	x := *p.xml
+	x.Metadata = p.buildMetadata()
+	output, err := xml.MarshalIndent(&x, "", "  ")
 	if err != nil {
-		panic(fmt.Sprintf(
-			"Error marshalling XML for package file: %s\n"+
-				"\tXML=%#v",
-			err,
-			p.xml))
+		return &UnableToWriteEpubError{Filename: pkgFilePath, Err: err}
 	}
 	// Add the xml header to the output
 	pkgFileContent := append([]byte(xml.Header), output...)
 	// It's generally nice to have files end with a newline
 	pkgFileContent = append(pkgFileContent, "\n"...)
 
-	if err := os.WriteFile(pkgFilePath, []byte(pkgFileContent), filePermissions); err != nil {
-		panic(fmt.Sprintf("Error writing package file: %s", err))
-	}
+	return writeZipFile(z, pkgFilePath, pkgFileContent)
 }
@@ -0,0 +1,987 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// UnableToReadEpubError is thrown by Open or Read if the EPUB or one of the
+// files inside it cannot be read or parsed
+type UnableToReadEpubError struct {
+	Filename string // The file inside the EPUB that was being read, if any
+	Err      error  // The underlying error that was thrown
+}
+
+func (e *UnableToReadEpubError) Error() string {
+	if e.Filename == "" {
+		return fmt.Sprintf("Error reading EPUB: %+v", e.Err)
+	}
+	return fmt.Sprintf("Error reading %q from EPUB: %+v", e.Filename, e.Err)
+}
+
+func (e *UnableToReadEpubError) Unwrap() error {
+	return e.Err
+}
+
+var (
+	// Attributes of XHTML content referencing other files
+	xhtmlRefRegexp = regexp.MustCompile(`(\s(?:src|href|xlink:href|poster)\s*=\s*)("[^"]*"|'[^']*')`)
+	// References to other files in CSS
+	cssRefRegexp = regexp.MustCompile(`url\(\s*("[^"]*"|'[^']*'|[^)"'\s]*)\s*\)`)
+)
+
+// The container file (META-INF/container.xml)
+type readContainer struct {
+	Rootfiles []struct {
+		FullPath  string `xml:"full-path,attr"`
+		MediaType string `xml:"media-type,attr"`
+	} `xml:"rootfiles>rootfile"`
+}
+
+// The package file (package.opf), as far as it is needed to rebuild or
+// validate an Epub
+type readPackage struct {
+	Version          string `xml:"version,attr"`
+	UniqueIdentifier string `xml:"unique-identifier,attr"`
+	Metadata         struct {
+		Identifiers  []readDcElement `xml:"http://purl.org/dc/elements/1.1/ identifier"`
+		Titles       []readDcElement `xml:"http://purl.org/dc/elements/1.1/ title"`
+		Languages    []string        `xml:"http://purl.org/dc/elements/1.1/ language"`
+		Descriptions []string        `xml:"http://purl.org/dc/elements/1.1/ description"`
+		Creators     []readDcElement `xml:"http://purl.org/dc/elements/1.1/ creator"`
+		Contributors []readDcElement `xml:"http://purl.org/dc/elements/1.1/ contributor"`
+		Publishers   []string        `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+		Dates        []string        `xml:"http://purl.org/dc/elements/1.1/ date"`
+		Subjects     []string        `xml:"http://purl.org/dc/elements/1.1/ subject"`
+		Rights       []string        `xml:"http://purl.org/dc/elements/1.1/ rights"`
+		Sources      []string        `xml:"http://purl.org/dc/elements/1.1/ source"`
+		Meta         []struct {
+			ID       string `xml:"id,attr"`
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Refines  string `xml:"refines,attr"`
+			Property string `xml:"property,attr"`
+			Scheme   string `xml:"scheme,attr"`
+			Data     string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Properties string `xml:"properties,attr"`
+		Fallback   string `xml:"fallback,attr"`
+	} `xml:"manifest>item"`
+	Spine struct {
+		Toc   string `xml:"toc,attr"`
+		Ppd   string `xml:"page-progression-direction,attr"`
+		Items []struct {
+			Idref      string `xml:"idref,attr"`
+			Linear     string `xml:"linear,attr"`
+			Properties string `xml:"properties,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
+}
+
+// A Dublin Core element, with the EPUB 2 attributes that EPUB 3 replaced with
+// refining <meta> elements
+type readDcElement struct {
+	ID     string `xml:"id,attr"`
+	Role   string `xml:"http://www.idpf.org/2007/opf role,attr"`
+	FileAs string `xml:"http://www.idpf.org/2007/opf file-as,attr"`
+	Scheme string `xml:"http://www.idpf.org/2007/opf scheme,attr"`
+	Data   string `xml:",chardata"`
+}
+
+// An XHTML content document
+type readXhtml struct {
+	Title string `xml:"head>title"`
+	Metas []struct {
+		Name    string `xml:"name,attr"`
+		Content string `xml:"content,attr"`
+	} `xml:"head>meta"`
+	Links []struct {
+		Rel  string `xml:"rel,attr"`
+		Href string `xml:"href,attr"`
+	} `xml:"head>link"`
+	Body xhtmlInnerxml `xml:"body"`
+}
+
+// The body of a TOC page written by SetTocPage
+type readTocPage struct {
+	Navs []struct {
+		ID string `xml:"id,attr"`
+		H1 string `xml:"h1"`
+	} `xml:"nav"`
+}
+
+// A navigation entry of nav.xhtml or toc.ncx
+type readNavEntry struct {
+	title  string
+	href   string // Path inside the EPUB, without fragment
+	parent string // Path of the closest enclosing entry for another file
+}
+
+// The EPUB v3 TOC file (nav.xhtml)
+type readNavDoc struct {
+	Navs []readNav `xml:"body>nav"`
+}
+
+type readNav struct {
+	EpubType string        `xml:"http://www.idpf.org/2007/ops type,attr"`
+	H1       string        `xml:"h1"`
+	Items    []readNavItem `xml:"ol>li"`
+}
+
+type readNavItem struct {
+	A struct {
+		Href string `xml:"href,attr"`
+		Data string `xml:",innerxml"`
+	} `xml:"a"`
+	Children []readNavItem `xml:"ol>li"`
+}
+
+// The EPUB v2 TOC file (toc.ncx)
+type readNcx struct {
+	NavPoints []readNcxNavPoint `xml:"navMap>navPoint"`
+}
+
+type readNcxNavPoint struct {
+	Text    string `xml:"navLabel>text"`
+	Content struct {
+		Src string `xml:"src,attr"`
+	} `xml:"content"`
+	Children []readNcxNavPoint `xml:"navPoint"`
+}
+
+// Open reads the EPUB file at the given path so it can be modified and
+// written again.
+func Open(epubFilePath string) (*Epub, error) {
+	data, err := os.ReadFile(epubFilePath)
+	if err != nil {
+		return nil, &UnableToReadEpubError{Err: err}
+	}
+
+	return Read(bytes.NewReader(data), int64(len(data)))
+}
+
+// Read reads an EPUB of the given size from r so it can be modified and
+// written again.
+//
+// The metadata, cover, sections, table of contents titles and all CSS, font,
+// image and other media files are read into memory. Files are stored using the
+// folder layout of this package, and references between them in sections and
+// CSS files are rewritten accordingly. Sections nested in the table of
+// contents are read back as subsections of their parent entry, as long as the
+// parent comes earlier in the reading order; otherwise they become top-level
+// sections. The table of contents is generated again from the section titles
+// and that hierarchy when the EPUB is written, so each section is listed once
+// and entries pointing inside a section are dropped. XHTML files of the
+// manifest that are not in the reading order are read as non-linear sections.
+//
+// A stylesheet that all sections but the cover page link to first, such as one
+// set with SetDefaultCSS, is read back as the default stylesheet; the
+// sections then link to their other stylesheets only.
+func Read(r io.ReaderAt, size int64) (*Epub, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &UnableToReadEpubError{Err: err}
+	}
+
+	rd := &epubReader{
+		files:   make(map[string]*zip.File),
+		renamed: make(map[string]string),
+	}
+	for _, f := range z.File {
+		rd.files[f.Name] = f
+	}
+
+	return rd.read()
+}
+
+// epubReader holds the state of Read
+type epubReader struct {
+	e     *Epub
+	files map[string]*zip.File
+	// Path of the package file inside the EPUB
+	pkgPath string
+	// The key is the original path inside the EPUB, the value is the new
+	// relative path from a section, e.g. ../images/cover.png
+	renamed map[string]string
+	// The key is the path of an encrypted file inside the EPUB, the value is
+	// its encryption algorithm
+	encrypted map[string]string
+}
+
+func (rd *epubReader) read() (*Epub, error) {
+	var c readContainer
+	err := rd.unmarshal(path.Join(metaInfFolderName, containerFilename), &c)
+	if err != nil {
+		return nil, err
+	}
+	for _, rf := range c.Rootfiles {
+		if rf.MediaType == "" || rf.MediaType == "application/oebps-package+xml" {
+			rd.pkgPath = rf.FullPath
+			break
+		}
+	}
+	if rd.pkgPath == "" {
+		return nil, &UnableToReadEpubError{
+			Filename: path.Join(metaInfFolderName, containerFilename),
+			Err:      fmt.Errorf("no package file found"),
+		}
+	}
+
+	var p readPackage
+	err = rd.unmarshal(rd.pkgPath, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	rd.e = NewEpub("")
+	rd.readMetadata(&p)
+
+	// Must be called after readMetadata, since fonts are obfuscated using the
+	// unique identifier
+	err = rd.readEncryption()
+	if err != nil {
+		return nil, err
+	}
+
+	err = rd.readMedia(&p)
+	if err != nil {
+		return nil, err
+	}
+
+	err = rd.readSections(&p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Must be called after readMedia and readSections, since fallbacks may
+	// point to either
+	rd.readFallbacks(&p)
+
+	return rd.e, nil
+}
+
+func (rd *epubReader) readMetadata(p *readPackage) {
+	e := rd.e
+	m := &p.Metadata
+
+	// The refining <meta> elements by the id of the element they refine and
+	// their property
+	refines := make(map[string]string)
+	for _, meta := range m.Meta {
+		if strings.HasPrefix(meta.Refines, "#") && meta.Property != "" {
+			key := strings.TrimPrefix(meta.Refines, "#") + " " + meta.Property
+			value := strings.TrimSpace(meta.Data)
+			if meta.Property == pkgIdentifierTypeProperty && meta.Scheme == pkgOnixCodelist5Scheme {
+				value = onixIdentifierScheme(value)
+			}
+			refines[key] = value
+		}
+	}
+	refined := func(el readDcElement, property string, fallback string) string {
+		if value := refines[el.ID+" "+property]; el.ID != "" && value != "" {
+			return value
+		}
+		return strings.TrimSpace(fallback)
+	}
+
+	unique := 0
+	for i, id := range m.Identifiers {
+		if id.ID == p.UniqueIdentifier {
+			unique = i
+			break
+		}
+	}
+	for i, id := range m.Identifiers {
+		identifier := strings.TrimSpace(id.Data)
+		if i == unique {
+			e.SetIdentifier(identifier)
+			continue
+		}
+		e.AddIdentifier(identifier, refined(id, pkgIdentifierTypeProperty, id.Scheme))
+	}
+
+	for _, title := range m.Titles {
+		switch {
+		case refined(title, pkgTitleTypeProperty, "") == pkgTitleTypeSubtitle:
+			if e.Subtitle() == "" {
+				e.SetSubtitle(strings.TrimSpace(title.Data))
+			}
+		case e.Title() == "":
+			e.SetTitle(strings.TrimSpace(title.Data))
+			e.SetTitleFileAs(refined(title, pkgFileAsProperty, title.FileAs))
+		}
+	}
+
+	for _, c := range m.Creators {
+		e.AddCreator(Contributor{
+			Name:   strings.TrimSpace(c.Data),
+			FileAs: refined(c, pkgFileAsProperty, c.FileAs),
+			Role:   refined(c, pkgRoleProperty, c.Role),
+		})
+	}
+	for _, c := range m.Contributors {
+		e.AddContributor(Contributor{
+			Name:   strings.TrimSpace(c.Data),
+			FileAs: refined(c, pkgFileAsProperty, c.FileAs),
+			Role:   refined(c, pkgRoleProperty, c.Role),
+		})
+	}
+	calibreSeries, calibreIndex := "", ""
+	for _, meta := range m.Meta {
+		switch {
+		case meta.Property == pkgCollectionProperty && meta.Refines == "":
+			el := readDcElement{ID: meta.ID}
+			e.AddCollection(Collection{
+				Name:     strings.TrimSpace(meta.Data),
+				Type:     refined(el, pkgCollectionType, ""),
+				Position: refined(el, pkgGroupPosition, ""),
+			})
+		case meta.Name == pkgCalibreSeries:
+			calibreSeries = strings.TrimSpace(meta.Content)
+		case meta.Name == pkgCalibreSeriesIndex:
+			calibreIndex = strings.TrimSpace(meta.Content)
+		case meta.Property == pkgLayoutProperty && meta.Refines == "":
+			e.SetLayout(strings.TrimSpace(meta.Data))
+		case meta.Property == pkgSpreadProperty && meta.Refines == "":
+			e.SetSpread(strings.TrimSpace(meta.Data))
+		}
+	}
+	if name, _ := e.Series(); name == "" && calibreSeries != "" {
+		e.SetSeries(calibreSeries, calibreIndex)
+	}
+
+	for _, subject := range m.Subjects {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			e.AddSubject(subject)
+		}
+	}
+
+	if lang := first(m.Languages); lang != "" {
+		e.SetLang(lang)
+	}
+	e.SetDescription(first(m.Descriptions))
+	e.SetPublisher(first(m.Publishers))
+	e.SetDate(first(m.Dates))
+	e.SetRights(first(m.Rights))
+	e.SetSource(first(m.Sources))
+	if p.Spine.Ppd != "" {
+		e.SetPpd(p.Spine.Ppd)
+	}
+}
+
+// Return the identifier scheme of an ONIX code list 5 identifier type
+func onixIdentifierScheme(code string) string {
+	// ISBN-13 is written as ISBN
+	if code == onixIdentifierTypes["ISBN"] {
+		return "ISBN"
+	}
+	for scheme, c := range onixIdentifierTypes {
+		if c == code {
+			return scheme
+		}
+	}
+	return code
+}
+
+// Read the CSS, font, image and other media files
+func (rd *epubReader) readMedia(p *readPackage) error {
+	e := rd.e
+
+	coverID := ""
+	for _, m := range p.Metadata.Meta {
+		if m.Name == "cover" {
+			coverID = m.Content
+		}
+	}
+
+	for _, item := range p.Manifest {
+		// Remote resources stay on the web, sections keep referring to their
+		// URL
+		if isRemoteSource(item.Href) {
+			rd.renamed[item.Href] = e.addRemoteMedia(item.Href, item.MediaType)
+			continue
+		}
+		if item.MediaType == mediaTypeXhtml || item.MediaType == mediaTypeNcx {
+			continue
+		}
+		itemPath := rd.resolve(rd.pkgPath, item.Href)
+		data, err := rd.readFile(itemPath)
+		if err != nil {
+			return err
+		}
+
+		var addBytes func([]byte, string, string) (string, error)
+		switch {
+		case item.MediaType == mediaTypeCSS:
+			addBytes = e.AddCSSBytes
+		case isFontMediaType(item.MediaType):
+			addBytes = e.AddFontBytes
+		case strings.HasPrefix(item.MediaType, "image/"):
+			addBytes = e.AddImageBytes
+		default:
+			addBytes = e.AddMediaBytes
+		}
+
+		filename := path.Base(itemPath)
+		ref, err := addBytes(data, filename, item.MediaType)
+		if _, ok := err.(*FilenameAlreadyUsedError); ok {
+			ref, err = addBytes(data, "", item.MediaType)
+		}
+		if err != nil {
+			return &UnableToReadEpubError{Filename: itemPath, Err: err}
+		}
+		rd.renamed[itemPath] = ref
+
+		if item.ID == coverID || hasProperty(item.Properties, coverImageProperties) {
+			e.cover.imageFilename = path.Base(ref)
+			e.pkg.setCover(e.cover.imageFilename)
+		}
+	}
+
+	// Rewrite references between CSS files and other files
+	for origPath, ref := range rd.renamed {
+		if css, ok := e.css[path.Base(ref)]; ok && path.Dir(ref) == "../"+CSSFolderName {
+			css.data = rd.rewriteRefs(cssRefRegexp, css.data, origPath, true)
+		}
+	}
+
+	return nil
+}
+
+// Read the sections in reading order, followed by those not in the spine as
+// non-linear sections
+func (rd *epubReader) readSections(p *readPackage) error {
+	e := rd.e
+
+	titles, parents := rd.readToc(p)
+
+	var items []string
+	seen := make(map[string]bool)
+	// The options of the sections from their spine items, by path
+	options := make(map[string][]SectionOption)
+	for _, ref := range p.Spine.Items {
+		for _, item := range p.Manifest {
+			if item.ID == ref.Idref && item.MediaType == mediaTypeXhtml && !isRemoteSource(item.Href) && !seen[item.ID] {
+				itemPath := rd.resolve(rd.pkgPath, item.Href)
+				items = append(items, itemPath)
+				seen[item.ID] = true
+				options[itemPath] = append(spineOptions(ref.Linear, ref.Properties), ManifestProperties(item.Properties))
+			}
+		}
+	}
+	for _, item := range p.Manifest {
+		if item.MediaType == mediaTypeXhtml && !isRemoteSource(item.Href) && !seen[item.ID] && !hasProperty(item.Properties, tocNavItemProperties) {
+			itemPath := rd.resolve(rd.pkgPath, item.Href)
+			items = append(items, itemPath)
+			options[itemPath] = []SectionOption{NonLinear(), ManifestProperties(item.Properties)}
+		}
+	}
+
+	// Assign the new section filenames first so links between sections can
+	// be rewritten
+	filenames := make(map[string]bool)
+	for i, itemPath := range items {
+		filename := path.Base(itemPath)
+		if filenames[filename] {
+			filename = fmt.Sprintf(sectionFileFormat, i+1)
+		}
+		filenames[filename] = true
+		rd.renamed[itemPath] = filename
+	}
+
+	// The sections from the top level down to the last one added; a section
+	// can only be read as a subsection of one of these without changing the
+	// reading order
+	var open []string
+	for _, itemPath := range items {
+		data, err := rd.readFile(itemPath)
+		if err != nil {
+			return err
+		}
+		var x readXhtml
+		err = unmarshalXhtml(data, &x)
+		if err != nil {
+			return &UnableToReadEpubError{Filename: itemPath, Err: err}
+		}
+
+		cssPath := ""
+		var cssPaths []string
+		for _, link := range x.Links {
+			if isStylesheetLink(link.Rel) {
+				cssPaths = append(cssPaths, rd.rewriteRef(link.Href, itemPath, false))
+			}
+		}
+		if len(cssPaths) > 0 {
+			cssPath = cssPaths[0]
+			options[itemPath] = append(options[itemPath], Stylesheets(cssPaths[1:]...))
+		}
+		var elements []string
+		for _, element := range headElements(data) {
+			elements = append(elements, string(rd.rewriteRefs(xhtmlRefRegexp, []byte(element), itemPath, false)))
+		}
+		options[itemPath] = append(options[itemPath], HeadElements(elements...))
+
+		// The TOC page is written again from the TOC instead of as a section
+		var page readTocPage
+		if strings.Contains(x.Body.XML, tocPageID) &&
+			unmarshalXhtml([]byte("<body>"+x.Body.XML+"</body>"), &page) == nil &&
+			len(page.Navs) == 1 && page.Navs[0].ID == tocPageID {
+			if heading := strings.TrimSpace(page.Navs[0].H1); heading != "" {
+				e.SetTocHeading(heading)
+			}
+			if err := e.SetTocPage(cssPath); err != nil {
+				return &UnableToReadEpubError{Filename: itemPath, Err: err}
+			}
+			continue
+		}
+
+		body := rd.rewriteRefs(xhtmlRefRegexp, []byte(x.Body.XML), itemPath, false)
+		filename := rd.renamed[itemPath]
+		for len(open) > 0 && open[len(open)-1] != parents[itemPath] {
+			open = open[:len(open)-1]
+		}
+		if len(open) > 0 {
+			_, err = e.AddSubSection(rd.renamed[open[len(open)-1]], strings.TrimSpace(string(body)), titles[itemPath], filename, cssPath, options[itemPath]...)
+		} else {
+			_, err = e.AddSection(strings.TrimSpace(string(body)), titles[itemPath], filename, cssPath, options[itemPath]...)
+		}
+		open = append(open, itemPath)
+		if err != nil {
+			return &UnableToReadEpubError{Filename: itemPath, Err: err}
+		}
+
+		for _, meta := range x.Metas {
+			if meta.Name == xhtmlViewportName {
+				width, height := parseViewport(meta.Content)
+				e.SetSectionViewport(filename, width, height)
+			}
+		}
+
+		// A page showing nothing but the cover image is the cover page
+		if e.cover.xhtmlFilename == "" && e.cover.imageFilename != "" && isCoverBody(string(body), e.cover.imageFilename) {
+			e.cover.xhtmlFilename = filename
+			e.cover.cssFilename = path.Base(cssPath)
+		}
+	}
+	rd.readDefaultCSS()
+
+	return nil
+}
+
+// A stylesheet that every section but the cover page links to first is taken
+// to be the default stylesheet set with SetDefaultCSS, so that sections added
+// later link to it too. The sections no longer link to it themselves, which
+// writes them the same way.
+func (rd *epubReader) readDefaultCSS() {
+	e := rd.e
+	first := ""
+	shared := true
+	walkSections(e.sections, func(section *epubSection) {
+		if section.filename == e.cover.xhtmlFilename {
+			return
+		}
+		links := section.xhtml.xml.Head.Links
+		if len(links) == 0 || (first != "" && links[0].Href != first) {
+			shared = false
+			return
+		}
+		first = links[0].Href
+	})
+	if !shared || first == "" {
+		return
+	}
+
+	walkSections(e.sections, func(section *epubSection) {
+		if section.filename != e.cover.xhtmlFilename {
+			section.xhtml.xml.Head.Links = section.xhtml.xml.Head.Links[1:]
+		}
+	})
+	e.SetDefaultCSS(first)
+}
+
+// Whether a <link> element with the given rel attribute links to a stylesheet
+// that always applies, unlike an alternate stylesheet
+func isStylesheetLink(rel string) bool {
+	return hasProperty(rel, xhtmlLinkRel) && !hasProperty(rel, "alternate")
+}
+
+// Return the elements of the head of an XHTML document other than its title,
+// viewport, stylesheets and character set, as they are written in the document
+func headElements(data []byte) []string {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.AutoClose = xml.HTMLAutoClose
+
+	var elements []string
+	// How deep inside the document we are, and where the current element of
+	// the head starts if it is kept
+	depth := 0
+	inHead := false
+	start := int64(-1)
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err != nil {
+			return elements
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			switch {
+			case depth == 2 && t.Name.Local == "head":
+				inHead = true
+			case inHead && depth == 3 && isExtraHeadElement(t):
+				start = offset
+			}
+		case xml.EndElement:
+			switch {
+			case inHead && depth == 3 && start >= 0:
+				elements = append(elements, string(data[start:d.InputOffset()]))
+				start = -1
+			case inHead && depth == 2:
+				return elements
+			}
+			depth--
+		}
+	}
+}
+
+// Whether an element of the head isn't written from a field of its own
+func isExtraHeadElement(el xml.StartElement) bool {
+	switch el.Name.Local {
+	case "title":
+		return false
+	case "meta":
+		return attrValue(el, "name") != xhtmlViewportName && attrValue(el, "charset") == "" &&
+			!strings.EqualFold(attrValue(el, "http-equiv"), "content-type")
+	case "link":
+		return !isStylesheetLink(attrValue(el, "rel"))
+	}
+	return true
+}
+
+// Return the section options of a spine item
+func spineOptions(linear string, properties string) []SectionOption {
+	var options []SectionOption
+	if strings.TrimSpace(linear) == pkgItemrefLinearNo {
+		options = append(options, NonLinear())
+	}
+	for _, property := range strings.Fields(properties) {
+		switch property {
+		case PageSpreadLeft, PageSpreadRight, PageSpreadCenter:
+			options = append(options, PageSpread(property))
+		default:
+			options = append(options, SpineProperties(property))
+		}
+	}
+	return options
+}
+
+// Set the fallbacks of the media files that have one in the manifest
+func (rd *epubReader) readFallbacks(p *readPackage) {
+	paths := make(map[string]string)
+	for _, item := range p.Manifest {
+		paths[item.ID] = rd.resolve(rd.pkgPath, item.Href)
+		if isRemoteSource(item.Href) {
+			paths[item.ID] = item.Href
+		}
+	}
+	for _, item := range p.Manifest {
+		if item.Fallback == "" {
+			continue
+		}
+		ref, ok := rd.renamed[paths[item.ID]]
+		fallbackRef, fallbackOk := rd.renamed[paths[item.Fallback]]
+		if ok && fallbackOk {
+			// Fallbacks of sections and to files that weren't read are dropped
+			rd.e.SetMediaFallback(ref, fallbackRef)
+		}
+	}
+}
+
+// Read the TOC titles and parents of the sections from nav.xhtml, or toc.ncx if
+// there is no nav document. The keys and parents are paths of sections inside
+// the EPUB.
+func (rd *epubReader) readToc(p *readPackage) (titles map[string]string, parents map[string]string) {
+	var entries []readNavEntry
+
+	for _, item := range p.Manifest {
+		if !hasProperty(item.Properties, tocNavItemProperties) {
+			continue
+		}
+		navPath := rd.resolve(rd.pkgPath, item.Href)
+		data, err := rd.readFile(navPath)
+		if err != nil {
+			break
+		}
+		var nav readNavDoc
+		if unmarshalXhtml(data, &nav) != nil {
+			break
+		}
+		for _, n := range nav.Navs {
+			if hasProperty(n.EpubType, tocNavEpubType) {
+				entries = flattenNav(entries, n.Items, navPath, "", rd)
+				if heading := strings.TrimSpace(n.H1); heading != "" {
+					rd.e.SetTocHeading(heading)
+				}
+			}
+		}
+	}
+
+	if len(entries) == 0 {
+		for _, item := range p.Manifest {
+			if item.MediaType != mediaTypeNcx {
+				continue
+			}
+			ncxPath := rd.resolve(rd.pkgPath, item.Href)
+			var ncx readNcx
+			if rd.unmarshal(ncxPath, &ncx) != nil {
+				break
+			}
+			entries = flattenNcx(entries, ncx.NavPoints, ncxPath, "", rd)
+		}
+	}
+
+	titles = make(map[string]string)
+	parents = make(map[string]string)
+	for _, entry := range entries {
+		if _, ok := titles[entry.href]; !ok && entry.title != "" {
+			titles[entry.href] = entry.title
+			parents[entry.href] = entry.parent
+		}
+	}
+
+	return
+}
+
+func flattenNav(entries []readNavEntry, items []readNavItem, navPath string, parent string, rd *epubReader) []readNavEntry {
+	for _, item := range items {
+		childParent := parent
+		if item.A.Href != "" {
+			entry := readNavEntry{
+				title:  innerText(item.A.Data),
+				href:   rd.resolve(navPath, item.A.Href),
+				parent: parent,
+			}
+			entries = append(entries, entry)
+			childParent = entry.href
+		}
+		entries = flattenNav(entries, item.Children, navPath, childParent, rd)
+	}
+	return entries
+}
+
+func flattenNcx(entries []readNavEntry, points []readNcxNavPoint, ncxPath string, parent string, rd *epubReader) []readNavEntry {
+	for _, np := range points {
+		entry := readNavEntry{
+			title:  strings.TrimSpace(np.Text),
+			href:   rd.resolve(ncxPath, np.Content.Src),
+			parent: parent,
+		}
+		entries = append(entries, entry)
+		entries = flattenNcx(entries, np.Children, ncxPath, entry.href, rd)
+	}
+	return entries
+}
+
+// Rewrite the references matched by re in content found at origPath so they
+// point to the new location of the referenced files
+func (rd *epubReader) rewriteRefs(re *regexp.Regexp, content []byte, origPath string, fromCSS bool) []byte {
+	return re.ReplaceAllFunc(content, func(m []byte) []byte {
+		sub := re.FindSubmatchIndex(m)
+		// The last group is the (possibly quoted) reference
+		start, end := sub[len(sub)-2], sub[len(sub)-1]
+		ref := string(m[start:end])
+		quote := ""
+		if len(ref) >= 2 && (ref[0] == '"' || ref[0] == '\'') {
+			quote, ref = ref[:1], ref[1:len(ref)-1]
+		}
+		newRef := rd.rewriteRef(ref, origPath, fromCSS)
+		return []byte(string(m[:start]) + quote + newRef + quote + string(m[end:]))
+	})
+}
+
+// Rewrite a single reference found at origPath, leaving external and unknown
+// references alone. Sections and CSS files are both one folder deep, so only
+// references to sections depend on where they are made from.
+func (rd *epubReader) rewriteRef(ref string, origPath string, fromCSS bool) string {
+	u, err := url.Parse(ref)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" || strings.HasPrefix(ref, "#") {
+		return ref
+	}
+
+	target, ok := rd.renamed[rd.resolve(origPath, u.Path)]
+	if !ok {
+		return ref
+	}
+	// Sections are referenced by filename from other sections
+	if fromCSS && !strings.HasPrefix(target, "../") {
+		target = path.Join("..", xhtmlFolderName, target)
+	}
+
+	u.Path = ""
+	return target + u.String()
+}
+
+// Resolve a reference found in the file at base to a path inside the EPUB
+func (rd *epubReader) resolve(base string, ref string) string {
+	ref, _ = url.PathUnescape(ref)
+	if i := strings.IndexByte(ref, '#'); i >= 0 {
+		ref = ref[:i]
+	}
+	return path.Join(path.Dir(base), ref)
+}
+
+// Read the encryption file, if any, so obfuscated fonts are de-obfuscated
+// when they are read
+func (rd *epubReader) readEncryption() error {
+	encryptionFilePath := path.Join(metaInfFolderName, encryptionFilename)
+	if _, ok := rd.files[encryptionFilePath]; !ok {
+		return nil
+	}
+
+	var enc readEncryption
+	err := rd.unmarshal(encryptionFilePath, &enc)
+	if err != nil {
+		return err
+	}
+
+	rd.encrypted = make(map[string]string)
+	for _, data := range enc.EncryptedData {
+		// The URI is relative to the root of the container
+		name := rd.resolve("", data.CipherReference.URI)
+		rd.encrypted[name] = data.EncryptionMethod.Algorithm
+
+		// Obfuscate the fonts again when the EPUB is written
+		switch data.EncryptionMethod.Algorithm {
+		case obfuscationAlgorithmIDPF, obfuscationAlgorithmAdobe:
+			rd.e.SetFontObfuscation(true)
+		}
+	}
+
+	return nil
+}
+
+// Read a file from the EPUB, de-obfuscating it if it's an obfuscated font
+func (rd *epubReader) readFile(name string) ([]byte, error) {
+	f, ok := rd.files[name]
+	if !ok {
+		return nil, &UnableToReadEpubError{Filename: name, Err: os.ErrNotExist}
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, &UnableToReadEpubError{Filename: name, Err: err}
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, &UnableToReadEpubError{Filename: name, Err: err}
+	}
+
+	algorithm, ok := rd.encrypted[name]
+	if !ok {
+		return data, nil
+	}
+	var key []byte
+	length := 0
+	switch algorithm {
+	case obfuscationAlgorithmIDPF:
+		key, length = idpfObfuscationKey(rd.e.Identifier()), obfuscationLengthIDPF
+	case obfuscationAlgorithmAdobe:
+		key, length = adobeObfuscationKey(rd.e.Identifier()), obfuscationLengthAdobe
+	}
+	if key == nil {
+		return nil, &UnableToReadEpubError{
+			Filename: name,
+			Err:      &UnsupportedEncryptionError{Filename: name, Algorithm: algorithm},
+		}
+	}
+	obfuscate(data, 0, key, length)
+
+	return data, nil
+}
+
+func (rd *epubReader) unmarshal(name string, v interface{}) error {
+	data, err := rd.readFile(name)
+	if err != nil {
+		return err
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return &UnableToReadEpubError{Filename: name, Err: err}
+	}
+	return nil
+}
+
+// Unmarshal an XHTML document, tolerating HTML entities
+func unmarshalXhtml(data []byte, v interface{}) error {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	d.AutoClose = xml.HTMLAutoClose
+	return d.Decode(v)
+}
+
+// Get the text of an XML fragment
+func innerText(fragment string) string {
+	d := xml.NewDecoder(strings.NewReader(fragment))
+	d.Strict = false
+	d.Entity = xml.HTMLEntity
+	var sb strings.Builder
+	for {
+		t, err := d.Token()
+		if err != nil {
+			break
+		}
+		if c, ok := t.(xml.CharData); ok {
+			sb.Write(c)
+		}
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
+
+// Whether the section body shows nothing but the given image
+func isCoverBody(body string, imageFilename string) bool {
+	return strings.Count(body, "<img") == 1 &&
+		strings.Contains(body, "/"+imageFilename) &&
+		innerText(body) == ""
+}
+
+func isFontMediaType(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "font/") ||
+		strings.HasPrefix(mediaType, "application/font-") ||
+		strings.HasPrefix(mediaType, "application/x-font-") ||
+		mediaType == "application/vnd.ms-opentype"
+}
+
+// Whether a space-separated list of properties contains the given one
+func hasProperty(properties string, property string) bool {
+	for _, p := range strings.Fields(properties) {
+		if p == property {
+			return true
+		}
+	}
+	return false
+}
+
+func first(values []string) string {
+	if len(values) == 0 {
+		return ""
+	}
+	return strings.TrimSpace(values[0])
+}
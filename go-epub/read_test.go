@@ -0,0 +1,378 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+// Write the EPUB to memory
+func writeTestEpub(t *testing.T, e *Epub) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// Read an EPUB from memory
+func readTestEpub(t *testing.T, data []byte) *Epub {
+	t.Helper()
+	e, err := Read(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	return e
+}
+
+// Return the files of a ZIP archive by name
+func zipFiles(t *testing.T, data []byte) map[string][]byte {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %s", err)
+	}
+	files := make(map[string][]byte)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %s", f.Name, err)
+		}
+		files[f.Name], err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("read %s: %s", f.Name, err)
+		}
+	}
+	return files
+}
+
+// Return a copy of an EPUB with the content of one file changed by edit
+func editZipFile(t *testing.T, data []byte, name string, edit func(string) string) []byte {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %s", err)
+	}
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatalf("open %s: %s", f.Name, err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		if f.Name == name {
+			content = []byte(edit(string(content)))
+		}
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: f.Name, Method: f.Method})
+		if err != nil {
+			t.Fatalf("create %s: %s", f.Name, err)
+		}
+		fw.Write(content)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("zip: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// The parts of a package file compared between writes
+type testPackage struct {
+	Metadata struct {
+		Identifiers []string `xml:"identifier"`
+		Titles      []string `xml:"title"`
+		Languages   []string `xml:"language"`
+		Creators    []string `xml:"creator"`
+		Subjects    []string `xml:"subject"`
+		Publisher   string   `xml:"publisher"`
+		Description string   `xml:"description"`
+		Meta        []struct {
+			Property string `xml:"property,attr"`
+			Refines  string `xml:"refines,attr"`
+			Name     string `xml:"name,attr"`
+			Content  string `xml:"content,attr"`
+			Data     string `xml:",chardata"`
+		} `xml:"meta"`
+	} `xml:"metadata"`
+	Manifest []struct {
+		ID         string `xml:"id,attr"`
+		Href       string `xml:"href,attr"`
+		MediaType  string `xml:"media-type,attr"`
+		Fallback   string `xml:"fallback,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"manifest>item"`
+	Spine []struct {
+		Idref      string `xml:"idref,attr"`
+		Linear     string `xml:"linear,attr"`
+		Properties string `xml:"properties,attr"`
+	} `xml:"spine>itemref"`
+}
+
+func parseTestPackage(t *testing.T, files map[string][]byte) testPackage {
+	t.Helper()
+	var p testPackage
+	if err := xml.Unmarshal(files["EPUB/package.opf"], &p); err != nil {
+		t.Fatalf("package.opf: %s", err)
+	}
+	return p
+}
+
+// Return the TOC of a nav document as indented titles
+func navTitles(t *testing.T, files map[string][]byte) []string {
+	t.Helper()
+	type li struct {
+		Title    string `xml:"a"`
+		Children []li   `xml:"ol>li"`
+	}
+	var nav struct {
+		Navs []struct {
+			Type  string `xml:"type,attr"`
+			Items []li   `xml:"ol>li"`
+		} `xml:"body>nav"`
+	}
+	if err := xml.Unmarshal(files["EPUB/nav.xhtml"], &nav); err != nil {
+		t.Fatalf("nav.xhtml: %s", err)
+	}
+	var titles []string
+	var walk func(items []li, indent string)
+	walk = func(items []li, indent string) {
+		for _, item := range items {
+			titles = append(titles, indent+item.Title)
+			walk(item.Children, indent+"  ")
+		}
+	}
+	for _, n := range nav.Navs {
+		if n.Type == "toc" {
+			walk(n.Items, "")
+		}
+	}
+	return titles
+}
+
+var testModified = time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+
+// A book using most of what the package file, nav document and encryption
+// file can hold
+func newTestBook(t *testing.T) *Epub {
+	t.Helper()
+	e := NewEpub("Round Trip")
+	e.SetIdentifier("urn:uuid:7c4e2a5e-4b1d-4a4e-9a57-5c3e8d0f1a2b")
+	e.SetModified(testModified)
+	e.SetAuthor("Ann Author")
+	e.AddContributor(Contributor{Name: "Ed Editor", Role: "edt"})
+	e.SetLang("fr")
+	e.SetDescription("A book & its description")
+	e.SetPublisher("Publisher")
+	e.AddSubject("Testing")
+	e.AddSubject("EPUB")
+	e.SetSeries("The Series", "2")
+	e.SetFontObfuscation(true)
+
+	css, err := e.AddCSSBytes([]byte(`@font-face { font-family: "Go"; src: url("../fonts/go.ttf"); }
+body { font-family: "Go"; background: url("../images/dot.png"); }`), "book.css", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddFontBytes(goregular.TTF, "go.ttf", ""); err != nil {
+		t.Fatal(err)
+	}
+	dot, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "dot.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	video, err := e.AddMediaBytes([]byte("webm"), "clip.webm", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	one, err := e.AddSection(`<h1>One</h1><p><img src="`+dot+`" alt="dot" /><a href="notes.xhtml#n1">1</a></p>`, "One", "one.xhtml", css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oneA, err := e.AddSubSection(one, `<h2>One A</h2><video src="`+video+`"></video>`, "One A", "one-a.xhtml", css)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSubSection(oneA, `<h3>One A i</h3>`, "One A i", "one-a-i.xhtml", css, HeadElements(`<meta name="color-scheme" content="light" />`)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSubSection(one, `<h2>One B</h2>`, "One B", "one-b.xhtml", css); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddSection(`<h1>Two</h1><span epub:type="pagebreak" id="p2" aria-label="2"></span>`, "Two", "two.xhtml", css); err != nil {
+		t.Fatal(err)
+	}
+	notes, err := e.AddSection(`<p id="n1">A note</p>`, "Notes", "notes.xhtml", css, NonLinear())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetMediaFallback(video, notes); err != nil {
+		t.Fatal(err)
+	}
+
+	return e
+}
+
+func TestReadRoundTrip(t *testing.T) {
+	first := writeTestEpub(t, newTestBook(t))
+	e := readTestEpub(t, first)
+	// The modification date is that of the next write unless set again
+	e.SetModified(testModified)
+	second := writeTestEpub(t, e)
+
+	files1, files2 := zipFiles(t, first), zipFiles(t, second)
+	p1, p2 := parseTestPackage(t, files1), parseTestPackage(t, files2)
+	if !reflect.DeepEqual(p1.Metadata, p2.Metadata) {
+		t.Errorf("metadata changed:\n%+v\n%+v", p1.Metadata, p2.Metadata)
+	}
+	if !reflect.DeepEqual(p1.Manifest, p2.Manifest) {
+		t.Errorf("manifest changed:\n%+v\n%+v", p1.Manifest, p2.Manifest)
+	}
+	if !reflect.DeepEqual(p1.Spine, p2.Spine) {
+		t.Errorf("spine changed:\n%+v\n%+v", p1.Spine, p2.Spine)
+	}
+
+	wantToc := []string{"One", "  One A", "    One A i", "  One B", "Two", "Notes"}
+	for i, files := range []map[string][]byte{files1, files2} {
+		if got := navTitles(t, files); !reflect.DeepEqual(got, wantToc) {
+			t.Errorf("write %d: toc %q, want %q", i+1, got, wantToc)
+		}
+	}
+
+	// Everything else comes out the same too, down to the obfuscated font
+	for name, content := range files1 {
+		if !bytes.Equal(content, files2[name]) {
+			t.Errorf("%s changed", name)
+		}
+	}
+	if len(files1) != len(files2) {
+		t.Errorf("wrote %d files, then %d", len(files1), len(files2))
+	}
+
+	// The font is de-obfuscated when read
+	if font := e.fonts["go.ttf"]; font == nil || !bytes.Equal(font.data, goregular.TTF) {
+		t.Error("font not de-obfuscated")
+	}
+	if got := e.Author(); got != "Ann Author" {
+		t.Errorf("author %q", got)
+	}
+	if name, position := e.Series(); name != "The Series" || position != "2" {
+		t.Errorf("series %q %q", name, position)
+	}
+}
+
+func TestReadRemoteResources(t *testing.T) {
+	e := NewEpub("Remote")
+	e.SetIdentifier("urn:uuid:0f7a9ff4-9e63-4ac3-a1d6-0e0ff1f0c1d1")
+	e.AddSection(`<video src="https://example.com/v.mp4"></video>`, "Video", "video.xhtml", "", ManifestProperties(ManifestRemoteResources))
+	data := editZipFile(t, writeTestEpub(t, e), "EPUB/package.opf", func(opf string) string {
+		return strings.Replace(opf, "<manifest>", `<manifest><item id="remote" href="https://example.com/v.mp4" media-type="video/mp4"></item>`, 1)
+	})
+
+	read := readTestEpub(t, data)
+	files := zipFiles(t, writeTestEpub(t, read))
+	p := parseTestPackage(t, files)
+
+	found := false
+	for _, item := range p.Manifest {
+		if item.Href == "https://example.com/v.mp4" && item.MediaType == "video/mp4" {
+			found = true
+		}
+		if item.ID == "video.xhtml" && item.Properties != ManifestRemoteResources {
+			t.Errorf("section properties %q", item.Properties)
+		}
+	}
+	if !found {
+		t.Errorf("remote item dropped: %+v", p.Manifest)
+	}
+	for name := range files {
+		if strings.HasSuffix(name, ".mp4") {
+			t.Errorf("remote resource stored as %s", name)
+		}
+	}
+	if !strings.Contains(string(files["EPUB/xhtml/video.xhtml"]), `src="https://example.com/v.mp4"`) {
+		t.Error("reference to the remote resource changed")
+	}
+}
+
+func TestReadUnlistedSection(t *testing.T) {
+	data := editZipFile(t, writeTestEpub(t, newTestBook(t)), "EPUB/package.opf", func(opf string) string {
+		return strings.Replace(opf, `<itemref idref="two.xhtml"></itemref>`, "", 1)
+	})
+
+	e := readTestEpub(t, data)
+	// a section missing from the spine is kept out of the reading order
+	if s := findSection(e.sections, "two.xhtml"); s == nil || !s.nonLinear {
+		t.Fatalf("section not in the spine read as %+v, want non-linear", s)
+	}
+	if s := findSection(e.sections, "one.xhtml"); s == nil || s.nonLinear {
+		t.Errorf("section in the spine read as %+v, want linear", s)
+	}
+	opf := string(zipFiles(t, writeTestEpub(t, e))["EPUB/package.opf"])
+	if !strings.Contains(opf, `<itemref idref="two.xhtml" linear="no"></itemref>`) {
+		t.Errorf("section not written as non-linear:\n%s", opf)
+	}
+}
+
+func TestReadDefaultCSS(t *testing.T) {
+	e := newSectionBook(t)
+	base, err := e.AddCSSBytes([]byte(`body { margin: 0; }`), "base.css", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra, err := e.AddCSSBytes([]byte(`p { color: gray; }`), "extra.css", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "cover.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	e.SetDefaultCSS(base)
+	if _, err := e.AddSection("<p>D</p>", "D", "d.xhtml", extra); err != nil {
+		t.Fatal(err)
+	}
+	data := writeTestEpub(t, e)
+
+	r := readTestEpub(t, data)
+	if r.DefaultCSS() != base {
+		t.Errorf("read default stylesheet %q, want %q", r.DefaultCSS(), base)
+	}
+	if css := findSection(r.sections, "d.xhtml").xhtml.css(); !reflect.DeepEqual(css, []string{extra}) {
+		t.Errorf("section links %q, want only %q", css, extra)
+	}
+	// the sections link to the same stylesheets as before
+	written, read := zipFiles(t, writeTestEpub(t, e)), zipFiles(t, writeTestEpub(t, r))
+	for name, data := range written {
+		if strings.HasPrefix(name, "EPUB/xhtml/") && !bytes.Equal(read[name], data) {
+			t.Errorf("%s of the read book is written differently:\n%s\nwant\n%s", name, read[name], data)
+		}
+	}
+
+	// a section linking another stylesheet first leaves the default unset
+	e = newSectionBook(t)
+	if _, err := e.AddCSSBytes([]byte(`body { margin: 0; }`), "base.css", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"a.xhtml", "b.xhtml"} {
+		if err := e.ReplaceSection(filename, "<p>X</p>", "X", base); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if r := readTestEpub(t, writeTestEpub(t, e)); r.DefaultCSS() != "" {
+		t.Errorf("default stylesheet %q read from a book without one", r.DefaultCSS())
+	}
+}
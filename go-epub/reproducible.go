@@ -0,0 +1,147 @@
+package epub
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/uuid"
+)
+
+// Modification time of reproducible EPUBs if none is set, the earliest time
+// that can be stored in a ZIP file
+var defaultReproducibleTime = time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// Namespace of the identifiers derived from the content of reproducible EPUBs
+var reproducibleNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/bmaupin/go-epub")
+
+// SetReproducible sets whether the EPUB is written reproducibly, so that
+// writing the same content twice gives byte-identical files: unless set with
+// SetIdentifier, the identifier is a UUID derived from the content, and the
+// modification time is the one set with SetModified, or 1980-01-01 if none is
+// set.
+//
+// Reproducible mode is turned on by NewEpub if the SOURCE_DATE_EPOCH
+// environment variable holds a Unix timestamp, which is used as the
+// modification time.
+func (e *Epub) SetReproducible(reproducible bool) {
+	e.reproducible = reproducible
+}
+
+// Reproducible returns whether the EPUB is written reproducibly.
+func (e *Epub) Reproducible() bool {
+	return e.reproducible
+}
+
+// SetModified sets the modification time written to the package file and the
+// files of the EPUB container. If it isn't set, the time of writing is used.
+func (e *Epub) SetModified(t time.Time) {
+	e.modified = t.UTC()
+}
+
+// Modified returns the modification time set with SetModified or taken from
+// SOURCE_DATE_EPOCH, or the zero time if the time of writing will be used.
+func (e *Epub) Modified() time.Time {
+	return e.modified
+}
+
+// The modification time to write
+func (e *Epub) writeTime() time.Time {
+	switch {
+	case !e.modified.IsZero():
+		return e.modified
+	case e.reproducible:
+		return defaultReproducibleTime
+	default:
+		return time.Now().UTC().Truncate(time.Second)
+	}
+}
+
+// Return the time in SOURCE_DATE_EPOCH, if it is set
+func sourceDateEpoch() (time.Time, bool) {
+	epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(epoch, 0).UTC(), true
+}
+
+// Return an identifier derived from the metadata, sections and media files of
+// the EPUB. Media files are identified by their content, read from memory or
+// from their source; only a source that cannot be read is identified by its
+// name, and writing the EPUB will fail anyway.
+func (e *Epub) contentIdentifier() string {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "%q %q %q %q %q\n", e.title, e.subtitle, e.lang, e.desc, e.ppd)
+	fmt.Fprintf(h, "%q %q %q %q %q\n", e.publisher, e.date, e.rights, e.source, e.titleFileAs)
+	fmt.Fprintf(h, "%q %q %q %q %q\n", e.creators, e.contributors, e.collections, e.identifiers, e.subjects)
+	fmt.Fprintf(h, "toc %q %t %q\n", e.toc.heading, e.tocPage.enabled, e.tocPage.cssPath)
+	fmt.Fprintf(h, "fonts obfuscated %t\n", e.fontObfuscation)
+	fmt.Fprintf(h, "layout %q %q\n", e.layout, e.spread)
+	fmt.Fprintf(h, "default css %q\n", e.defaultCSS)
+	if len(e.pageCSS) > 0 {
+		fmt.Fprintf(h, "page css %q\n", e.pageCSS)
+	}
+
+	walkSections(e.sections, func(section *epubSection) {
+		// The title of the cover page is only set when the EPUB is written
+		title := section.xhtml.Title()
+		if section.filename == e.cover.xhtmlFilename {
+			title = ""
+		}
+		viewport := ""
+		if section.xhtml.xml.Head.Viewport != nil {
+			viewport = section.xhtml.xml.Head.Viewport.Content
+		}
+		fmt.Fprintf(h, "section %q %q %q %q %q %q %q %q %q\n", section.filename, title, viewport, section.spineLinear(), section.spineProperties(), section.manifestProperties, section.xhtml.css(), section.xhtml.xml.Head.Elements, section.xhtml.xml.Body.XML)
+	})
+
+	for _, folder := range []struct {
+		name  string
+		media map[string]*epubMedia
+	}{
+		{CSSFolderName, e.css},
+		{FontFolderName, e.fonts},
+		{ImageFolderName, e.images},
+		{MediaFolderName, e.media},
+	} {
+		filenames := make([]string, 0, len(folder.media))
+		for filename := range folder.media {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+
+		for _, filename := range filenames {
+			media := folder.media[filename]
+			fmt.Fprintf(h, "%s/%s %q %q ", folder.name, filename, media.mediaType, media.fallback)
+			e.hashMedia(h, media)
+			io.WriteString(h, "\n")
+		}
+	}
+
+	return urnUUIDPrefix + uuid.NewV5(reproducibleNamespace, fmt.Sprintf("%x", h.Sum(nil))).String()
+}
+
+// Write the content of a media file to the hash
+func (e *Epub) hashMedia(h io.Writer, media *epubMedia) {
+	if media.remote {
+		fmt.Fprintf(h, "remote %q", media.source)
+		return
+	}
+	r, err := e.openMedia(media)
+	if err != nil {
+		fmt.Fprintf(h, "source %q", media.source)
+		return
+	}
+	defer r.Close()
+
+	io.WriteString(h, "data ")
+	n, err := io.Copy(h, r)
+	// The length ends the content, so files can't run into each other
+	fmt.Fprintf(h, " %d %t", n, err == nil)
+}
@@ -0,0 +1,118 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testIdentifierRegexp = regexp.MustCompile(`<dc:identifier id="pub-id">([^<]*)</dc:identifier>`)
+
+// A book without an identifier of its own, built the same way every time
+func newReproducibleBook(t *testing.T, text string) *Epub {
+	t.Helper()
+	e := NewEpub("Reproducible")
+	e.SetAuthor("Author")
+	css, err := e.AddCSSBytes([]byte(`body { margin: 0; }`), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "", "image/png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"One", "Two"} {
+		if _, err := e.AddSection("<h1>"+title+"</h1><p>"+text+"</p>", title, "", css); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+// Return the identifier written to the package file
+func testIdentifier(t *testing.T, data []byte) string {
+	t.Helper()
+	m := testIdentifierRegexp.FindSubmatch(zipFiles(t, data)["EPUB/package.opf"])
+	if m == nil {
+		t.Fatal("no identifier in the package file")
+	}
+	return string(m[1])
+}
+
+func checkZipTimes(t *testing.T, data []byte, want time.Time) {
+	t.Helper()
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %s", err)
+	}
+	for _, f := range z.File {
+		if !f.Modified.Equal(want) {
+			t.Errorf("%s modified %s, want %s", f.Name, f.Modified, want)
+		}
+	}
+}
+
+func TestSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	epoch := time.Unix(1700000000, 0).UTC()
+
+	first := newReproducibleBook(t, "Text")
+	if !first.Reproducible() || !first.Modified().Equal(epoch) {
+		t.Fatalf("reproducible %t, modified %s", first.Reproducible(), first.Modified())
+	}
+	a := writeTestEpub(t, first)
+	// the random identifier of each new book must not show
+	b := writeTestEpub(t, newReproducibleBook(t, "Text"))
+	if !bytes.Equal(a, b) {
+		t.Error("writing the same book twice gives different files")
+	}
+
+	checkZipTimes(t, a, epoch)
+	if opf := string(zipFiles(t, a)["EPUB/package.opf"]); !strings.Contains(opf, ">2023-11-14T22:13:20Z<") {
+		t.Errorf("package file not modified at SOURCE_DATE_EPOCH:\n%s", opf)
+	}
+
+	id := testIdentifier(t, a)
+	if !strings.HasPrefix(id, "urn:uuid:") {
+		t.Errorf("identifier %q, want a urn:uuid", id)
+	}
+	if other := testIdentifier(t, writeTestEpub(t, newReproducibleBook(t, "Other text"))); other == id {
+		t.Errorf("books of different content share the identifier %q", id)
+	}
+}
+
+func TestSetReproducible(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+
+	write := func() []byte {
+		e := newReproducibleBook(t, "Text")
+		if e.Reproducible() {
+			t.Fatal("reproducible without SOURCE_DATE_EPOCH")
+		}
+		e.SetReproducible(true)
+		return writeTestEpub(t, e)
+	}
+	a := write()
+	b := write()
+	if !bytes.Equal(a, b) {
+		t.Error("writing the same book twice gives different files")
+	}
+	checkZipTimes(t, a, defaultReproducibleTime)
+	if id := testIdentifier(t, a); !strings.HasPrefix(id, "urn:uuid:") || id != testIdentifier(t, b) {
+		t.Errorf("identifiers %q and %q, want the same urn:uuid", id, testIdentifier(t, b))
+	}
+
+	// an identifier that was set is kept
+	e := newReproducibleBook(t, "Text")
+	e.SetReproducible(true)
+	e.SetIdentifier("urn:isbn:9780000000002")
+	if id := testIdentifier(t, writeTestEpub(t, e)); id != "urn:isbn:9780000000002" {
+		t.Errorf("identifier %q, want the one set", id)
+	}
+}
@@ -0,0 +1,197 @@
+package epub
+
+import (
+	"fmt"
+)
+
+// SectionDoesNotExistError is thrown by InsertSection, MoveSection,
+// MoveSubSection, RemoveSection, ReplaceSection, SetSectionViewport,
+// SetSectionPageSpread or SetSectionLinear if no section or subsection with
+// the given filename has been added.
+type SectionDoesNotExistError struct {
+	Filename string // Filename of the section
+}
+
+func (e *SectionDoesNotExistError) Error() string {
+	return fmt.Sprintf("Section with the internal filename %s does not exist", e.Filename)
+}
+
+// InsertSection adds a section right before the section with the given
+// filename (as returned by AddSection or AddSubSection), at the same level:
+// if that section is a subsection, the new section becomes a subsection of the
+// same parent. It returns the internal filename of the new section.
+//
+// The body, title, internal filename, CSS path and options follow the same
+// rules as for AddSection. If there is no section with the given filename,
+// SectionDoesNotExistError will be returned.
+func (e *Epub) InsertSection(beforeFilename string, body string, sectionTitle string, internalFilename string, internalCSSPath string, options ...SectionOption) (string, error) {
+	list, index := locateSection(&e.sections, beforeFilename)
+	if list == nil {
+		return "", &SectionDoesNotExistError{Filename: beforeFilename}
+	}
+
+	s, err := e.newSection(body, sectionTitle, internalFilename, internalCSSPath, options)
+	if err != nil {
+		return "", err
+	}
+	insertSection(list, index, s)
+
+	return s.filename, nil
+}
+
+// MoveSection moves the section with the given filename, along with its
+// subsections, right before the section with the before filename, at the same
+// level. If the before filename is empty, the section is moved to the end of
+// the EPUB at the top level.
+//
+// If either section doesn't exist, SectionDoesNotExistError will be returned.
+// A section cannot be moved before one of its own subsections, which is
+// reported the same way.
+func (e *Epub) MoveSection(filename string, beforeFilename string) error {
+	return e.moveSection(filename, func(s *epubSection) bool {
+		if beforeFilename == "" {
+			e.sections = append(e.sections, s)
+			return true
+		}
+		list, index := locateSection(&e.sections, beforeFilename)
+		if list == nil {
+			return false
+		}
+		insertSection(list, index, s)
+		return true
+	}, beforeFilename)
+}
+
+// MoveSubSection moves the section with the given filename, along with its
+// subsections, to the end of the subsections of the section with the parent
+// filename.
+//
+// If either section doesn't exist, SectionDoesNotExistError will be returned.
+// A section cannot be moved below one of its own subsections, which is
+// reported the same way.
+func (e *Epub) MoveSubSection(filename string, parentFilename string) error {
+	return e.moveSection(filename, func(s *epubSection) bool {
+		parent := findSection(e.sections, parentFilename)
+		if parent == nil {
+			return false
+		}
+		parent.children = append(parent.children, s)
+		return true
+	}, parentFilename)
+}
+
+// RemoveSection removes the section with the given filename along with its
+// subsections. If the cover page is removed, the cover image is kept but no
+// cover page will be written.
+//
+// If there is no section with the given filename, SectionDoesNotExistError
+// will be returned.
+func (e *Epub) RemoveSection(filename string) error {
+	list, index := locateSection(&e.sections, filename)
+	if list == nil {
+		return &SectionDoesNotExistError{Filename: filename}
+	}
+
+	removed := (*list)[index]
+	*list = append((*list)[:index], (*list)[index+1:]...)
+
+	walkSections([]*epubSection{removed}, func(section *epubSection) {
+		if section.filename == e.cover.xhtmlFilename {
+			e.cover.xhtmlFilename = ""
+		}
+	})
+
+	return nil
+}
+
+// ReplaceSection replaces the body, title, CSS and head elements of the section
+// with the given filename. The section keeps its filename, its place in the
+// reading order, its subsections, its viewport, page spread and other options.
+// The body, title, CSS path and options follow the same rules as for
+// AddSection.
+//
+// If there is no section with the given filename, SectionDoesNotExistError
+// will be returned.
+func (e *Epub) ReplaceSection(filename string, body string, sectionTitle string, internalCSSPath string, options ...SectionOption) error {
+	s := findSection(e.sections, filename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: filename}
+	}
+
+	x := newXhtml(body)
+	x.setTitle(sectionTitle)
+
+	if internalCSSPath != "" {
+		x.setCSS(internalCSSPath)
+	}
+	x.xml.Head.Viewport = s.xhtml.xml.Head.Viewport
+	s.xhtml = x
+	for _, option := range options {
+		option(s)
+	}
+
+	return nil
+}
+
+// Detach a section and place it again using place. If place fails, the
+// section is put back where it was and SectionDoesNotExistError is returned
+// for the target filename.
+func (e *Epub) moveSection(filename string, place func(s *epubSection) bool, targetFilename string) error {
+	list, index := locateSection(&e.sections, filename)
+	if list == nil {
+		return &SectionDoesNotExistError{Filename: filename}
+	}
+
+	s := (*list)[index]
+	*list = append((*list)[:index], (*list)[index+1:]...)
+
+	// The section's own subsections can no longer be found once detached
+	if !place(s) {
+		insertSection(list, index, s)
+		return &SectionDoesNotExistError{Filename: targetFilename}
+	}
+
+	return nil
+}
+
+// Return the section or subsection with the given filename, or nil
+func findSection(sections []*epubSection, filename string) *epubSection {
+	for _, section := range sections {
+		if section.filename == filename {
+			return section
+		}
+		if s := findSection(section.children, filename); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// Call fn for each section and subsection in reading order
+func walkSections(sections []*epubSection, fn func(section *epubSection)) {
+	for _, section := range sections {
+		fn(section)
+		walkSections(section.children, fn)
+	}
+}
+
+// Return the list holding the section or subsection with the given filename,
+// and its index in that list, or nil if there is no such section
+func locateSection(sections *[]*epubSection, filename string) (*[]*epubSection, int) {
+	for i, section := range *sections {
+		if section.filename == filename {
+			return sections, i
+		}
+		if list, index := locateSection(&section.children, filename); list != nil {
+			return list, index
+		}
+	}
+	return nil, -1
+}
+
+// Insert a section into the list at the given index
+func insertSection(list *[]*epubSection, index int, s *epubSection) {
+	*list = append(*list, nil)
+	copy((*list)[index+1:], (*list)[index:])
+	(*list)[index] = s
+}
@@ -0,0 +1,255 @@
+package epub
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Return the sections of the EPUB as filenames indented by level
+func sectionTree(e *Epub) []string {
+	var tree []string
+	var walk func(sections []*epubSection, indent string)
+	walk = func(sections []*epubSection, indent string) {
+		for _, s := range sections {
+			tree = append(tree, indent+s.filename)
+			walk(s.children, indent+"  ")
+		}
+	}
+	walk(e.sections, "")
+	return tree
+}
+
+// A book of sections a, b and c, where a has subsections a1 and a2, and a1
+// has a subsection a1x
+func newSectionBook(t *testing.T) *Epub {
+	t.Helper()
+	e := NewEpub("Sections")
+	e.SetIdentifier("urn:uuid:3d0a5a3e-0f43-4b8e-8c0c-2f6f0f4a8c11")
+	for _, s := range []struct{ parent, filename string }{
+		{"", "a.xhtml"},
+		{"a.xhtml", "a1.xhtml"},
+		{"a1.xhtml", "a1x.xhtml"},
+		{"a.xhtml", "a2.xhtml"},
+		{"", "b.xhtml"},
+		{"", "c.xhtml"},
+	} {
+		title := strings.ToUpper(strings.TrimSuffix(s.filename, ".xhtml"))
+		var err error
+		if s.parent == "" {
+			_, err = e.AddSection("<p>"+title+"</p>", title, s.filename, "")
+		} else {
+			_, err = e.AddSubSection(s.parent, "<p>"+title+"</p>", title, s.filename, "")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return e
+}
+
+func checkSectionTree(t *testing.T, e *Epub, want []string) {
+	t.Helper()
+	if got := sectionTree(e); !reflect.DeepEqual(got, want) {
+		t.Errorf("sections %q, want %q", got, want)
+	}
+}
+
+// Check the written table of contents, which follows the sections
+func checkToc(t *testing.T, e *Epub, want []string) {
+	t.Helper()
+	if got := navTitles(t, zipFiles(t, writeTestEpub(t, e))); !reflect.DeepEqual(got, want) {
+		t.Errorf("toc %q, want %q", got, want)
+	}
+}
+
+func checkSectionDoesNotExist(t *testing.T, err error, filename string) {
+	t.Helper()
+	var notExist *SectionDoesNotExistError
+	if !errors.As(err, &notExist) || notExist.Filename != filename {
+		t.Errorf("got %v, want SectionDoesNotExistError for %s", err, filename)
+	}
+}
+
+func TestInsertSection(t *testing.T) {
+	e := newSectionBook(t)
+
+	if _, err := e.InsertSection("b.xhtml", "<p>Top</p>", "Top", "top.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	// Before a subsection, the new section is a subsection too
+	if _, err := e.InsertSection("a1x.xhtml", "<p>Sub</p>", "Sub", "sub.xhtml", "", NonLinear()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.InsertSection("a.xhtml", "<p>First</p>", "First", "first.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"first.xhtml", "a.xhtml", "  a1.xhtml", "    sub.xhtml", "    a1x.xhtml", "  a2.xhtml", "top.xhtml", "b.xhtml", "c.xhtml"})
+	checkToc(t, e, []string{"First", "A", "  A1", "    Sub", "    A1X", "  A2", "Top", "B", "C"})
+	if s := findSection(e.sections, "sub.xhtml"); s == nil || !s.nonLinear {
+		t.Error("options of the inserted section not applied")
+	}
+
+	_, err := e.InsertSection("missing.xhtml", "<p>X</p>", "X", "x.xhtml", "")
+	checkSectionDoesNotExist(t, err, "missing.xhtml")
+	var used *FilenameAlreadyUsedError
+	if _, err := e.InsertSection("b.xhtml", "<p>X</p>", "X", "c.xhtml", ""); !errors.As(err, &used) {
+		t.Errorf("got %v, want FilenameAlreadyUsedError", err)
+	}
+	if findSection(e.sections, "x.xhtml") != nil {
+		t.Error("section inserted despite the error")
+	}
+}
+
+func TestMoveSection(t *testing.T) {
+	e := newSectionBook(t)
+
+	// Subsections move along
+	if err := e.MoveSection("a.xhtml", "c.xhtml"); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"b.xhtml", "a.xhtml", "  a1.xhtml", "    a1x.xhtml", "  a2.xhtml", "c.xhtml"})
+
+	// Before a subsection, the section becomes a subsection
+	if err := e.MoveSection("c.xhtml", "a1.xhtml"); err != nil {
+		t.Fatal(err)
+	}
+	// An empty before filename moves to the end at the top level
+	if err := e.MoveSection("a1x.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"b.xhtml", "a.xhtml", "  c.xhtml", "  a1.xhtml", "  a2.xhtml", "a1x.xhtml"})
+	checkToc(t, e, []string{"B", "A", "  C", "  A1", "  A2", "A1X"})
+
+	// Moving a section before itself or its own descendant fails and leaves
+	// it in place
+	before := sectionTree(e)
+	for _, target := range []string{"a.xhtml", "a1.xhtml", "missing.xhtml"} {
+		checkSectionDoesNotExist(t, e.MoveSection("a.xhtml", target), target)
+		checkSectionTree(t, e, before)
+	}
+	checkSectionDoesNotExist(t, e.MoveSection("missing.xhtml", "b.xhtml"), "missing.xhtml")
+	checkSectionTree(t, e, before)
+}
+
+func TestMoveSubSection(t *testing.T) {
+	e := newSectionBook(t)
+
+	if err := e.MoveSubSection("c.xhtml", "a1.xhtml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.MoveSubSection("a2.xhtml", "b.xhtml"); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"a.xhtml", "  a1.xhtml", "    a1x.xhtml", "    c.xhtml", "b.xhtml", "  a2.xhtml"})
+	checkToc(t, e, []string{"A", "  A1", "    A1X", "    C", "B", "  A2"})
+
+	// A section cannot go under itself or its own descendant
+	before := sectionTree(e)
+	for _, target := range []string{"a.xhtml", "a1.xhtml", "c.xhtml", "missing.xhtml"} {
+		checkSectionDoesNotExist(t, e.MoveSubSection("a.xhtml", target), target)
+		checkSectionTree(t, e, before)
+	}
+	checkSectionDoesNotExist(t, e.MoveSubSection("a1.xhtml", "a1x.xhtml"), "a1x.xhtml")
+	checkSectionTree(t, e, before)
+
+	// Moving a subsection back to the top level
+	if err := e.MoveSection("a1.xhtml", ""); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"a.xhtml", "b.xhtml", "  a2.xhtml", "a1.xhtml", "  a1x.xhtml", "  c.xhtml"})
+}
+
+func TestRemoveSection(t *testing.T) {
+	e := newSectionBook(t)
+
+	if err := e.RemoveSection("a1.xhtml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.RemoveSection("c.xhtml"); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"a.xhtml", "  a2.xhtml", "b.xhtml"})
+	checkToc(t, e, []string{"A", "  A2", "B"})
+	// The filenames of removed sections can be used again
+	if _, err := e.AddSection("<p>New</p>", "New", "a1x.xhtml", ""); err != nil {
+		t.Errorf("filename of a removed subsection: %s", err)
+	}
+
+	checkSectionDoesNotExist(t, e.RemoveSection("a1.xhtml"), "a1.xhtml")
+}
+
+func TestRemoveCover(t *testing.T) {
+	e := newSectionBook(t)
+	image, err := e.AddImageBytes([]byte("\x89PNG\r\n\x1a\n"), "cover.png", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	cover := e.cover.xhtmlFilename
+	if cover == "" {
+		t.Fatal("no cover page")
+	}
+
+	if err := e.RemoveSection(cover); err != nil {
+		t.Fatal(err)
+	}
+	if e.cover.xhtmlFilename != "" {
+		t.Error("cover page still set")
+	}
+
+	files := zipFiles(t, writeTestEpub(t, e))
+	if _, ok := files["EPUB/xhtml/"+cover]; ok {
+		t.Error("removed cover page written")
+	}
+	if nav := string(files["EPUB/nav.xhtml"]); strings.Contains(nav, `epub:type="cover"`) {
+		t.Error("landmarks still point to the cover page")
+	}
+	opf := string(files["EPUB/package.opf"])
+	if !strings.Contains(opf, `href="images/cover.png"`) || !strings.Contains(opf, `properties="cover-image"`) {
+		t.Errorf("cover image dropped:\n%s", opf)
+	}
+	checkToc(t, e, []string{"A", "  A1", "    A1X", "  A2", "B", "C"})
+
+	// A cover can be set again
+	if err := e.SetCover(image, ""); err != nil {
+		t.Fatal(err)
+	}
+	if e.cover.xhtmlFilename == "" || findSection(e.sections, e.cover.xhtmlFilename) == nil {
+		t.Errorf("no cover page after setting the cover again: %q", sectionTree(e))
+	}
+}
+
+func TestReplaceSection(t *testing.T) {
+	e := newSectionBook(t)
+	if err := e.SetSectionViewport("a.xhtml", 600, 800); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.SetSectionPageSpread("a.xhtml", PageSpreadRight); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.ReplaceSection("a.xhtml", "<p>Replaced</p>", "Replaced", "", NonLinear()); err != nil {
+		t.Fatal(err)
+	}
+	checkSectionTree(t, e, []string{"a.xhtml", "  a1.xhtml", "    a1x.xhtml", "  a2.xhtml", "b.xhtml", "c.xhtml"})
+	checkToc(t, e, []string{"Replaced", "  A1", "    A1X", "  A2", "B", "C"})
+
+	s := findSection(e.sections, "a.xhtml")
+	if !s.nonLinear || s.pageSpread != PageSpreadRight {
+		t.Errorf("options lost: non-linear %t, spread %q", s.nonLinear, s.pageSpread)
+	}
+	files := zipFiles(t, writeTestEpub(t, e))
+	page := string(files["EPUB/xhtml/a.xhtml"])
+	if !strings.Contains(page, "<p>Replaced</p>") || strings.Contains(page, "<p>A</p>") {
+		t.Errorf("body not replaced:\n%s", page)
+	}
+	if !strings.Contains(page, `content="width=600, height=800"`) {
+		t.Errorf("viewport lost:\n%s", page)
+	}
+
+	checkSectionDoesNotExist(t, e.ReplaceSection("missing.xhtml", "<p>X</p>", "X", ""), "missing.xhtml")
+}
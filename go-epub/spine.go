@@ -0,0 +1,161 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+const (
+	pkgItemrefLinearNo = "no"
+)
+
+// Properties of the manifest item of a section, set with ManifestProperties.
+const (
+	ManifestScripted        = "scripted"         // The section contains scripts or forms
+	ManifestRemoteResources = "remote-resources" // The section uses files that aren't in the EPUB
+	ManifestSVG             = "svg"              // The section embeds SVG
+	ManifestMathML          = "mathml"           // The section embeds MathML
+)
+
+// MediaDoesNotExistError is thrown by SetMediaFallback if no file or section
+// with the given path has been added.
+type MediaDoesNotExistError struct {
+	Filename string // Internal path of the file
+}
+
+func (e *MediaDoesNotExistError) Error() string {
+	return fmt.Sprintf("Media with the internal path %s does not exist", e.Filename)
+}
+
+// SectionOption sets how a section added with AddSection, AddSubSection,
+// InsertSection or ReplaceSection appears in the reading order, or adds to its
+// head.
+type SectionOption func(s *epubSection)
+
+// NonLinear marks the section as outside the main reading order, such as
+// footnotes or answers that are only reached through links. Reading systems
+// may skip it when paging through the EPUB.
+func NonLinear() SectionOption {
+	return func(s *epubSection) {
+		s.nonLinear = true
+	}
+}
+
+// SpineProperties adds properties to the entry of the section in the reading
+// order, such as "rendition:layout-reflowable" for a page that reflows in a
+// pre-paginated EPUB. Page spreads are set with PageSpread instead.
+func SpineProperties(properties ...string) SectionOption {
+	return func(s *epubSection) {
+		s.properties = addProperties(s.properties, properties)
+	}
+}
+
+// ManifestProperties adds properties to the manifest item of the section, such
+// as ManifestScripted for a section with scripts or ManifestRemoteResources for
+// one that shows images, audio or video from the web.
+func ManifestProperties(properties ...string) SectionOption {
+	return func(s *epubSection) {
+		s.manifestProperties = addProperties(s.manifestProperties, properties)
+	}
+}
+
+// PageSpread sets the side of the spread the section is shown on, the same
+// way as SetSectionPageSpread.
+func PageSpread(spread string) SectionOption {
+	return func(s *epubSection) {
+		s.pageSpread = spread
+	}
+}
+
+// SetSectionLinear sets whether the section with the given filename is part of
+// the main reading order. Sections are linear unless added with NonLinear.
+//
+// If there is no section with the given filename, SectionDoesNotExistError
+// will be returned.
+func (e *Epub) SetSectionLinear(filename string, linear bool) error {
+	s := findSection(e.sections, filename)
+	if s == nil {
+		return &SectionDoesNotExistError{Filename: filename}
+	}
+	s.nonLinear = !linear
+
+	return nil
+}
+
+// SetMediaFallback sets the file reading systems use in place of the file with
+// the given internal path (as returned by AddCSS, AddFont, AddImage or
+// AddMedia) if they can't show it, as media that isn't a core media type of
+// EPUB needs. The fallback is the internal path of another file, or the
+// filename of a section (as returned by AddSection). An empty fallback removes
+// it.
+//
+// If either file doesn't exist, MediaDoesNotExistError will be returned.
+func (e *Epub) SetMediaFallback(internalPath string, fallbackPath string) error {
+	media := e.mediaByPath(internalPath)
+	if media == nil {
+		return &MediaDoesNotExistError{Filename: internalPath}
+	}
+	if fallbackPath == "" {
+		media.fallback = ""
+		return nil
+	}
+
+	fallback := path.Base(fallbackPath)
+	if fallback == path.Base(internalPath) || (e.mediaByPath(fallbackPath) == nil && findSection(e.sections, fallback) == nil) {
+		return &MediaDoesNotExistError{Filename: fallbackPath}
+	}
+	media.fallback = fallback
+
+	return nil
+}
+
+// Return the media file with the given internal path, or nil
+// Ex: ../images/image0001.png
+func (e *Epub) mediaByPath(internalPath string) *epubMedia {
+	folders := map[string]map[string]*epubMedia{
+		CSSFolderName:   e.css,
+		FontFolderName:  e.fonts,
+		ImageFolderName: e.images,
+		MediaFolderName: e.media,
+	}
+	mediaMap, ok := folders[path.Base(path.Dir(internalPath))]
+	if !ok {
+		return nil
+	}
+	return mediaMap[path.Base(internalPath)]
+}
+
+// Return the properties of the spine item of the section
+func (s *epubSection) spineProperties() string {
+	var properties []string
+	if s.pageSpread != "" {
+		properties = append(properties, s.pageSpread)
+	}
+	for _, property := range s.properties {
+		if property != s.pageSpread {
+			properties = append(properties, property)
+		}
+	}
+	return strings.Join(properties, " ")
+}
+
+// Add the space-separated properties that aren't in the list yet
+func addProperties(list []string, properties []string) []string {
+	for _, property := range properties {
+		for _, p := range strings.Fields(property) {
+			if !hasProperty(strings.Join(list, " "), p) {
+				list = append(list, p)
+			}
+		}
+	}
+	return list
+}
+
+// Return the linear attribute of the spine item of the section
+func (s *epubSection) spineLinear() string {
+	if s.nonLinear {
+		return pkgItemrefLinearNo
+	}
+	return ""
+}
@@ -3,15 +3,14 @@ package epub
 import (
 	"encoding/xml"
 	"fmt"
-	"os"
-	"path/filepath"
+	"path"
 	"strconv"
 )
 
 const (
 	tocNavBodyTemplate = `
     <nav epub:type="toc">
-      <h1>Table of Contents</h1>
+      <h1></h1>
       <ol>
       </ol>
     </nav>
@@ -21,6 +20,19 @@ const (
 	tocNavItemProperties = "nav"
 	tocNavEpubType       = "toc"
 
+	tocLandmarksEpubType = "landmarks"
+	tocLandmarksTitle    = "Landmarks"
+	tocPageListEpubType  = "page-list"
+	tocPageListTitle     = "Pages"
+
+	// Titles of the landmarks, which are also used for the EPUB v2 guide
+	tocBodymatterTitle = "Start of Content"
+	tocCoverTitle      = "Cover"
+	tocTitle           = "Table of Contents"
+
+	tocPageFilename = "toc.xhtml"
+	tocPageID       = "toc-page"
+
 	tocNcxFilename = "toc.ncx"
 	tocNcxItemID   = "ncx"
 	tocNcxTemplate = `
@@ -54,24 +66,73 @@ type toc struct {
 	// Spec: http://www.idpf.org/epub/20/spec/OPF_2.0.1_draft.htm#Section2.4.1
 	ncxXML *tocNcxRoot
 
-	title string // EPUB title
+	// These hold the landmarks and page list navs, which are written to the EPUB
+	// v3 TOC file after the TOC itself. They are hidden from the reader and only
+	// written if they have entries
+	//
+	// Spec: https://www.w3.org/TR/epub-33/#sec-nav-landmarks
+	// Spec: https://www.w3.org/TR/epub-33/#sec-nav-pagelist
+	landmarksXML *tocNavBody
+	pageListXML  *tocNavBody
+
+	// The sections listed in the TOC, from which navXML and ncxXML are built
+	entries []*tocEntry
+	// The key parts of the EPUB (cover, TOC, start of the content)
+	landmarks []tocLandmark
+	// The page breaks of the sections, in reading order
+	pages []*tocEntry
+
+	title   string // EPUB title
+	heading string // Heading of the TOC, ex: Table of Contents
+}
+
+// A section listed in the TOC, with the subsections nested below it
+type tocEntry struct {
+	title        string
+	relativePath string
+	children     []*tocEntry
+}
+
+// A landmark of the EPUB, with its structural semantics (e.g. cover, toc or
+// bodymatter)
+type tocLandmark struct {
+	epubType     string
+	title        string
+	relativePath string
 }
 
 type tocNavBody struct {
 	XMLName  xml.Name     `xml:"nav"`
 	EpubType string       `xml:"epub:type,attr"`
+	Hidden   string       `xml:"hidden,attr,omitempty"`
 	H1       string       `xml:"h1"`
 	Links    []tocNavItem `xml:"ol>li"`
 }
 
 type tocNavItem struct {
-	A tocNavLink `xml:"a"`
+	A        tocNavLink  `xml:"a"`
+	Children *tocNavList `xml:"ol,omitempty"`
+}
+
+// This holds the body XML of the TOC page, which lists the same entries as the
+// EPUB v3 TOC file but is part of the reading order
+type tocPageBody struct {
+	XMLName xml.Name     `xml:"nav"`
+	ID      string       `xml:"id,attr"`
+	H1      string       `xml:"h1"`
+	Links   []tocNavItem `xml:"ol>li"`
+}
+
+// The nested list of a TOC entry with subsections
+type tocNavList struct {
+	Links []tocNavItem `xml:"li"`
 }
 
 type tocNavLink struct {
-	XMLName xml.Name `xml:"a"`
-	Href    string   `xml:"href,attr"`
-	Data    string   `xml:",chardata"`
+	XMLName  xml.Name `xml:"a"`
+	EpubType string   `xml:"epub:type,attr,omitempty"`
+	Href     string   `xml:"href,attr"`
+	Data     string   `xml:",chardata"`
 }
 
 type tocNcxRoot struct {
@@ -92,10 +153,12 @@ type tocNcxMeta struct {
 }
 
 type tocNcxNavPoint struct {
-	XMLName xml.Name      `xml:"navPoint"`
-	ID      string        `xml:"id,attr"`
-	Text    string        `xml:"navLabel>text"`
-	Content tocNcxContent `xml:"content"`
+	XMLName   xml.Name         `xml:"navPoint"`
+	ID        string           `xml:"id,attr"`
+	PlayOrder int              `xml:"playOrder,attr"`
+	Text      string           `xml:"navLabel>text"`
+	Content   tocNcxContent    `xml:"content"`
+	Children  []tocNcxNavPoint `xml:"navPoint"`
 }
 
 // Constructor for toc
@@ -106,6 +169,20 @@ func newToc() *toc {
 
 	t.ncxXML = newTocNcxXML()
 
+	t.setHeading(tocTitle)
+
+	t.landmarksXML = &tocNavBody{
+		EpubType: tocLandmarksEpubType,
+		Hidden:   "hidden",
+		H1:       tocLandmarksTitle,
+	}
+
+	t.pageListXML = &tocNavBody{
+		EpubType: tocPageListEpubType,
+		Hidden:   "hidden",
+		H1:       tocPageListTitle,
+	}
+
 	return t
 }
 
@@ -146,25 +223,105 @@ func newTocNcxXML() *tocNcxRoot {
 	return n
 }
 
-// Add a section to the TOC (navXML as well as ncxXML)
-func (t *toc) addSection(index int, title string, relativePath string) {
-	relativePath = filepath.ToSlash(relativePath)
-	l := &tocNavItem{
-		A: tocNavLink{
-			Href: relativePath,
-			Data: title,
-		},
+// Add a section to the TOC, at the top level or below parent if it isn't nil,
+// and return its entry
+func (t *toc) addSection(parent *tocEntry, title string, relativePath string) *tocEntry {
+	entry := &tocEntry{
+		title:        title,
+		relativePath: relativePath,
+	}
+	if parent == nil {
+		t.entries = append(t.entries, entry)
+	} else {
+		parent.children = append(parent.children, entry)
+	}
+	return entry
+}
+
+// Add a landmark to the TOC
+func (t *toc) addLandmark(epubType string, title string, relativePath string) {
+	t.landmarks = append(t.landmarks, tocLandmark{
+		epubType:     epubType,
+		title:        title,
+		relativePath: relativePath,
+	})
+}
+
+// Add a page break to the page list, labelled with its page number
+func (t *toc) addPage(label string, relativePath string) {
+	t.pages = append(t.pages, &tocEntry{
+		title:        label,
+		relativePath: relativePath,
+	})
+}
+
+// Remove all sections, landmarks and pages from the TOC so they can be added
+// again
+func (t *toc) clearSections() {
+	t.entries = nil
+	t.landmarks = nil
+	t.pages = nil
+}
+
+// Build navXML and ncxXML from the TOC entries. NCX navPoints are numbered in
+// reading order, which is the order of the entries when walked depth-first.
+func (t *toc) build() {
+	playOrder := 0
+
+	var build func(entries []*tocEntry) ([]tocNavItem, []tocNcxNavPoint)
+	build = func(entries []*tocEntry) ([]tocNavItem, []tocNcxNavPoint) {
+		var links []tocNavItem
+		var navPoints []tocNcxNavPoint
+		for _, entry := range entries {
+			playOrder++
+			np := tocNcxNavPoint{
+				ID:        "navPoint-" + strconv.Itoa(playOrder),
+				PlayOrder: playOrder,
+				Text:      entry.title,
+				Content: tocNcxContent{
+					Src: entry.relativePath,
+				},
+			}
+			l := tocNavItem{
+				A: tocNavLink{
+					Href: entry.relativePath,
+					Data: entry.title,
+				},
+			}
+			var children []tocNavItem
+			children, np.Children = build(entry.children)
+			if len(children) > 0 {
+				l.Children = &tocNavList{Links: children}
+			}
+
+			links = append(links, l)
+			navPoints = append(navPoints, np)
+		}
+		return links, navPoints
 	}
-	t.navXML.Links = append(t.navXML.Links, *l)
 
-	np := &tocNcxNavPoint{
-		ID:   "navPoint-" + strconv.Itoa(index),
-		Text: title,
-		Content: tocNcxContent{
-			Src: relativePath,
-		},
+	t.navXML.Links, t.ncxXML.NavMap = build(t.entries)
+
+	t.landmarksXML.Links = nil
+	for _, landmark := range t.landmarks {
+		t.landmarksXML.Links = append(t.landmarksXML.Links, tocNavItem{
+			A: tocNavLink{
+				EpubType: landmark.epubType,
+				Href:     landmark.relativePath,
+				Data:     landmark.title,
+			},
+		})
+	}
+
+	t.pageListXML.Links = nil
+	for _, page := range t.pages {
+		t.pageListXML.Links = append(t.pageListXML.Links, tocNavItem{
+			A: tocNavLink{
+				Href: page.relativePath,
+				Data: page.title,
+			},
+		})
 	}
-	t.ncxXML.NavMap = append(t.ncxXML.NavMap, *np)
 }
 
 func (t *toc) setIdentifier(identifier string) {
@@ -175,42 +332,96 @@ func (t *toc) setTitle(title string) {
 	t.title = title
 }
 
+func (t *toc) setHeading(heading string) {
+	t.heading = heading
+	t.navXML.H1 = heading
+}
+
 // Write the TOC files
-func (t *toc) write(tempDir string) {
-	t.writeNavDoc(tempDir)
-	t.writeNcxDoc(tempDir)
+func (t *toc) write(z *zipWriter) error {
+	t.build()
+
+	err := t.writeNavDoc(z)
+	if err != nil {
+		return err
+	}
+
+	return t.writeNcxDoc(z)
 }
 
-// Write the the EPUB v3 TOC file (nav.xhtml) to the temporary directory
-func (t *toc) writeNavDoc(tempDir string) {
+// Write the the EPUB v3 TOC file (nav.xhtml) to the EPUB
+func (t *toc) writeNavDoc(z *zipWriter) error {
+	navFilePath := path.Join(contentFolderName, tocNavFilename)
+
 	navBodyContent, err := xml.MarshalIndent(t.navXML, "    ", "  ")
 	if err != nil {
-		panic(fmt.Sprintf(
-			"Error marshalling XML for EPUB v3 TOC file: %s\n"+
-				"\tXML=%#v",
-			err,
-			t.navXML))
+		return &UnableToWriteEpubError{Filename: navFilePath, Err: err}
+	}
+
+	// The landmarks and page list follow the TOC in the same file
+	for _, nav := range []*tocNavBody{t.landmarksXML, t.pageListXML} {
+		if len(nav.Links) == 0 {
+			continue
+		}
+		navContent, err := xml.MarshalIndent(nav, "    ", "  ")
+		if err != nil {
+			return &UnableToWriteEpubError{Filename: navFilePath, Err: err}
+		}
+		navBodyContent = append(append(navBodyContent, "\n"...), navContent...)
 	}
 
 	n := newXhtml(string(navBodyContent))
 	n.setXmlnsEpub(xmlnsEpub)
 	n.setTitle(t.title)
 
-	navFilePath := filepath.Join(tempDir, contentFolderName, tocNavFilename)
-	n.write(navFilePath)
+	return n.write(z, navFilePath)
 }
 
-// Write the EPUB v2 TOC file (toc.ncx) to the temporary directory
-func (t *toc) writeNcxDoc(tempDir string) {
+// Write the TOC page to the specified path inside the EPUB. It must be called
+// after build.
+func (t *toc) writePage(z *zipWriter, pageFilePath string, cssPath string, moreCSS []string) error {
+	// The entries link to sections relative to the TOC file, which isn't in
+	// the same folder as the page
+	var relink func(links []tocNavItem) []tocNavItem
+	relink = func(links []tocNavItem) []tocNavItem {
+		var pageLinks []tocNavItem
+		for _, l := range links {
+			l.A.Href = path.Base(l.A.Href)
+			if l.Children != nil {
+				l.Children = &tocNavList{Links: relink(l.Children.Links)}
+			}
+			pageLinks = append(pageLinks, l)
+		}
+		return pageLinks
+	}
+
+	pageBody := &tocPageBody{
+		ID:    tocPageID,
+		H1:    t.heading,
+		Links: relink(t.navXML.Links),
+	}
+	pageBodyContent, err := xml.MarshalIndent(pageBody, "    ", "  ")
+	if err != nil {
+		return &UnableToWriteEpubError{Filename: pageFilePath, Err: err}
+	}
+
+	p := newXhtml(string(pageBodyContent))
+	p.setTitle(t.heading)
+	if cssPath != "" {
+		p.setCSS(cssPath)
+	}
+
+	return p.withCSS(moreCSS).write(z, pageFilePath)
+}
+
+// Write the EPUB v2 TOC file (toc.ncx) to the EPUB
+func (t *toc) writeNcxDoc(z *zipWriter) error {
+	ncxFilePath := path.Join(contentFolderName, tocNcxFilename)
 	t.ncxXML.Title = t.title
 
 	ncxFileContent, err := xml.MarshalIndent(t.ncxXML, "", "  ")
 	if err != nil {
-		panic(fmt.Sprintf(
-			"Error marshalling XML for EPUB v2 TOC file: %s\n"+
-				"\tXML=%#v",
-			err,
-			t.ncxXML))
+		return &UnableToWriteEpubError{Filename: ncxFilePath, Err: err}
 	}
 
 	// Add the xml header to the output
@@ -218,8 +429,5 @@ func (t *toc) writeNcxDoc(tempDir string) {
 	// It's generally nice to have files end with a newline
 	ncxFileContent = append(ncxFileContent, "\n"...)
 
-	ncxFilePath := filepath.Join(tempDir, contentFolderName, tocNcxFilename)
-	if err := os.WriteFile(ncxFilePath, ncxFileContent, filePermissions); err != nil {
-		panic(fmt.Sprintf("Error writing EPUB v2 TOC file: %s", err))
-	}
+	return writeZipFile(z, ncxFilePath, ncxFileContent)
 }
@@ -0,0 +1,106 @@
+package epub
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+)
+
+const (
+	defaultTocPageCSSContent = `nav#toc-page ol {
+  list-style-type: none;
+  padding-left: 0;
+}
+nav#toc-page ol ol {
+  padding-left: 1.5em;
+}
+nav#toc-page li {
+  margin: 0.4em 0;
+}
+nav#toc-page a {
+  text-decoration: none;
+}
+`
+	defaultTocPageCSSFilename = "toc.css"
+)
+
+// SetTocHeading sets the heading of the table of contents, which defaults to
+// "Table of Contents". It is used in the navigation document, on the TOC page
+// and for the TOC landmark.
+func (e *Epub) SetTocHeading(heading string) {
+	e.toc.setHeading(heading)
+}
+
+// TocHeading returns the heading of the table of contents.
+func (e *Epub) TocHeading() string {
+	return e.toc.heading
+}
+
+// SetTocPage adds a page with a clickable table of contents to the reading
+// order, right after the cover. The navigation document holding the table of
+// contents isn't part of the reading order, so many reading systems have no
+// contents page to flip to otherwise. The page lists the same sections as the
+// navigation document, under the heading set with SetTocHeading.
+//
+// The internal path to an already-added CSS file (as returned by AddCSS) to be
+// used for the page is optional; if no path is provided, a default stylesheet
+// is added. The list on the page is a <nav id="toc-page"> element holding
+// nested <ol> elements, which the stylesheet can target.
+func (e *Epub) SetTocPage(internalCSSPath string) error {
+	e.RemoveTocPage()
+
+	// Use default TOC page stylesheet if one isn't provided
+	if internalCSSPath == "" {
+		var err error
+		internalCSSPath, err = e.AddCSSBytes([]byte(defaultTocPageCSSContent), defaultTocPageCSSFilename, "")
+		// If that doesn't work, generate a filename
+		if _, ok := err.(*FilenameAlreadyUsedError); ok {
+			internalCSSPath, err = e.AddCSSBytes([]byte(defaultTocPageCSSContent), "", "")
+		}
+		if err != nil {
+			return err
+		}
+		e.tocPage.defaultCSSFilename = filepath.Base(internalCSSPath)
+	}
+
+	e.tocPage.enabled = true
+	e.tocPage.cssPath = internalCSSPath
+
+	return nil
+}
+
+// RemoveTocPage removes the page added with SetTocPage, along with its
+// default stylesheet.
+func (e *Epub) RemoveTocPage() {
+	if e.tocPage.defaultCSSFilename != "" {
+		delete(e.css, e.tocPage.defaultCSSFilename)
+	}
+	e.tocPage = epubTocPage{}
+}
+
+// TocPage returns whether a TOC page was added with SetTocPage.
+func (e *Epub) TocPage() bool {
+	return e.tocPage.enabled
+}
+
+// Return the filename of the TOC page, which must not be used by a section
+func (e *Epub) tocPageFilename() string {
+	filename := tocPageFilename
+	for index := 1; findSection(e.sections, filename) != nil; index++ {
+		filename = fmt.Sprintf(sectionFileFormat, index)
+	}
+	return filename
+}
+
+// Write the TOC page to the EPUB and add it to the package file. It must be
+// called after the TOC has been built.
+func (e *Epub) writeTocPage(z *zipWriter, filename string) error {
+	relativePath := path.Join(xhtmlFolderName, filename)
+	err := e.toc.writePage(z, path.Join(contentFolderName, relativePath), e.tocPage.cssPath, e.pageCSS)
+	if err != nil {
+		return err
+	}
+	e.pkg.addToManifest(filename, relativePath, mediaTypeXhtml, "", "")
+
+	return nil
+}
@@ -0,0 +1,710 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Severity is the severity of a validation finding.
+type Severity int
+
+const (
+	// SeverityWarning is a problem that reading systems may cope with
+	SeverityWarning Severity = iota
+	// SeverityError makes the EPUB invalid; reading systems may refuse it
+	SeverityError
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Finding is a problem found by Validate.
+type Finding struct {
+	Severity Severity
+	File     string // Path of the file inside the EPUB, empty for the EPUB itself
+	Line     int    // Line in the file, 0 if unknown
+	Message  string
+}
+
+func (f Finding) String() string {
+	location := f.File
+	if location == "" {
+		location = "EPUB"
+	}
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", location, f.Line)
+	}
+	return fmt.Sprintf("%s: %s: %s", f.Severity, location, f.Message)
+}
+
+// Media types that reading systems must support and that need no fallback
+// (https://www.w3.org/TR/epub-33/#sec-core-media-types)
+var coreMediaTypes = map[string]bool{
+	"application/font-sfnt":       true,
+	"application/font-woff":       true,
+	"application/javascript":      true,
+	"application/pls+xml":         true,
+	"application/smil+xml":        true,
+	"application/vnd.ms-opentype": true,
+	"application/x-dtbncx+xml":    true,
+	"application/x-font-ttf":      true,
+	"application/xhtml+xml":       true,
+	"audio/mp4":                   true,
+	"audio/mpeg":                  true,
+	"audio/ogg; codecs=opus":      true,
+	"font/otf":                    true,
+	"font/ttf":                    true,
+	"font/woff":                   true,
+	"font/woff2":                  true,
+	"image/gif":                   true,
+	"image/jpeg":                  true,
+	"image/png":                   true,
+	"image/svg+xml":               true,
+	"image/webp":                  true,
+	"text/css":                    true,
+	"text/javascript":             true,
+}
+
+// The format of dcterms:modified
+var modifiedRegexp = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}Z$`)
+
+// ValidateFile validates the EPUB file at the given path. See Validate.
+func ValidateFile(epubFilePath string) ([]Finding, error) {
+	data, err := os.ReadFile(epubFilePath)
+	if err != nil {
+		return nil, &UnableToReadEpubError{Err: err}
+	}
+
+	return Validate(bytes.NewReader(data), int64(len(data)))
+}
+
+// Validate checks an EPUB of the given size read from r and returns the
+// problems found, most severe first. It checks:
+//
+//   - the container: the mimetype file, META-INF/container.xml and the package
+//     file it points to
+//   - the required metadata: identifier, title, language and modification date
+//   - the manifest and spine: duplicate or missing items, files missing from
+//     the EPUB or from the manifest, unreferenced items, the navigation
+//     document, media types without fallbacks, and linear attributes of the
+//     spine
+//   - the XHTML content documents: well-formedness, duplicate ids, links and
+//     references to files or fragments that don't exist, and the viewport of
+//     pre-paginated pages
+//   - remote resources: images, media and stylesheets from the web that are
+//     missing from the manifest, or used by a content document without the
+//     remote-resources property
+//
+// An error is only returned if r cannot be read as a ZIP file at all.
+func Validate(r io.ReaderAt, size int64) ([]Finding, error) {
+	z, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, &UnableToReadEpubError{Err: err}
+	}
+
+	v := &validator{
+		rd: &epubReader{
+			files: make(map[string]*zip.File),
+		},
+	}
+	for _, f := range z.File {
+		if _, ok := v.rd.files[f.Name]; ok {
+			v.add(SeverityError, f.Name, 0, "file is stored more than once")
+		}
+		v.rd.files[f.Name] = f
+	}
+
+	v.validate(z)
+
+	sort.SliceStable(v.findings, func(i, j int) bool {
+		return v.findings[i].Severity > v.findings[j].Severity
+	})
+	return v.findings, nil
+}
+
+// Validate writes the EPUB to memory and validates it. See the package-level
+// Validate function.
+func (e *Epub) Validate() ([]Finding, error) {
+	var buf bytes.Buffer
+	if _, err := e.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return Validate(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+}
+
+type validator struct {
+	rd       *epubReader
+	findings []Finding
+
+	// The key is the path of a manifest item inside the EPUB
+	items map[string]*validatorItem
+	// The ids of each XHTML content document, by path
+	ids map[string]map[string]bool
+	// The manifest items outside the EPUB, by URL
+	remote map[string]*validatorItem
+	// The documents already reported for lacking the remote-resources property
+	remoteReported map[string]bool
+}
+
+type validatorItem struct {
+	id         string
+	mediaType  string
+	properties string
+	fallback   string
+	referenced bool
+	// Whether the item is a page of a fixed size in the spine
+	prePaginated bool
+}
+
+// A reference from a content document to another file
+type validatorRef struct {
+	file string
+	line int
+	ref  string
+	// Whether the file is shown or used by the document, like an image or a
+	// stylesheet, rather than linked to
+	resource bool
+}
+
+func (v *validator) add(severity Severity, file string, line int, format string, args ...interface{}) {
+	v.findings = append(v.findings, Finding{
+		Severity: severity,
+		File:     file,
+		Line:     line,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validate(z *zip.Reader) {
+	v.validateMimetype(z)
+
+	p := v.validateContainer()
+	if p == nil {
+		return
+	}
+	v.validateMetadata(p)
+	v.validateManifest(p)
+	v.validateSpine(p)
+
+	var refs []validatorRef
+	v.ids = make(map[string]map[string]bool)
+	for _, itemPath := range v.sortedItems() {
+		item := v.items[itemPath]
+		if _, ok := v.rd.files[itemPath]; !ok {
+			continue
+		}
+		switch item.mediaType {
+		case mediaTypeXhtml:
+			refs = append(refs, v.validateXhtml(itemPath)...)
+		case mediaTypeCSS:
+			refs = append(refs, v.cssRefs(itemPath)...)
+		case mediaTypeNcx:
+			refs = append(refs, v.ncxRefs(itemPath)...)
+		}
+	}
+	for _, ref := range refs {
+		v.validateRef(ref)
+	}
+
+	v.validateFallbacks()
+	v.validateUnreferenced()
+}
+
+// The mimetype file must come first, be stored uncompressed without an extra
+// field, and hold the EPUB media type
+func (v *validator) validateMimetype(z *zip.Reader) {
+	if len(z.File) == 0 || z.File[0].Name != mimetypeFilename {
+		v.add(SeverityError, mimetypeFilename, 0, "the mimetype file must be the first file in the EPUB")
+	}
+	f, ok := v.rd.files[mimetypeFilename]
+	if !ok {
+		return
+	}
+	if f.Method != zip.Store {
+		v.add(SeverityError, mimetypeFilename, 0, "the mimetype file must not be compressed")
+	}
+	if len(f.Extra) > 0 {
+		v.add(SeverityError, mimetypeFilename, 0, "the mimetype file must not have an extra field")
+	}
+	data, err := v.rd.readFile(mimetypeFilename)
+	if err != nil {
+		v.add(SeverityError, mimetypeFilename, 0, "cannot read file: %s", err)
+		return
+	}
+	if string(data) != mediaTypeEpub {
+		v.add(SeverityError, mimetypeFilename, 0, "the mimetype file must contain %q, found %q", mediaTypeEpub, data)
+	}
+}
+
+// Check the container file and return the package file it points to
+func (v *validator) validateContainer() *readPackage {
+	containerPath := path.Join(metaInfFolderName, containerFilename)
+
+	var c readContainer
+	if !v.unmarshal(containerPath, &c) {
+		return nil
+	}
+	for _, rf := range c.Rootfiles {
+		if rf.MediaType == "application/oebps-package+xml" {
+			v.rd.pkgPath = rf.FullPath
+			break
+		}
+	}
+	if v.rd.pkgPath == "" {
+		v.add(SeverityError, containerPath, 0, "no rootfile with media type application/oebps-package+xml")
+		return nil
+	}
+
+	var p readPackage
+	if !v.unmarshal(v.rd.pkgPath, &p) {
+		return nil
+	}
+	if p.Version == "" {
+		v.add(SeverityError, v.rd.pkgPath, 0, "the package element has no version")
+	}
+
+	return &p
+}
+
+// Check the metadata required by the EPUB specification
+func (v *validator) validateMetadata(p *readPackage) {
+	m := &p.Metadata
+	pkgPath := v.rd.pkgPath
+
+	found := false
+	for _, id := range m.Identifiers {
+		if strings.TrimSpace(id.Data) == "" {
+			v.add(SeverityError, pkgPath, 0, "empty dc:identifier")
+		}
+		found = found || id.ID == p.UniqueIdentifier
+	}
+	switch {
+	case len(m.Identifiers) == 0:
+		v.add(SeverityError, pkgPath, 0, "missing dc:identifier")
+	case p.UniqueIdentifier == "":
+		v.add(SeverityError, pkgPath, 0, "the package element has no unique-identifier")
+	case !found:
+		v.add(SeverityError, pkgPath, 0, "unique-identifier %q does not match a dc:identifier", p.UniqueIdentifier)
+	}
+
+	if first(dcTexts(m.Titles)) == "" {
+		v.add(SeverityError, pkgPath, 0, "missing dc:title")
+	}
+	if first(m.Languages) == "" {
+		v.add(SeverityError, pkgPath, 0, "missing dc:language")
+	}
+
+	if !strings.HasPrefix(p.Version, "3") {
+		return
+	}
+	modified := 0
+	for _, meta := range m.Meta {
+		if meta.Property != pkgModifiedProperty || meta.Refines != "" {
+			continue
+		}
+		modified++
+		if !modifiedRegexp.MatchString(strings.TrimSpace(meta.Data)) {
+			v.add(SeverityError, pkgPath, 0, "dcterms:modified %q is not in the format CCYY-MM-DDThh:mm:ssZ", meta.Data)
+		}
+	}
+	switch {
+	case modified == 0:
+		v.add(SeverityError, pkgPath, 0, "missing dcterms:modified")
+	case modified > 1:
+		v.add(SeverityError, pkgPath, 0, "dcterms:modified is set %d times", modified)
+	}
+}
+
+// Check the manifest items and the files they point to
+func (v *validator) validateManifest(p *readPackage) {
+	pkgPath := v.rd.pkgPath
+	v.items = make(map[string]*validatorItem)
+	v.remote = make(map[string]*validatorItem)
+	v.remoteReported = make(map[string]bool)
+
+	ids := make(map[string]bool)
+	navs := 0
+	for _, item := range p.Manifest {
+		if item.ID == "" {
+			v.add(SeverityError, pkgPath, 0, "manifest item %q has no id", item.Href)
+		} else if ids[item.ID] {
+			v.add(SeverityError, pkgPath, 0, "manifest item id %q is used more than once", item.ID)
+		}
+		ids[item.ID] = true
+
+		if item.MediaType == "" {
+			v.add(SeverityError, pkgPath, 0, "manifest item %q has no media type", item.ID)
+		}
+		if hasProperty(item.Properties, tocNavItemProperties) {
+			navs++
+			if item.MediaType != mediaTypeXhtml {
+				v.add(SeverityError, pkgPath, 0, "navigation document %q is not XHTML", item.Href)
+			}
+		}
+
+		if isRemoteSource(item.Href) {
+			v.remote[item.Href] = &validatorItem{id: item.ID, mediaType: item.MediaType, fallback: item.Fallback}
+			continue
+		}
+		itemPath := v.rd.resolve(pkgPath, item.Href)
+		if _, ok := v.items[itemPath]; ok {
+			v.add(SeverityError, pkgPath, 0, "file %q is listed in the manifest more than once", itemPath)
+			continue
+		}
+		v.items[itemPath] = &validatorItem{
+			id:         item.ID,
+			mediaType:  item.MediaType,
+			properties: item.Properties,
+			fallback:   item.Fallback,
+			// The navigation document and the cover image need no reference
+			referenced: hasProperty(item.Properties, tocNavItemProperties) || hasProperty(item.Properties, coverImageProperties),
+		}
+		if _, ok := v.rd.files[itemPath]; !ok {
+			v.add(SeverityError, pkgPath, 0, "manifest item %q points to %q, which does not exist", item.ID, itemPath)
+		}
+	}
+
+	if strings.HasPrefix(p.Version, "3") && navs != 1 {
+		v.add(SeverityError, pkgPath, 0, "the manifest must have exactly one navigation document, found %d", navs)
+	}
+
+	for name := range v.rd.files {
+		if name == mimetypeFilename || name == pkgPath || strings.HasPrefix(name, metaInfFolderName+"/") || strings.HasSuffix(name, "/") {
+			continue
+		}
+		if _, ok := v.items[name]; !ok {
+			v.add(SeverityWarning, name, 0, "file is not listed in the manifest")
+		}
+	}
+}
+
+// Check that the spine only references manifest items that can be rendered
+func (v *validator) validateSpine(p *readPackage) {
+	pkgPath := v.rd.pkgPath
+
+	if len(p.Spine.Items) == 0 {
+		v.add(SeverityError, pkgPath, 0, "the spine is empty")
+	}
+
+	prePaginated := false
+	for _, meta := range p.Metadata.Meta {
+		if meta.Property == pkgLayoutProperty && meta.Refines == "" {
+			prePaginated = strings.TrimSpace(meta.Data) == LayoutPrePaginated
+		}
+	}
+
+	seen := make(map[string]bool)
+	linear := false
+	for _, ref := range p.Spine.Items {
+		switch strings.TrimSpace(ref.Linear) {
+		case "", "yes":
+			linear = true
+		case pkgItemrefLinearNo:
+		default:
+			v.add(SeverityError, pkgPath, 0, "spine item %q has linear attribute %q, which is neither yes nor no", ref.Idref, ref.Linear)
+		}
+		if seen[ref.Idref] {
+			v.add(SeverityError, pkgPath, 0, "spine item %q is used more than once", ref.Idref)
+		}
+		seen[ref.Idref] = true
+
+		item := v.itemByID(ref.Idref)
+		if item == nil {
+			v.add(SeverityError, pkgPath, 0, "spine item %q is not in the manifest", ref.Idref)
+			continue
+		}
+		item.referenced = true
+		item.prePaginated = (prePaginated || hasProperty(ref.Properties, pkgLayoutPrePaginatedProperty)) &&
+			!hasProperty(ref.Properties, pkgLayoutReflowableProperty)
+		if !v.hasFallback(item, func(i *validatorItem) bool {
+			return i.mediaType == mediaTypeXhtml || i.mediaType == "image/svg+xml"
+		}) {
+			v.add(SeverityError, pkgPath, 0, "spine item %q has media type %s and no XHTML or SVG fallback", ref.Idref, item.mediaType)
+		}
+	}
+
+	if len(p.Spine.Items) > 0 && !linear {
+		v.add(SeverityWarning, pkgPath, 0, "every spine item is non-linear, so there is no main reading order")
+	}
+
+	if p.Spine.Toc != "" {
+		item := v.itemByID(p.Spine.Toc)
+		switch {
+		case item == nil:
+			v.add(SeverityError, pkgPath, 0, "spine toc %q is not in the manifest", p.Spine.Toc)
+		case item.mediaType != mediaTypeNcx:
+			v.add(SeverityError, pkgPath, 0, "spine toc %q is not an NCX document", p.Spine.Toc)
+		default:
+			item.referenced = true
+		}
+	} else if !strings.HasPrefix(p.Version, "3") {
+		v.add(SeverityError, pkgPath, 0, "the spine of an EPUB 2 has no toc")
+	}
+}
+
+// Check an XHTML content document, record its ids and return its references
+func (v *validator) validateXhtml(docPath string) []validatorRef {
+	data, err := v.rd.readFile(docPath)
+	if err != nil {
+		v.add(SeverityError, docPath, 0, "cannot read file: %s", err)
+		return nil
+	}
+
+	ids := make(map[string]bool)
+	v.ids[docPath] = ids
+	viewport := false
+
+	var refs []validatorRef
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		line, _ := d.InputPos()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if synErr, ok := err.(*xml.SyntaxError); ok {
+				v.add(SeverityError, docPath, synErr.Line, "not well-formed: %s", synErr.Msg)
+			} else {
+				v.add(SeverityError, docPath, line, "not well-formed: %s", err)
+			}
+			break
+		}
+
+		// Named HTML entities are only defined by the XHTML 1.x doctypes
+		if dir, ok := tok.(xml.Directive); ok && bytes.HasPrefix(dir, []byte("DOCTYPE")) && bytes.Contains(dir, []byte("XHTML")) {
+			d.Entity = xml.HTMLEntity
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if el.Name.Local == "meta" && attrValue(el, "name") == xhtmlViewportName {
+			viewport = true
+			if width, height := parseViewport(attrValue(el, "content")); width <= 0 || height <= 0 {
+				v.add(SeverityError, docPath, line, "viewport %q has no width and height", attrValue(el, "content"))
+			}
+		}
+		for _, attr := range el.Attr {
+			switch {
+			case attr.Name.Local == "id" && attr.Name.Space == "":
+				if ids[attr.Value] {
+					v.add(SeverityError, docPath, line, "id %q is used more than once", attr.Value)
+				}
+				ids[attr.Value] = true
+			case attr.Name.Local == "src" || attr.Name.Local == "href" || attr.Name.Local == "poster":
+				link := attr.Name.Local == "href" && (el.Name.Local == "a" || el.Name.Local == "area")
+				refs = append(refs, validatorRef{file: docPath, line: line, ref: attr.Value, resource: !link})
+			}
+		}
+	}
+
+	if item := v.items[docPath]; item != nil && item.prePaginated && !viewport {
+		v.add(SeverityError, docPath, 0, "pre-paginated page has no viewport meta element")
+	}
+
+	return refs
+}
+
+// Return the value of an attribute without namespace, or ""
+func attrValue(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name && attr.Name.Space == "" {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Return the references of a CSS file
+func (v *validator) cssRefs(cssPath string) []validatorRef {
+	data, err := v.rd.readFile(cssPath)
+	if err != nil {
+		v.add(SeverityError, cssPath, 0, "cannot read file: %s", err)
+		return nil
+	}
+
+	var refs []validatorRef
+	for _, m := range cssRefRegexp.FindAllSubmatchIndex(data, -1) {
+		ref := strings.Trim(string(data[m[2]:m[3]]), `"'`)
+		line := bytes.Count(data[:m[0]], []byte("\n")) + 1
+		refs = append(refs, validatorRef{file: cssPath, line: line, ref: ref, resource: true})
+	}
+	return refs
+}
+
+// Return the references of an NCX document
+func (v *validator) ncxRefs(ncxPath string) []validatorRef {
+	var ncx readNcx
+	if !v.unmarshal(ncxPath, &ncx) {
+		return nil
+	}
+
+	var refs []validatorRef
+	var walk func(points []readNcxNavPoint)
+	walk = func(points []readNcxNavPoint) {
+		for _, np := range points {
+			refs = append(refs, validatorRef{file: ncxPath, ref: np.Content.Src})
+			walk(np.Children)
+		}
+	}
+	walk(ncx.NavPoints)
+	return refs
+}
+
+// Check that a reference points to a manifest item and, for links into XHTML
+// documents, to an existing id
+func (v *validator) validateRef(ref validatorRef) {
+	u, err := url.Parse(ref.ref)
+	if err != nil {
+		v.add(SeverityError, ref.file, ref.line, "invalid reference %q: %s", ref.ref, err)
+		return
+	}
+	if u.Scheme != "" || u.Host != "" {
+		if ref.resource && isRemoteSource(ref.ref) {
+			v.validateRemoteRef(ref)
+		}
+		return
+	}
+
+	target := ref.file
+	if u.Path != "" {
+		target = v.rd.resolve(ref.file, ref.ref)
+		item, ok := v.items[target]
+		if !ok {
+			if _, exists := v.rd.files[target]; exists {
+				v.add(SeverityError, ref.file, ref.line, "reference %q points to a file that is not in the manifest", ref.ref)
+			} else {
+				v.add(SeverityError, ref.file, ref.line, "reference %q points to a file that does not exist", ref.ref)
+			}
+			return
+		}
+		if target != ref.file {
+			item.referenced = true
+		}
+	}
+
+	if u.Fragment == "" {
+		return
+	}
+	ids, ok := v.ids[target]
+	if ok && !ids[u.Fragment] {
+		v.add(SeverityError, ref.file, ref.line, "reference %q points to fragment %q, which does not exist", ref.ref, u.Fragment)
+	}
+}
+
+// Check that a resource outside the EPUB is listed in the manifest, and that
+// the content document using it has the remote-resources property
+func (v *validator) validateRemoteRef(ref validatorRef) {
+	if item, ok := v.remote[ref.ref]; ok {
+		item.referenced = true
+	} else {
+		v.add(SeverityError, ref.file, ref.line, "remote resource %q is not in the manifest", ref.ref)
+	}
+
+	item := v.items[ref.file]
+	if item == nil || item.mediaType != mediaTypeXhtml || hasProperty(item.properties, ManifestRemoteResources) || v.remoteReported[ref.file] {
+		return
+	}
+	v.remoteReported[ref.file] = true
+	v.add(SeverityError, v.rd.pkgPath, 0, "manifest item %q uses remote resources but has no %s property", item.id, ManifestRemoteResources)
+}
+
+// Warn about manifest items with media types that are not core media types
+// and have no fallback
+func (v *validator) validateFallbacks() {
+	for _, itemPath := range v.sortedItems() {
+		item := v.items[itemPath]
+		if !v.hasFallback(item, func(i *validatorItem) bool { return coreMediaTypes[i.mediaType] }) {
+			v.add(SeverityWarning, v.rd.pkgPath, 0, "manifest item %q has media type %s, which is not a core media type, and no fallback", item.id, item.mediaType)
+		}
+	}
+}
+
+// Warn about manifest items that nothing refers to
+func (v *validator) validateUnreferenced() {
+	for _, itemPath := range v.sortedItems() {
+		item := v.items[itemPath]
+		if !item.referenced {
+			v.add(SeverityWarning, itemPath, 0, "file is in the manifest but never referenced")
+		}
+	}
+}
+
+// Whether the item, or an item in its chain of fallbacks, satisfies ok
+func (v *validator) hasFallback(item *validatorItem, ok func(*validatorItem) bool) bool {
+	seen := make(map[string]bool)
+	for item != nil && !seen[item.id] {
+		if ok(item) {
+			return true
+		}
+		seen[item.id] = true
+		if item.fallback == "" {
+			return false
+		}
+		item = v.itemByID(item.fallback)
+		if item != nil {
+			item.referenced = true
+		}
+	}
+	return false
+}
+
+func (v *validator) itemByID(id string) *validatorItem {
+	for _, item := range v.items {
+		if item.id == id {
+			return item
+		}
+	}
+	return nil
+}
+
+func (v *validator) sortedItems() []string {
+	paths := make([]string, 0, len(v.items))
+	for itemPath := range v.items {
+		paths = append(paths, itemPath)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Unmarshal an XML file, reporting an error finding if it fails
+func (v *validator) unmarshal(name string, dst interface{}) bool {
+	data, err := v.rd.readFile(name)
+	if err != nil {
+		v.add(SeverityError, name, 0, "cannot read file: %s", err)
+		return false
+	}
+	if err := xml.Unmarshal(data, dst); err != nil {
+		line := 0
+		if synErr, ok := err.(*xml.SyntaxError); ok {
+			line = synErr.Line
+		}
+		v.add(SeverityError, name, line, "cannot parse file: %s", err)
+		return false
+	}
+	return true
+}
+
+// The text of Dublin Core elements
+func dcTexts(elements []readDcElement) []string {
+	texts := make([]string, len(elements))
+	for i, el := range elements {
+		texts[i] = el.Data
+	}
+	return texts
+}
@@ -0,0 +1,263 @@
+package epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// A file of a ZIP archive, in archive order
+type testZipEntry struct {
+	name   string
+	method uint16
+	data   []byte
+}
+
+func readZipEntries(t *testing.T, data []byte) []testZipEntry {
+	t.Helper()
+	files := zipFiles(t, data)
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("zip: %s", err)
+	}
+	entries := make([]testZipEntry, len(z.File))
+	for i, f := range z.File {
+		entries[i] = testZipEntry{name: f.Name, method: f.Method, data: files[f.Name]}
+	}
+	return entries
+}
+
+func writeZipEntries(t *testing.T, entries []testZipEntry) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		fw, err := w.CreateHeader(&zip.FileHeader{Name: entry.name, Method: entry.method})
+		if err != nil {
+			t.Fatalf("create %s: %s", entry.name, err)
+		}
+		fw.Write(entry.data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("zip: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// Return a function replacing old with new in a file, failing if it's not there
+func replaceIn(name, old, new string) func(*testing.T, []testZipEntry) []testZipEntry {
+	return func(t *testing.T, entries []testZipEntry) []testZipEntry {
+		for i, entry := range entries {
+			if entry.name == name {
+				if !bytes.Contains(entry.data, []byte(old)) {
+					t.Fatalf("%s has no %q", name, old)
+				}
+				entries[i].data = bytes.Replace(entry.data, []byte(old), []byte(new), 1)
+				return entries
+			}
+		}
+		t.Fatalf("no file %s", name)
+		return nil
+	}
+}
+
+// Return a function applying the edits in order
+func edits(fns ...func(*testing.T, []testZipEntry) []testZipEntry) func(*testing.T, []testZipEntry) []testZipEntry {
+	return func(t *testing.T, entries []testZipEntry) []testZipEntry {
+		for _, fn := range fns {
+			entries = fn(t, entries)
+		}
+		return entries
+	}
+}
+
+// Edits making the first section show a remote image
+var (
+	remoteImage         = replaceIn("EPUB/xhtml/one.xhtml", `src="../images/dot.png"`, `src="https://example.com/dot.png"`)
+	remoteImageManifest = replaceIn("EPUB/package.opf", `<manifest>`, `<manifest><item id="remote-dot" href="https://example.com/dot.png" media-type="image/png"></item>`)
+	remoteProperty      = replaceIn("EPUB/package.opf", `href="xhtml/one.xhtml" media-type="application/xhtml+xml"`, `href="xhtml/one.xhtml" media-type="application/xhtml+xml" properties="remote-resources"`)
+)
+
+func validateTestEpub(t *testing.T, data []byte) []Finding {
+	t.Helper()
+	findings, err := Validate(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Validate: %s", err)
+	}
+	return findings
+}
+
+func TestValidateValid(t *testing.T) {
+	for _, f := range validateTestEpub(t, writeTestEpub(t, newTestBook(t))) {
+		t.Errorf("unexpected finding: %s", f)
+	}
+}
+
+func TestValidateRemote(t *testing.T) {
+	data := writeZipEntries(t, edits(remoteImage, remoteImageManifest, remoteProperty)(t, readZipEntries(t, writeTestEpub(t, newTestBook(t)))))
+	for _, f := range validateTestEpub(t, data) {
+		t.Errorf("unexpected finding: %s", f)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	const pkgPath = "EPUB/package.opf"
+
+	for _, tt := range []struct {
+		name     string
+		edit     func(*testing.T, []testZipEntry) []testZipEntry
+		severity Severity
+		file     string
+		message  string
+	}{
+		{
+			name: "mimetype not first",
+			edit: func(t *testing.T, entries []testZipEntry) []testZipEntry {
+				return append(entries[1:], entries[0])
+			},
+			severity: SeverityError,
+			file:     "mimetype",
+			message:  "the mimetype file must be the first file in the EPUB",
+		},
+		{
+			name: "mimetype compressed",
+			edit: func(t *testing.T, entries []testZipEntry) []testZipEntry {
+				entries[0].method = zip.Deflate
+				return entries
+			},
+			severity: SeverityError,
+			file:     "mimetype",
+			message:  "the mimetype file must not be compressed",
+		},
+		{
+			name: "mimetype content",
+			edit: func(t *testing.T, entries []testZipEntry) []testZipEntry {
+				entries[0].data = []byte("application/zip")
+				return entries
+			},
+			severity: SeverityError,
+			file:     "mimetype",
+			message:  `the mimetype file must contain "application/epub+zip"`,
+		},
+		{
+			name: "missing container",
+			edit: func(t *testing.T, entries []testZipEntry) []testZipEntry {
+				var kept []testZipEntry
+				for _, entry := range entries {
+					if entry.name != "META-INF/container.xml" {
+						kept = append(kept, entry)
+					}
+				}
+				return kept
+			},
+			severity: SeverityError,
+			file:     "META-INF/container.xml",
+			message:  "cannot read file",
+		},
+		{
+			name:     "bad spine idref",
+			edit:     replaceIn(pkgPath, `<itemref idref="two.xhtml"`, `<itemref idref="three.xhtml"`),
+			severity: SeverityError,
+			file:     pkgPath,
+			message:  `spine item "three.xhtml" is not in the manifest`,
+		},
+		{
+			name:     "spine item without fallback",
+			edit:     replaceIn(pkgPath, `<itemref idref="two.xhtml"`, `<itemref idref="dot.png"`),
+			severity: SeverityError,
+			file:     pkgPath,
+			message:  `spine item "dot.png" has media type image/png and no XHTML or SVG fallback`,
+		},
+		{
+			name:     "missing fallback",
+			edit:     replaceIn(pkgPath, ` fallback="notes.xhtml"`, ``),
+			severity: SeverityWarning,
+			file:     pkgPath,
+			message:  `manifest item "clip.webm" has media type video/webm, which is not a core media type, and no fallback`,
+		},
+		{
+			name:     "non-core media type",
+			edit:     replaceIn(pkgPath, `media-type="image/png"`, `media-type="image/x-ms-bmp"`),
+			severity: SeverityWarning,
+			file:     pkgPath,
+			message:  `manifest item "dot.png" has media type image/x-ms-bmp, which is not a core media type, and no fallback`,
+		},
+		{
+			name:     "broken fragment",
+			edit:     replaceIn("EPUB/xhtml/one.xhtml", `href="notes.xhtml#n1"`, `href="notes.xhtml#n2"`),
+			severity: SeverityError,
+			file:     "EPUB/xhtml/one.xhtml",
+			message:  `reference "notes.xhtml#n2" points to fragment "n2", which does not exist`,
+		},
+		{
+			name:     "broken link",
+			edit:     replaceIn("EPUB/xhtml/one.xhtml", `href="notes.xhtml#n1"`, `href="note.xhtml#n1"`),
+			severity: SeverityError,
+			file:     "EPUB/xhtml/one.xhtml",
+			message:  `reference "note.xhtml#n1" points to a file that does not exist`,
+		},
+		{
+			name:     "missing modified",
+			edit:     replaceIn(pkgPath, `property="dcterms:modified"`, `property="dcterms:created"`),
+			severity: SeverityError,
+			file:     pkgPath,
+			message:  "missing dcterms:modified",
+		},
+		{
+			name:     "remote resource not in the manifest",
+			edit:     edits(remoteImage, remoteProperty),
+			severity: SeverityError,
+			file:     "EPUB/xhtml/one.xhtml",
+			message:  `remote resource "https://example.com/dot.png" is not in the manifest`,
+		},
+		{
+			name:     "remote resource without property",
+			edit:     edits(remoteImage, remoteImageManifest),
+			severity: SeverityError,
+			file:     pkgPath,
+			message:  `manifest item "one.xhtml" uses remote resources but has no remote-resources property`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := writeZipEntries(t, tt.edit(t, readZipEntries(t, writeTestEpub(t, newTestBook(t)))))
+			findings := validateTestEpub(t, data)
+			for _, f := range findings {
+				if f.Severity == tt.severity && f.File == tt.file && strings.HasPrefix(f.Message, tt.message) {
+					return
+				}
+			}
+			t.Errorf("no %s in %s: %s; got:", tt.severity, tt.file, tt.message)
+			for _, f := range findings {
+				t.Log(f)
+			}
+		})
+	}
+}
+
+func TestAddRemoteMedia(t *testing.T) {
+	e := newTestBook(t)
+	if _, err := e.AddSection(`<p><img src="https://example.com/photo.jpg" alt="photo" /></p>`, "Photo", "photo.xhtml", "", ManifestProperties(ManifestRemoteResources)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.AddRemoteMedia("https://example.com/photo.jpg", ""); err != nil {
+		t.Fatal(err)
+	}
+	data := writeTestEpub(t, e)
+	if opf := string(zipFiles(t, data)["EPUB/package.opf"]); !strings.Contains(opf, `href="https://example.com/photo.jpg" media-type="image/jpeg"`) {
+		t.Errorf("remote image not in the manifest:\n%s", opf)
+	}
+	for _, f := range validateTestEpub(t, data) {
+		t.Errorf("unexpected finding: %s", f)
+	}
+
+	var retrieval *FileRetrievalError
+	if _, err := e.AddRemoteMedia("images/photo.jpg", "image/jpeg"); !errors.As(err, &retrieval) {
+		t.Errorf("local source: got %v, want FileRetrievalError", err)
+	}
+	var unknown *UnknownMediaTypeError
+	if _, err := e.AddRemoteMedia("https://example.com/photo", ""); !errors.As(err, &unknown) {
+		t.Errorf("no extension: got %v, want UnknownMediaTypeError", err)
+	}
+}
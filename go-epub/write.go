@@ -4,11 +4,13 @@ import (
 	"archive/zip"
 	"fmt"
 	"io"
-	"net/http"
-	"net/url"
+	"mime"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 )
 
 // UnableToCreateEpubError is thrown by Write if it cannot create the destination EPUB file
@@ -21,6 +23,38 @@ func (e *UnableToCreateEpubError) Error() string {
 	return fmt.Sprintf("Error creating EPUB at %q: %+v", e.Path, e.Err)
 }
 
+func (e *UnableToCreateEpubError) Unwrap() error {
+	return e.Err
+}
+
+// UnableToWriteEpubError is thrown by Write or WriteTo if a file inside the
+// EPUB cannot be generated or written to the destination
+type UnableToWriteEpubError struct {
+	Filename string // The file inside the EPUB that was being written, if any
+	Err      error  // The underlying error that was thrown
+}
+
+func (e *UnableToWriteEpubError) Error() string {
+	if e.Filename == "" {
+		return fmt.Sprintf("Error writing EPUB: %+v", e.Err)
+	}
+	return fmt.Sprintf("Error writing %q to EPUB: %+v", e.Filename, e.Err)
+}
+
+func (e *UnableToWriteEpubError) Unwrap() error {
+	return e.Err
+}
+
+// UnknownMediaTypeError is thrown by Write or WriteTo if the media type of a
+// CSS, font or image file cannot be determined from its filename extension
+type UnknownMediaTypeError struct {
+	Filename string // Filename whose media type is unknown
+}
+
+func (e *UnknownMediaTypeError) Error() string {
+	return fmt.Sprintf("Unknown media type for file: %s", e.Filename)
+}
+
 var extensionMediaTypes = map[string]string{
 	".css":   mediaTypeCSS,
 	".gif":   "image/gif",
@@ -52,6 +86,40 @@ var extensionMediaTypes = map[string]string{
 	".avif":  "image/avif",
 }
 
+// Extensions of the files generated for media types, which must not depend on
+// the mime tables of the system so that the output is reproducible
+var mediaTypeExtensions = map[string]string{
+	mediaTypeCSS:                    ".css",
+	"image/gif":                     ".gif",
+	mediaTypeJpeg:                   ".jpg",
+	"image/png":                     ".png",
+	"image/svg+xml":                 ".svg",
+	"image/webp":                    ".webp",
+	"image/avif":                    ".avif",
+	"image/bmp":                     ".bmp",
+	"image/tiff":                    ".tiff",
+	"application/vnd.ms-opentype":   ".otf",
+	"application/font-sfnt":         ".ttf",
+	"application/font-woff":         ".woff",
+	"font/otf":                      ".otf",
+	"font/ttf":                      ".ttf",
+	"font/woff":                     ".woff",
+	"font/woff2":                    ".woff2",
+	"audio/mpeg":                    ".mp3",
+	"audio/mp4":                     ".m4a",
+	"audio/ogg":                     ".ogg",
+	"video/mp4":                     ".mp4",
+	"video/webm":                    ".webm",
+	"text/vtt":                      ".vtt",
+	"application/javascript":        ".js",
+	"text/javascript":               ".js",
+	"application/smil+xml":          ".smil",
+	"application/pls+xml":           ".pls",
+	mediaTypeXhtml:                  ".xhtml",
+	"application/x-dtbncx+xml":      ".ncx",
+	"application/oebps-package+xml": ".opf",
+}
+
 const (
 	containerFilename     = "container.xml"
 	containerFileTemplate = `<?xml version="1.0" encoding="UTF-8"?>
@@ -65,119 +133,171 @@ const (
 	// http://www.idpf.org/epub/31/spec/epub-ocf.html
 	contentFolderName    = "EPUB"
 	coverImageProperties = "cover-image"
-	// Permissions for any new directories we create
-	dirPermissions = 0755
-	// Permissions for any new files we create
-	filePermissions   = 0644
-	mediaTypeCSS      = "text/css"
-	mediaTypeEpub     = "application/epub+zip"
-	mediaTypeJpeg     = "image/jpeg"
-	mediaTypeNcx      = "application/x-dtbncx+xml"
-	mediaTypeXhtml    = "application/xhtml+xml"
-	metaInfFolderName = "META-INF"
-	mimetypeFilename  = "mimetype"
-	pkgFilename       = "package.opf"
-	tempDirPrefix     = "go-epub"
-	xhtmlFolderName   = "xhtml"
+	mediaTypeCSS         = "text/css"
+	mediaTypeEpub        = "application/epub+zip"
+	mediaTypeJpeg        = "image/jpeg"
+	mediaTypeNcx         = "application/x-dtbncx+xml"
+	mediaTypeXhtml       = "application/xhtml+xml"
+	metaInfFolderName    = "META-INF"
+	mimetypeFilename     = "mimetype"
+	pkgFilename          = "package.opf"
+	xhtmlFolderName      = "xhtml"
 )
 
 // Write writes the EPUB file. The destination path must be the full path to
 // the resulting file, including filename and extension.
 func (e *Epub) Write(destFilePath string) error {
-	tempDir, err := os.MkdirTemp("", tempDirPrefix)
-	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			panic(fmt.Sprintf("Error removing temp directory: %s", err))
+	f, err := os.Create(destFilePath)
+	if err != nil {
+		return &UnableToCreateEpubError{
+			Path: destFilePath,
+			Err:  err,
 		}
-	}()
+	}
+
+	_, err = e.WriteTo(f)
+	if cerr := f.Close(); err == nil && cerr != nil {
+		err = &UnableToCreateEpubError{
+			Path: destFilePath,
+			Err:  cerr,
+		}
+	}
 	if err != nil {
-		panic(fmt.Sprintf("Error creating temp directory: %s", err))
+		// Don't leave a truncated EPUB behind
+		os.Remove(destFilePath)
+		return err
 	}
 
-	writeMimetype(tempDir)
-	createEpubFolders(tempDir)
+	return nil
+}
 
-	// Must be called after:
-	// createEpubFolders()
-	writeContainerFile(tempDir)
+// WriteTo writes the EPUB file to w and returns the number of bytes written.
+// The ZIP container is built in a single streaming pass, so w may be a
+// network connection or any other non-seekable writer.
+func (e *Epub) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	z := &zipWriter{
+		Writer:   zip.NewWriter(cw),
+		modified: e.writeTime(),
+	}
 
-	// Must be called after:
-	// createEpubFolders()
-	err = e.writeCSSFiles(tempDir)
+	if e.reproducible && e.autoIdentifier {
+		e.setIdentifier(e.contentIdentifier())
+	}
+
+	// The manifest, spine and TOC are rebuilt on every write so the EPUB can
+	// be written again after it has been changed
+	e.pkg.clearItems()
+	e.toc.clearSections()
+
+	// Must be called first
+	err := writeMimetype(z)
 	if err != nil {
-		return err
+		return cw.n, err
 	}
 
-	// Must be called after:
-	// createEpubFolders()
-	err = e.writeFonts(tempDir)
+	err = writeContainerFile(z)
 	if err != nil {
-		return err
+		return cw.n, err
 	}
 
-	// Must be called after:
-	// createEpubFolders()
-	err = e.writeImages(tempDir)
+	err = e.writeCSSFiles(z)
 	if err != nil {
-		return err
+		return cw.n, err
 	}
 
-	// Must be called after:
-	// createEpubFolders()
-	e.writeSections(tempDir)
+	err = e.writeFonts(z)
+	if err != nil {
+		return cw.n, err
+	}
+
+	err = e.writeImages(z)
+	if err != nil {
+		return cw.n, err
+	}
+
+	err = e.writeOtherMedia(z)
+	if err != nil {
+		return cw.n, err
+	}
+
+	err = e.writeSections(z)
+	if err != nil {
+		return cw.n, err
+	}
 
 	// Must be called after:
-	// createEpubFolders()
 	// writeSections()
-	e.writeToc(tempDir)
+	err = e.writeToc(z)
+	if err != nil {
+		return cw.n, err
+	}
 
 	// Must be called after:
-	// createEpubFolders()
 	// writeCSSFiles()
+	// writeFonts()
 	// writeImages()
+	// writeOtherMedia()
 	// writeSections()
 	// writeToc()
-	e.writePackageFile(tempDir)
-
-	// Must be called last
-	err = e.writeEpub(tempDir, destFilePath)
+	err = e.writePackageFile(z)
 	if err != nil {
-		return err
+		return cw.n, err
 	}
 
-	return nil
+	if err := z.Close(); err != nil {
+		return cw.n, &UnableToWriteEpubError{Filename: "", Err: err}
+	}
+
+	return cw.n, nil
 }
 
-// Create the EPUB folder structure in a temp directory
-func createEpubFolders(tempDir string) {
-	if err := os.Mkdir(
-		filepath.Join(
-			tempDir,
-			contentFolderName,
-		),
-		dirPermissions); err != nil {
-		// No reason this should happen if tempDir creation was successful
-		panic(fmt.Sprintf("Error creating EPUB subdirectory: %s", err))
-	}
+// countWriter counts the bytes written through it for WriteTo
+type countWriter struct {
+	w io.Writer
+	n int64
+}
 
-	if err := os.Mkdir(
-		filepath.Join(
-			tempDir,
-			contentFolderName,
-			xhtmlFolderName,
-		),
-		dirPermissions); err != nil {
-		panic(fmt.Sprintf("Error creating xhtml subdirectory: %s", err))
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// zipWriter writes the files of the EPUB container, all with the same
+// modification time
+type zipWriter struct {
+	*zip.Writer
+	modified time.Time
+}
+
+// Create a file in the container with the given compression method
+func (z *zipWriter) create(name string, method uint16) (io.Writer, error) {
+	// Only set the MS-DOS time: setting Modified adds an extra field, which is
+	// not allowed for the mimetype file
+	t := z.modified.UTC()
+	if t.Before(defaultReproducibleTime) {
+		t = defaultReproducibleTime
 	}
+	return z.CreateHeader(&zip.FileHeader{
+		Name:         name,
+		Method:       method,
+		ModifiedDate: uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9),
+		ModifiedTime: uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11),
+	})
+}
 
-	if err := os.Mkdir(
-		filepath.Join(
-			tempDir,
-			metaInfFolderName,
-		),
-		dirPermissions); err != nil {
-		panic(fmt.Sprintf("Error creating META-INF subdirectory: %s", err))
+// Add a file with the given content to the EPUB container
+func writeZipFile(z *zipWriter, name string, content []byte) error {
+	w, err := z.create(name, zip.Deflate)
+	if err != nil {
+		return &UnableToWriteEpubError{Filename: name, Err: err}
+	}
+	if _, err := w.Write(content); err != nil {
+		return &UnableToWriteEpubError{Filename: name, Err: err}
 	}
+
+	return nil
 }
 
 // Write the contatiner file (container.xml), which mostly just points to the
@@ -185,10 +305,10 @@ func createEpubFolders(tempDir string) {
 //
 // Sample: https://github.com/bmaupin/epub-samples/blob/master/minimal-v3plus2/META-INF/container.xml
 // Spec: http://www.idpf.org/epub/301/spec/epub-ocf.html#sec-container-metainf-container.xml
-func writeContainerFile(tempDir string) {
-	containerFilePath := filepath.Join(tempDir, metaInfFolderName, containerFilename)
-	if err := os.WriteFile(
-		containerFilePath,
+func writeContainerFile(z *zipWriter) error {
+	return writeZipFile(
+		z,
+		path.Join(metaInfFolderName, containerFilename),
 		[]byte(
 			fmt.Sprintf(
 				containerFileTemplate,
@@ -196,273 +316,262 @@ func writeContainerFile(tempDir string) {
 				pkgFilename,
 			),
 		),
-		filePermissions,
-	); err != nil {
-		panic(fmt.Sprintf("Error writing container file: %s", err))
-	}
+	)
+}
+
+// Write the CSS files to the EPUB and add them to the package file
+func (e *Epub) writeCSSFiles(z *zipWriter) error {
+	return e.writeMedia(z, e.css, CSSFolderName, nil)
 }
 
-// Write the CSS files to the temporary directory and add them to the package
-// file
-func (e *Epub) writeCSSFiles(tempDir string) error {
-	err := e.writeMedia(tempDir, e.css, CSSFolderName)
+// Get fonts from their source and save them in the EPUB, obfuscated if
+// SetFontObfuscation was called
+func (e *Epub) writeFonts(z *zipWriter) error {
+	if !e.fontObfuscation || len(e.fonts) == 0 {
+		return e.writeMedia(z, e.fonts, FontFolderName, nil)
+	}
+
+	err := e.writeEncryptionFile(z)
 	if err != nil {
 		return err
 	}
 
-	// Clean up the cover temp file if one was created
-	os.Remove(e.cover.cssTempFile)
+	return e.writeMedia(z, e.fonts, FontFolderName, idpfObfuscationKey(e.identifier))
+}
 
-	return nil
+// Get images from their source and save them in the EPUB
+func (e *Epub) writeImages(z *zipWriter) error {
+	return e.writeMedia(z, e.images, ImageFolderName, nil)
 }
 
-// Write the EPUB file itself by zipping up everything from a temp directory
-func (e *Epub) writeEpub(tempDir string, destFilePath string) error {
-	f, err := os.Create(destFilePath)
-	if err != nil {
-		return &UnableToCreateEpubError{
-			Path: destFilePath,
-			Err:  err,
-		}
+// Get other media files from their source and save them in the EPUB
+func (e *Epub) writeOtherMedia(z *zipWriter) error {
+	return e.writeMedia(z, e.media, MediaFolderName, nil)
+}
+
+// Get media files from their source and save them in the EPUB. If an
+// obfuscation key is given, the files are obfuscated with the IDPF algorithm.
+func (e *Epub) writeMedia(z *zipWriter, mediaMap map[string]*epubMedia, mediaFolderName string, obfuscationKey []byte) error {
+	// Write the files in a fixed order so the output is reproducible
+	mediaFilenames := make([]string, 0, len(mediaMap))
+	for mediaFilename := range mediaMap {
+		mediaFilenames = append(mediaFilenames, mediaFilename)
 	}
-	defer func() {
-		if err := f.Close(); err != nil {
-			panic(err)
+	sort.Strings(mediaFilenames)
+
+	for _, mediaFilename := range mediaFilenames {
+		media := mediaMap[mediaFilename]
+		mediaSource := media.source
+		mediaType := media.mediaType
+		if mediaType == "" {
+			mediaType = mediaTypeOf(mediaFilename)
 		}
-	}()
-
-	z := zip.NewWriter(f)
-	defer func() {
-		if err := z.Close(); err != nil {
-			panic(err)
+		if mediaType == "" {
+			return &UnknownMediaTypeError{Filename: mediaFilename}
 		}
-	}()
 
-	skipMimetypeFile := false
-
-	var addFileToZip = func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+		// Remote resources are only listed in the manifest
+		if media.remote {
+			e.pkg.addToManifest(mediaFilename, media.source, mediaType, media.fallback, "")
+			continue
 		}
 
-		// Get the path of the file relative to the folder we're zipping
-		relativePath, err := filepath.Rel(tempDir, path)
-		relativePath = filepath.ToSlash(relativePath)
+		// Get the media file from memory or from the source
+		r, err := e.openMedia(media)
 		if err != nil {
-			// tempDir and path are both internal, so we shouldn't get here
-			panic(fmt.Sprintf("Error closing EPUB file: %s", err))
+			return &FileRetrievalError{Source: mediaSource, Err: err}
 		}
 
-		// Only include regular files, not directories
-		if !info.Mode().IsRegular() {
-			return nil
-		}
-
-		var w io.Writer
-		if path == filepath.Join(tempDir, mimetypeFilename) {
-			// Skip the mimetype file if it's already been written
-			if skipMimetypeFile == true {
-				return nil
-			}
-			// The mimetype file must be uncompressed according to the EPUB spec
-			w, err = z.CreateHeader(&zip.FileHeader{
-				Name:   relativePath,
-				Method: zip.Store,
-			})
-		} else {
-			w, err = z.Create(relativePath)
-		}
+		// Add the file to the EPUB
+		mediaFilePath := path.Join(contentFolderName, mediaFolderName, mediaFilename)
+		w, err := z.create(mediaFilePath, zip.Deflate)
 		if err != nil {
-			panic(fmt.Sprintf("Error creating zip writer: %s", err))
+			r.Close()
+			return &UnableToWriteEpubError{Filename: mediaFilePath, Err: err}
 		}
-
-		r, err := os.Open(path)
-		if err != nil {
-			panic(fmt.Sprintf("Error opening file being added to EPUB: %s", err))
+		if obfuscationKey != nil {
+			w = &obfuscatingWriter{w: w, key: obfuscationKey, length: obfuscationLengthIDPF}
 		}
-		defer func() {
-			if err := r.Close(); err != nil {
-				panic(err)
-			}
-		}()
 
 		_, err = io.Copy(w, r)
+		// Close the reader manually. If we use a defer instead, it won't close
+		// until the function exits.
+		r.Close()
 		if err != nil {
-			panic(fmt.Sprintf("Error copying contents of file being added EPUB: %s", err))
+			// Either the source or the destination may have failed
+			return &FileRetrievalError{Source: mediaSource, Err: err}
 		}
 
-		return nil
-	}
+		// The cover image has a special value for the properties attribute
+		mediaProperties := ""
+		if mediaFilename == e.cover.imageFilename {
+			mediaProperties = coverImageProperties
+		}
 
-	// Add the mimetype file first
-	mimetypeFilePath := filepath.Join(tempDir, mimetypeFilename)
-	mimetypeInfo, err := os.Lstat(mimetypeFilePath)
-	if err != nil {
-		panic(fmt.Sprintf("Unable to get FileInfo for mimetype file: %s", err))
+		// Add the file to the OPF manifest
+		e.pkg.addToManifest(mediaFilename, path.Join(mediaFolderName, mediaFilename), mediaType, media.fallback, mediaProperties)
 	}
-	err = addFileToZip(mimetypeFilePath, mimetypeInfo, nil)
-	if err != nil {
-		panic(fmt.Sprintf("Unable to add mimetype file to EPUB: %s", err))
+
+	return nil
+}
+
+// Get the media type of a file from its extension, falling back to the types
+// known to the system
+func mediaTypeOf(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if mediaType, ok := extensionMediaTypes[ext]; ok {
+		return mediaType
 	}
+	mediaType, _, _ := mime.ParseMediaType(mime.TypeByExtension(ext))
+	return mediaType
+}
 
-	skipMimetypeFile = true
+// Get the extension of the files generated for a media type, or none if the
+// media type isn't known
+func extensionOf(mediaType string) string {
+	mediaType, _, _ = mime.ParseMediaType(mediaType)
+	return mediaTypeExtensions[strings.ToLower(mediaType)]
+}
 
-	err = filepath.Walk(tempDir, addFileToZip)
+// Write the mimetype file
+//
+// Sample: https://github.com/bmaupin/epub-samples/blob/master/minimal-v3plus2/mimetype
+// Spec: http://www.idpf.org/epub/301/spec/epub-ocf.html#sec-zip-container-mime
+func writeMimetype(z *zipWriter) error {
+	// The mimetype file must be uncompressed according to the EPUB spec
+	w, err := z.create(mimetypeFilename, zip.Store)
 	if err != nil {
-		panic(fmt.Sprintf("Unable to add file to EPUB: %s", err))
+		return &UnableToWriteEpubError{Filename: mimetypeFilename, Err: err}
+	}
+	if _, err := w.Write([]byte(mediaTypeEpub)); err != nil {
+		return &UnableToWriteEpubError{Filename: mimetypeFilename, Err: err}
 	}
 
 	return nil
 }
 
-// Get fonts from their source and save them in the temporary directory
-func (e *Epub) writeFonts(tempDir string) error {
-	return e.writeMedia(tempDir, e.fonts, FontFolderName)
-}
-
-// Get images from their source and save them in the temporary directory
-func (e *Epub) writeImages(tempDir string) error {
-	return e.writeMedia(tempDir, e.images, ImageFolderName)
+func (e *Epub) writePackageFile(z *zipWriter) error {
+	return e.pkg.write(z)
 }
 
-// Get images from their source and save them in the temporary directory
-func (e *Epub) writeMedia(tempDir string, mediaMap map[string]string, mediaFolderName string) error {
-	if len(mediaMap) > 0 {
-		mediaFolderPath := filepath.Join(tempDir, contentFolderName, mediaFolderName)
-		if err := os.Mkdir(mediaFolderPath, dirPermissions); err != nil {
-			panic(fmt.Sprintf("Unable to create directory: %s", err))
+// Write the section files to the EPUB and add the sections to the TOC and
+// package files
+func (e *Epub) writeSections(z *zipWriter) error {
+	if len(e.sections) > 0 {
+		// If a cover was set, add it to the package spine first so it shows up
+		// first in the reading order
+		if cover := findSection(e.sections, e.cover.xhtmlFilename); cover != nil {
+			e.pkg.addToSpine(cover.filename, cover.spineLinear(), cover.spineProperties())
 		}
-
-		for mediaFilename, mediaSource := range mediaMap {
-			// Get the media file from the source
-			u, err := url.Parse(mediaSource)
-			if err != nil {
-				return &FileRetrievalError{Source: mediaSource, Err: err}
+		// The TOC page follows the cover; it is written along with the TOC
+		if e.tocPage.enabled {
+			properties := ""
+			if e.layout == LayoutPrePaginated {
+				properties = pkgLayoutReflowableProperty
 			}
+			e.pkg.addToSpine(e.tocPageFilename(), "", properties)
+		}
 
-			var r io.ReadCloser
-			var resp *http.Response
-			// If it's a URL
-			if u.Scheme == "http" || u.Scheme == "https" {
-				resp, err = http.Get(mediaSource)
-				if err != nil {
-					return &FileRetrievalError{Source: mediaSource, Err: err}
-				}
-				r = resp.Body
-
-				// Otherwise, assume it's a local file
-			} else {
-				r, err = os.Open(mediaSource)
-			}
-			if err != nil {
-				return &FileRetrievalError{Source: mediaSource, Err: err}
-			}
+		err := e.writeSectionTree(z, e.sections, nil)
+		if err != nil {
+			return err
+		}
+	}
 
-			mediaFilePath := filepath.Join(
-				mediaFolderPath,
-				mediaFilename,
-			)
+	return nil
+}
 
-			// Add the file to the EPUB temp directory
-			w, err := os.Create(mediaFilePath)
-			if err != nil {
-				panic(fmt.Sprintf("Unable to create file: %s", err))
-			}
+// Write the sections and, recursively, their subsections. TOC entries of
+// subsections are nested below the entry of their closest listed ancestor.
+func (e *Epub) writeSectionTree(z *zipWriter, sections []*epubSection, parent *tocEntry) error {
+	for _, section := range sections {
+		// Set the title of the cover page XHTML to the title of the EPUB
+		if section.filename == e.cover.xhtmlFilename {
+			section.xhtml.setTitle(e.Title())
+			e.setCoverViewport(section)
+		}
 
-			_, err = io.Copy(w, r)
-			// Close the reader and writer manually. If we use a defer instead,
-			// they won't close until the function exits.
-			func() {
-				if err := r.Close(); err != nil {
-					panic(err)
-				}
-			}()
-			func() {
-				if err := w.Close(); err != nil {
-					panic(err)
-				}
-			}()
-			if err != nil {
-				// There shouldn't be any problem with the writer, but the reader
-				// might have an issue
-				return &FileRetrievalError{Source: mediaSource, Err: err}
-			}
+		// The cover page keeps to its own stylesheets
+		x := section.xhtml
+		if section.filename == e.cover.xhtmlFilename {
+			x = x.withCSS(e.pageCSS)
+		} else {
+			x = x.withDefaultCSS(e.defaultCSS)
+		}
 
-			mediaType := extensionMediaTypes[strings.ToLower(filepath.Ext(mediaFilename))]
-			if mediaType == "" {
-				panic(fmt.Sprintf(
-					"Unmatched file extension, media type not set for file: %s",
-					mediaFilename))
-			}
+		relativePath := path.Join(xhtmlFolderName, section.filename)
+		err := x.write(z, path.Join(contentFolderName, relativePath))
+		if err != nil {
+			return err
+		}
 
-			// The cover image has a special value for the properties attribute
-			mediaProperties := ""
-			if mediaFilename == e.cover.imageFilename {
-				mediaProperties = coverImageProperties
-			}
+		// Add the page breaks of the section to the page list
+		for _, pageBreak := range section.xhtml.pageBreaks() {
+			e.toc.addPage(pageBreak.label, relativePath+"#"+pageBreak.id)
+		}
 
-			// Add the file to the OPF manifest
-			e.pkg.addToManifest(mediaFilename, filepath.Join(mediaFolderName, mediaFilename), mediaType, mediaProperties)
+		// Don't add pages without titles or the cover to the TOC
+		entry := parent
+		if section.xhtml.Title() != "" && section.filename != e.cover.xhtmlFilename {
+			entry = e.toc.addSection(parent, section.xhtml.Title(), relativePath)
+		}
+		// The cover page should have already been added to the spine first
+		if section.filename != e.cover.xhtmlFilename {
+			e.pkg.addToSpine(section.filename, section.spineLinear(), section.spineProperties())
+		}
+		e.pkg.addToManifest(section.filename, relativePath, mediaTypeXhtml, "", strings.Join(section.manifestProperties, " "))
+
+		err = e.writeSectionTree(z, section.children, entry)
+		if err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// Write the mimetype file
-//
-// Sample: https://github.com/bmaupin/epub-samples/blob/master/minimal-v3plus2/mimetype
-// Spec: http://www.idpf.org/epub/301/spec/epub-ocf.html#sec-zip-container-mime
-func writeMimetype(tempDir string) {
-	mimetypeFilePath := filepath.Join(tempDir, mimetypeFilename)
+// Write the TOC files to the EPUB and add the TOC entries to the package file
+func (e *Epub) writeToc(z *zipWriter) error {
+	e.pkg.addToManifest(tocNavItemID, tocNavFilename, mediaTypeXhtml, "", tocNavItemProperties)
+	e.pkg.addToManifest(tocNcxItemID, tocNcxFilename, mediaTypeNcx, "", "")
 
-	if err := os.WriteFile(mimetypeFilePath, []byte(mediaTypeEpub), filePermissions); err != nil {
-		panic(fmt.Sprintf("Error writing mimetype file: %s", err))
+	e.addLandmarks()
+
+	err := e.toc.write(z)
+	if err != nil {
+		return err
 	}
-}
 
-func (e *Epub) writePackageFile(tempDir string) {
-	e.pkg.write(tempDir)
-}
+	if e.tocPage.enabled && len(e.sections) > 0 {
+		return e.writeTocPage(z, e.tocPageFilename())
+	}
 
-// Write the section files to the temporary directory and add the sections to
-// the TOC and package files
-func (e *Epub) writeSections(tempDir string) {
-	if len(e.sections) > 0 {
-		// If a cover was set, add it to the package spine first so it shows up
-		// first in the reading order
-		if e.cover.xhtmlFilename != "" {
-			e.pkg.addToSpine(e.cover.xhtmlFilename)
-		}
+	return nil
+}
 
-		for i, section := range e.sections {
-			// Set the title of the cover page XHTML to the title of the EPUB
-			if section.filename == e.cover.xhtmlFilename {
-				section.xhtml.setTitle(e.Title())
-			}
+// Add the cover, the TOC and the start of the content to the landmarks and to
+// the EPUB v2 guide
+func (e *Epub) addLandmarks() {
+	addLandmark := func(epubType string, guideType string, title string, relativePath string) {
+		e.toc.addLandmark(epubType, title, relativePath)
+		e.pkg.addToGuide(guideType, title, relativePath)
+	}
 
-			sectionFilePath := filepath.Join(tempDir, contentFolderName, xhtmlFolderName, section.filename)
-			section.xhtml.write(sectionFilePath)
+	if e.cover.xhtmlFilename != "" {
+		addLandmark("cover", "cover", tocCoverTitle, path.Join(xhtmlFolderName, e.cover.xhtmlFilename))
+	}
+	// Reading systems can show the TOC page, but not always the TOC file
+	if e.tocPage.enabled && len(e.sections) > 0 {
+		addLandmark("toc", "toc", e.toc.heading, path.Join(xhtmlFolderName, e.tocPageFilename()))
+	} else {
+		addLandmark("toc", "toc", e.toc.heading, tocNavFilename)
+	}
 
-			relativePath := filepath.Join(xhtmlFolderName, section.filename)
-			// Don't add pages without titles or the cover to the TOC
-			if section.xhtml.Title() != "" && section.filename != e.cover.xhtmlFilename {
-				e.toc.addSection(i, section.xhtml.Title(), relativePath)
-			}
-			// The cover page should have already been added to the spine first
-			if section.filename != e.cover.xhtmlFilename {
-				e.pkg.addToSpine(section.filename)
-			}
-			e.pkg.addToManifest(section.filename, relativePath, mediaTypeXhtml, "")
+	// The content starts with the first section after the cover
+	for _, section := range e.sections {
+		if section.filename != e.cover.xhtmlFilename {
+			addLandmark("bodymatter", "text", tocBodymatterTitle, path.Join(xhtmlFolderName, section.filename))
+			break
 		}
 	}
 }
-
-// Write the TOC file to the temporary directory and add the TOC entries to the
-// package file
-func (e *Epub) writeToc(tempDir string) {
-	e.pkg.addToManifest(tocNavItemID, tocNavFilename, mediaTypeXhtml, tocNavItemProperties)
-	e.pkg.addToManifest(tocNcxItemID, tocNcxFilename, mediaTypeNcx, "")
-
-	e.toc.write(tempDir)
-}
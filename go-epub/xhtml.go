@@ -3,7 +3,8 @@ package epub
 import (
 	"encoding/xml"
 	"fmt"
-	"os"
+	"io"
+	"strings"
 )
 
 const (
@@ -21,6 +22,12 @@ const (
 `
 )
 
+// Page breaks are marked with epub:type="pagebreak" or role="doc-pagebreak"
+const (
+	xhtmlPageBreakEpubType = "pagebreak"
+	xhtmlPageBreakRole     = "doc-pagebreak"
+)
+
 // xhtml implements an XHTML document
 type xhtml struct {
 	xml *xhtmlRoot
@@ -35,8 +42,20 @@ type xhtmlRoot struct {
 }
 
 type xhtmlHead struct {
-	Title string `xml:"title"`
-	Link  *xhtmlLink
+	Title    string `xml:"title"`
+	Viewport *xhtmlMeta
+	Links    []xhtmlLink
+	// Other elements of the head, which aren't validated, each on a line of
+	// its own
+	Elements string `xml:",innerxml"`
+}
+
+// The <meta> element, used for the viewport of pre-paginated sections
+// Ex: <meta name="viewport" content="width=1200, height=1600" />
+type xhtmlMeta struct {
+	XMLName xml.Name `xml:"meta"`
+	Name    string   `xml:"name,attr"`
+	Content string   `xml:"content,attr"`
 }
 
 // The <link> element, used to link to stylesheets
@@ -55,6 +74,13 @@ type xhtmlInnerxml struct {
 	XML string `xml:",innerxml"`
 }
 
+// A page break marker in the body of an XHTML document
+// Ex: <span epub:type="pagebreak" role="doc-pagebreak" id="page12" aria-label="12" />
+type xhtmlPageBreak struct {
+	id    string
+	label string
+}
+
 // Constructor for xhtml
 func newXhtml(body string) *xhtml {
 	x := &xhtml{
@@ -78,19 +104,104 @@ func newXhtmlRoot() *xhtmlRoot {
 			*r,
 			xhtmlTemplate))
 	}
+	// The elements of the template all have fields of their own
+	r.Head.Elements = ""
 
 	return r
 }
 
 func (x *xhtml) setBody(body string) {
 	x.xml.Body.XML = "\n" + body + "\n"
+
+	// The epub namespace must be declared for epub:type attributes in the body
+	if strings.Contains(body, "epub:") {
+		x.setXmlnsEpub(xmlnsEpub)
+	}
 }
 
 func (x *xhtml) setCSS(path string) {
-	x.xml.Head.Link = &xhtmlLink{
+	x.xml.Head.Links = nil
+	x.addCSS(path)
+}
+
+func (x *xhtml) addCSS(path string) {
+	x.xml.Head.Links = append(x.xml.Head.Links, xhtmlLink{
 		Rel:  xhtmlLinkRel,
 		Type: mediaTypeCSS,
 		Href: path,
+	})
+}
+
+func (x *xhtml) addHeadElement(element string) {
+	x.xml.Head.Elements += "\n    " + strings.TrimSpace(element)
+
+	// The epub namespace must be declared for epub:type attributes in the head
+	if strings.Contains(element, "epub:") {
+		x.setXmlnsEpub(xmlnsEpub)
+	}
+}
+
+// Return the paths of the stylesheets linked from the head
+func (x *xhtml) css() []string {
+	var paths []string
+	for _, link := range x.xml.Head.Links {
+		paths = append(paths, link.Href)
+	}
+	return paths
+}
+
+// Return a copy of the document that links to the stylesheet with the given
+// path before its own stylesheets, unless it already links to it
+func (x *xhtml) withDefaultCSS(path string) *xhtml {
+	if path == "" {
+		return x
+	}
+	for _, link := range x.xml.Head.Links {
+		if link.Href == path {
+			return x
+		}
+	}
+
+	root := *x.xml
+	root.Head.Links = append([]xhtmlLink{{
+		Rel:  xhtmlLinkRel,
+		Type: mediaTypeCSS,
+		Href: path,
+	}}, root.Head.Links...)
+
+	return &xhtml{xml: &root}
+}
+
+// Return a copy of the document that also links to the stylesheets with the
+// given paths, after its own stylesheets, skipping those it already links to
+func (x *xhtml) withCSS(paths []string) *xhtml {
+	root := *x.xml
+	root.Head.Links = append([]xhtmlLink(nil), root.Head.Links...)
+	for _, path := range paths {
+		linked := false
+		for _, link := range root.Head.Links {
+			linked = linked || link.Href == path
+		}
+		if !linked {
+			root.Head.Links = append(root.Head.Links, xhtmlLink{
+				Rel:  xhtmlLinkRel,
+				Type: mediaTypeCSS,
+				Href: path,
+			})
+		}
+	}
+
+	return &xhtml{xml: &root}
+}
+
+func (x *xhtml) setViewport(width int, height int) {
+	if width <= 0 || height <= 0 {
+		x.xml.Head.Viewport = nil
+		return
+	}
+	x.xml.Head.Viewport = &xhtmlMeta{
+		Name:    xhtmlViewportName,
+		Content: fmt.Sprintf(xhtmlViewportFormat, width, height),
 	}
 }
 
@@ -106,15 +217,96 @@ func (x *xhtml) Title() string {
 	return x.xml.Head.Title
 }
 
-// Write the XHTML file to the specified path
-func (x *xhtml) write(xhtmlFilePath string) {
+// Return the page break markers of the body that have an id, in document
+// order. A page break is labelled with its aria-label or title attribute, or
+// else with its text.
+func (x *xhtml) pageBreaks() []xhtmlPageBreak {
+	d := xml.NewDecoder(strings.NewReader(
+		`<body xmlns:epub="` + xmlnsEpub + `">` + x.xml.Body.XML + `</body>`))
+	// The body isn't validated, so be as lenient as an HTML parser
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var pageBreaks []xhtmlPageBreak
+	// The page break whose text is being read, and how deep inside it we are
+	var current *xhtmlPageBreak
+	var depth int
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Keep the page breaks found so far
+			break
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if current != nil {
+				depth++
+				continue
+			}
+			var id, label, title string
+			isPageBreak := false
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Local == "id":
+					id = attr.Value
+				case attr.Name.Local == "aria-label":
+					label = attr.Value
+				case attr.Name.Local == "title":
+					title = attr.Value
+				case attr.Name.Local == "type" && attr.Name.Space == xmlnsEpub:
+					isPageBreak = isPageBreak || hasProperty(attr.Value, xhtmlPageBreakEpubType)
+				case attr.Name.Local == "role":
+					isPageBreak = isPageBreak || hasProperty(attr.Value, xhtmlPageBreakRole)
+				}
+			}
+			if !isPageBreak || id == "" {
+				continue
+			}
+			if label == "" {
+				label = title
+			}
+			pageBreaks = append(pageBreaks, xhtmlPageBreak{id: id, label: label})
+			if label == "" {
+				current, depth = &pageBreaks[len(pageBreaks)-1], 0
+			}
+		case xml.EndElement:
+			if current == nil {
+				continue
+			}
+			if depth == 0 {
+				current.label = strings.TrimSpace(current.label)
+				current = nil
+			} else {
+				depth--
+			}
+		case xml.CharData:
+			if current != nil {
+				current.label += string(t)
+			}
+		}
+	}
+
+	// Page breaks without any label can't be listed
+	labelled := pageBreaks[:0]
+	for _, pageBreak := range pageBreaks {
+		if pageBreak.label != "" {
+			labelled = append(labelled, pageBreak)
+		}
+	}
+
+	return labelled
+}
+
+// Write the XHTML file to the specified path inside the EPUB
+func (x *xhtml) write(z *zipWriter, xhtmlFilePath string) error {
 	xhtmlFileContent, err := xml.MarshalIndent(x.xml, "", "  ")
 	if err != nil {
-		panic(fmt.Sprintf(
-			"Error marshalling XML for XHTML file: %s\n"+
-				"\tXML=%#v",
-			err,
-			x.xml))
+		return &UnableToWriteEpubError{Filename: xhtmlFilePath, Err: err}
 	}
 
 	// Add the doctype declaration to the output
@@ -124,7 +316,5 @@ func (x *xhtml) write(xhtmlFilePath string) {
 	// It's generally nice to have files end with a newline
 	xhtmlFileContent = append(xhtmlFileContent, "\n"...)
 
-	if err := os.WriteFile(xhtmlFilePath, []byte(xhtmlFileContent), filePermissions); err != nil {
-		panic(fmt.Sprintf("Error writing XHTML file: %s", err))
-	}
+	return writeZipFile(z, xhtmlFilePath, xhtmlFileContent)
 }
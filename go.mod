@@ -5,13 +5,11 @@ go 1.20
 require (
 	github.com/PuerkitoBio/goquery v1.8.1
 	github.com/alecthomas/kong v0.8.0
+	github.com/andybalholm/cascadia v1.3.1
 	github.com/gabriel-vasile/mimetype v1.4.2
 	github.com/gofrs/uuid v4.4.0+incompatible
-	github.com/gonejack/get v1.0.11
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.8.0
 )
 
-require (
-	github.com/andybalholm/cascadia v1.3.1 // indirect
-	golang.org/x/net v0.8.0 // indirect
-	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
-)
+require golang.org/x/text v0.16.0 // indirect
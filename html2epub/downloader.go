@@ -0,0 +1,319 @@
+package html2epub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errAborted = errors.New("download aborted")
+
+type downloadTask struct {
+	Link string
+	Path string
+	Err  error
+
+	host     string
+	attempts int
+	done     chan struct{}
+}
+
+// Wait blocks until the task has been downloaded or given up on.
+func (t *downloadTask) Wait() error {
+	<-t.done
+	return t.Err
+}
+
+// hostQueue holds pending tasks of one host with its politeness state.
+type hostQueue struct {
+	name    string
+	pending []*downloadTask
+	active  int
+	next    time.Time // earliest time the next request may start
+}
+
+// downloader fetches remote files with per-host politeness: at most perHost
+// requests in flight and interval between request starts for any one host.
+// Hosts are served round-robin so a big site does not starve the others, and
+// a Retry-After on 429/503 pauses the host instead of failing the task, unless
+// it asks for longer than the download timeout.
+type downloader struct {
+	client   *http.Client
+	workers  int
+	perHost  int
+	interval time.Duration
+	retries  int
+
+	// ctx is cancelled by Abort to stop requests in flight
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	hosts  map[string]*hostQueue
+	ring   []*hostQueue
+	cursor int
+	wake   chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func newDownloader(workers, perHost int, interval, timeout time.Duration, retries int) *downloader {
+	if workers < 1 {
+		workers = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &downloader{
+		client:   &http.Client{Timeout: timeout},
+		ctx:      ctx,
+		cancel:   cancel,
+		workers:  workers,
+		perHost:  perHost,
+		interval: interval,
+		retries:  retries,
+		hosts:    make(map[string]*hostQueue),
+		wake:     make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+// Add schedules link to be saved at path and returns the task to wait on.
+func (d *downloader) Add(link, path string) *downloadTask {
+	t := &downloadTask{Link: link, Path: path, done: make(chan struct{})}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Err = err
+		close(t.done)
+		return t
+	}
+	t.host = u.Host
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.closed {
+		t.Err = errors.New("downloader closed")
+		close(t.done)
+		return t
+	}
+	d.enqueue(t, false)
+	return t
+}
+
+// Close waits for all scheduled tasks to finish and stops the workers.
+func (d *downloader) Close() {
+	d.mu.Lock()
+	d.closed = true
+	d.broadcast()
+	d.mu.Unlock()
+	d.wg.Wait()
+	d.cancel()
+}
+
+// Abort drops the pending tasks, stops the requests in flight and waits for
+// the workers to stop. Dropped and stopped tasks fail with errAborted.
+func (d *downloader) Abort() {
+	d.mu.Lock()
+	d.closed = true
+	d.cancel()
+	for _, q := range d.ring {
+		for _, t := range q.pending {
+			t.Err = errAborted
+			close(t.done)
+		}
+		q.pending = nil
+	}
+	d.broadcast()
+	d.mu.Unlock()
+	d.wg.Wait()
+}
+
+func (d *downloader) enqueue(t *downloadTask, front bool) {
+	q, exist := d.hosts[t.host]
+	if !exist {
+		q = &hostQueue{name: t.host}
+		d.hosts[t.host] = q
+		d.ring = append(d.ring, q)
+	}
+	if front {
+		q.pending = append([]*downloadTask{t}, q.pending...)
+	} else {
+		q.pending = append(q.pending, t)
+	}
+	d.broadcast()
+}
+
+// broadcast wakes every idle worker; must be called with d.mu held.
+func (d *downloader) broadcast() {
+	close(d.wake)
+	d.wake = make(chan struct{})
+}
+
+// pick returns the next task allowed to start, or how long to wait before one
+// might be; must be called with d.mu held.
+func (d *downloader) pick(now time.Time) (t *downloadTask, wait time.Duration, idle bool) {
+	idle = true
+	for i := 0; i < len(d.ring); i++ {
+		q := d.ring[(d.cursor+i)%len(d.ring)]
+		if len(q.pending) == 0 {
+			continue
+		}
+		idle = false
+		if q.active >= d.perHost {
+			continue
+		}
+		if delay := q.next.Sub(now); delay > 0 {
+			if wait == 0 || delay < wait {
+				wait = delay
+			}
+			continue
+		}
+		t, q.pending = q.pending[0], q.pending[1:]
+		q.active += 1
+		q.next = now.Add(d.interval)
+		d.cursor = (d.cursor + i + 1) % len(d.ring)
+		return t, 0, false
+	}
+	return nil, wait, idle
+}
+
+func (d *downloader) work() {
+	defer d.wg.Done()
+	for {
+		d.mu.Lock()
+		t, wait, idle := d.pick(time.Now())
+		if t == nil && idle && d.closed && d.running() == 0 {
+			d.mu.Unlock()
+			return
+		}
+		wake := d.wake
+		d.mu.Unlock()
+
+		if t != nil {
+			d.run(t)
+			continue
+		}
+		if wait > 0 {
+			timer := time.NewTimer(wait)
+			select {
+			case <-wake:
+			case <-timer.C:
+			}
+			timer.Stop()
+		} else {
+			<-wake
+		}
+	}
+}
+
+// running counts requests in flight; must be called with d.mu held.
+func (d *downloader) running() (n int) {
+	for _, q := range d.ring {
+		n += q.active
+	}
+	return
+}
+
+func (d *downloader) run(t *downloadTask) {
+	pause, err := d.fetch(t)
+	t.attempts += 1
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	q := d.hosts[t.host]
+	q.active -= 1
+	if err != nil && d.ctx.Err() != nil {
+		err = errAborted
+	}
+	if err != nil && err != errAborted && pause >= 0 && t.attempts <= d.retries {
+		if pause < d.interval {
+			pause = d.interval * time.Duration(t.attempts)
+		}
+		if next := time.Now().Add(pause); next.After(q.next) {
+			q.next = next
+		}
+		d.enqueue(t, true)
+		return
+	}
+	t.Err = err
+	close(t.done)
+	d.broadcast()
+}
+
+// fetch downloads one task. A negative pause means the error is permanent,
+// otherwise the request may be retried after pause.
+func (d *downloader) fetch(t *downloadTask) (pause time.Duration, err error) {
+	if st, err := os.Stat(t.Path); err == nil && st.Size() > 0 {
+		return 0, nil
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodGet, t.Link, nil)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable:
+		pause = retryAfter(resp.Header.Get("Retry-After"), time.Now())
+		// don't hold up the host for longer than a download may take
+		if d.client.Timeout > 0 && pause > d.client.Timeout {
+			return -1, fmt.Errorf("server responded %s, retry after %s", resp.Status, pause)
+		}
+		return pause, fmt.Errorf("server responded %s", resp.Status)
+	case resp.StatusCode >= 500:
+		return 0, fmt.Errorf("server responded %s", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return -1, fmt.Errorf("server responded %s", resp.Status)
+	}
+
+	temp := t.Path + ".download"
+	fd, err := os.Create(temp)
+	if err != nil {
+		return -1, err
+	}
+	_, err = io.Copy(fd, resp.Body)
+	_ = fd.Close()
+	if err != nil {
+		_ = os.Remove(temp)
+		return 0, err
+	}
+	err = os.Rename(temp, t.Path)
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// retryAfter parses a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(value); err == nil && sec > 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
@@ -0,0 +1,223 @@
+package html2epub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testHost is a server that records when requests start and how many run at
+// once.
+type testHost struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	active  int
+	most    int
+	starts  []time.Time
+	respond func(w http.ResponseWriter, r *http.Request, n int)
+}
+
+func newTestHost(t *testing.T, respond func(w http.ResponseWriter, r *http.Request, n int)) *testHost {
+	h := &testHost{respond: respond}
+	h.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		h.active += 1
+		if h.active > h.most {
+			h.most = h.active
+		}
+		h.starts = append(h.starts, time.Now())
+		n := len(h.starts)
+		h.mu.Unlock()
+
+		h.respond(w, r, n)
+
+		h.mu.Lock()
+		h.active -= 1
+		h.mu.Unlock()
+	}))
+	t.Cleanup(h.Close)
+	return h
+}
+
+// gaps returns the time between consecutive request starts.
+func (h *testHost) gaps() (gaps []time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	starts := append([]time.Time(nil), h.starts...)
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	for i := 1; i < len(starts); i++ {
+		gaps = append(gaps, starts[i].Sub(starts[i-1]))
+	}
+	return
+}
+
+func TestDownloaderPerHost(t *testing.T) {
+	const (
+		perHost  = 2
+		interval = 40 * time.Millisecond
+		tasks    = 6
+	)
+	slow := func(w http.ResponseWriter, r *http.Request, n int) {
+		time.Sleep(150 * time.Millisecond)
+		fmt.Fprint(w, r.URL.Path)
+	}
+	hosts := []*testHost{newTestHost(t, slow), newTestHost(t, slow)}
+
+	dir := t.TempDir()
+	d := newDownloader(8, perHost, interval, 10*time.Second, 0)
+	var all []*downloadTask
+	for i, host := range hosts {
+		for j := 0; j < tasks; j++ {
+			all = append(all, d.Add(fmt.Sprintf("%s/%d", host.URL, j), filepath.Join(dir, fmt.Sprintf("%d-%d", i, j))))
+		}
+	}
+	start := time.Now()
+	d.Close()
+	elapsed := time.Since(start)
+
+	for _, task := range all {
+		if err := task.Wait(); err != nil {
+			t.Fatalf("%s: %s", task.Link, err)
+		}
+		data, err := os.ReadFile(task.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := task.Link[len(task.Link)-2:]; string(data) != want {
+			t.Errorf("%s saved %q, want %q", task.Link, data, want)
+		}
+	}
+
+	for i, host := range hosts {
+		host.mu.Lock()
+		most := host.most
+		host.mu.Unlock()
+		if most != perHost {
+			t.Errorf("host %d: at most %d requests at once, want %d", i, most, perHost)
+		}
+		for _, gap := range host.gaps() {
+			// allow for the time between picking a task and the server
+			// receiving its request
+			if gap < interval-10*time.Millisecond {
+				t.Errorf("host %d: requests %s apart, want at least %s", i, gap, interval)
+			}
+		}
+	}
+
+	// the hosts are served side by side rather than one after the other
+	if serial := time.Duration(len(hosts)*tasks/perHost) * 150 * time.Millisecond; elapsed >= serial {
+		t.Errorf("took %s, as long as serving the hosts in turn (%s)", elapsed, serial)
+	}
+}
+
+func TestDownloaderRetryAfter(t *testing.T) {
+	host := newTestHost(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if n == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+
+	d := newDownloader(2, 1, 10*time.Millisecond, 10*time.Second, 2)
+	task := d.Add(host.URL+"/image.png", filepath.Join(t.TempDir(), "image.png"))
+	// another task of the host waits for the pause too
+	other := d.Add(host.URL+"/other.png", filepath.Join(t.TempDir(), "other.png"))
+	d.Close()
+
+	if err := task.Wait(); err != nil {
+		t.Fatalf("retried task failed: %s", err)
+	}
+	if err := other.Wait(); err != nil {
+		t.Fatalf("other task failed: %s", err)
+	}
+	if task.attempts != 2 {
+		t.Errorf("%d attempts, want 2", task.attempts)
+	}
+
+	gaps := host.gaps()
+	if len(gaps) != 2 {
+		t.Fatalf("%d requests, want 3", len(gaps)+1)
+	}
+	if gaps[0] < 950*time.Millisecond {
+		t.Errorf("request after 429 came %s later, want the 1s of Retry-After", gaps[0])
+	}
+}
+
+func TestDownloaderGiveUp(t *testing.T) {
+	host := newTestHost(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if r.URL.Path == "/gone.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	d := newDownloader(2, 1, time.Millisecond, 10*time.Second, 2)
+	gone := d.Add(host.URL+"/gone.png", filepath.Join(t.TempDir(), "gone.png"))
+	down := d.Add(host.URL+"/down.png", filepath.Join(t.TempDir(), "down.png"))
+	d.Close()
+
+	if err := gone.Wait(); err == nil || gone.attempts != 1 {
+		t.Errorf("404: error %v after %d attempts, want an error after 1", err, gone.attempts)
+	}
+	if err := down.Wait(); err == nil || down.attempts != 3 {
+		t.Errorf("503: error %v after %d attempts, want an error after 3", err, down.attempts)
+	}
+}
+
+func TestDownloaderRetryAfterTooLong(t *testing.T) {
+	host := newTestHost(t, func(w http.ResponseWriter, r *http.Request, n int) {
+		if r.URL.Path == "/busy.png" {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprint(w, "ok")
+	})
+
+	d := newDownloader(2, 1, time.Millisecond, 10*time.Second, 2)
+	busy := d.Add(host.URL+"/busy.png", filepath.Join(t.TempDir(), "busy.png"))
+	other := d.Add(host.URL+"/other.png", filepath.Join(t.TempDir(), "other.png"))
+	start := time.Now()
+	d.Close()
+
+	// an hour is more than the timeout, the task fails instead of waiting
+	if err := busy.Wait(); err == nil || busy.attempts != 1 {
+		t.Errorf("error %v after %d attempts, want an error after 1", err, busy.attempts)
+	}
+	if err := other.Wait(); err != nil {
+		t.Errorf("other task failed: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("host paused for %s", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	for _, tt := range []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{"Mon, 06 May 2024 07:08:39 GMT", 30 * time.Second},
+		{"Mon, 06 May 2024 07:00:00 GMT", 0},
+	} {
+		if got := retryAfter(tt.value, now); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
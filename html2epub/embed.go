@@ -0,0 +1,298 @@
+package html2epub
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+var (
+	youtubeRe  = regexp.MustCompile(`^(?:https?:)?//(?:www\.)?(?:youtube\.com|youtube-nocookie\.com)/embed/([\w-]+)`)
+	vimeoRe    = regexp.MustCompile(`^(?:https?:)?//player\.vimeo\.com/video/(\d+)`)
+	mastodonRe = regexp.MustCompile(`^(https?://[^/]+)/@[^/]+/(\d+)/embed`)
+)
+
+// lookup endpoints, overridden by tests
+var (
+	vimeoOEmbed   = "https://vimeo.com/api/oembed.json?url="
+	twitterOEmbed = "https://publish.twitter.com/oembed?omit_script=true&dnt=true&url="
+)
+
+const (
+	videoPreviewTemplate = `<div class="embed-video"><a href="%[1]s"><img src="%[2]s" alt="%[3]s"/></a><p><a href="%[1]s">&#9654; %[4]s</a></p></div>`
+	videoLinkTemplate    = `<div class="embed-video"><p><a href="%[1]s">&#9654; %[2]s</a></p></div>`
+	postLinkTemplate     = `<blockquote class="embed-post"><p><a href="%[1]s">%[2]s</a></p></blockquote>`
+	postTemplate         = `<blockquote class="embed-post">%[1]s<p>&mdash; %[2]s <a href="%[3]s">%[4]s</a></p></blockquote>`
+)
+
+// Kinds of embeds looked up through the downloader.
+const (
+	embedVimeo    = "vimeo"
+	embedMastodon = "mastodon"
+	embedTwitter  = "twitter"
+)
+
+// embed is a video or post whose details are looked up while its page waits
+// to be added. Until then it shows as a plain link.
+type embed struct {
+	kind   string
+	link   string
+	title  string
+	block  *goquery.Selection
+	lookup *downloadTask
+}
+
+// convertEmbeds replaces video players and social media embeds, which cannot
+// work in an EPUB, with static blocks linking to the original. It must run
+// before sanitizeDoc removes the iframes and scripts they are made of.
+//
+// The thumbnails of Vimeo videos and the text of Mastodon and Twitter posts
+// are looked up through the shared downloader; the returned embeds are
+// completed by resolveEmbeds once the lookups finish.
+func (h *HtmlToEpub) convertEmbeds(doc *goquery.Document) (embeds []*embed) {
+	if h.NoEmbeds {
+		return
+	}
+
+	// rendered tweets that kept the original blockquote need no lookup
+	doc.Find(".twitter-tweet-rendered").Each(func(i int, widget *goquery.Selection) {
+		id, _ := widget.Find("iframe").Attr("data-tweet-id")
+		if id != "" && doc.Find(fmt.Sprintf(`blockquote.twitter-tweet a[href*="/status/%s"]`, id)).Length() > 0 {
+			widget.Remove()
+		}
+	})
+
+	doc.Find("iframe").Each(func(i int, frame *goquery.Selection) {
+		src, _ := frame.Attr("src")
+		if src == "" {
+			src, _ = frame.Attr("data-src")
+		}
+		title, _ := frame.Attr("title")
+
+		var e *embed
+		switch {
+		case youtubeRe.MatchString(src):
+			id := youtubeRe.FindStringSubmatch(src)[1]
+			link := "https://www.youtube.com/watch?v=" + id
+			thumb := "https://img.youtube.com/vi/" + id + "/hqdefault.jpg"
+			frame.ReplaceWithHtml(videoPreview(link, thumb, title, "Watch on YouTube"))
+			return
+		case vimeoRe.MatchString(src):
+			link := "https://vimeo.com/" + vimeoRe.FindStringSubmatch(src)[1]
+			e = &embed{kind: embedVimeo, link: link, title: title}
+			e.lookup = h.lookupEmbed(vimeoOEmbed + url.QueryEscape(link))
+		case mastodonRe.MatchString(src):
+			m := mastodonRe.FindStringSubmatch(src)
+			e = &embed{kind: embedMastodon, link: strings.TrimSuffix(m[0], "/embed")}
+			e.lookup = h.lookupEmbed(m[1] + "/api/v1/statuses/" + m[2])
+		default:
+			id, exist := frame.Attr("data-tweet-id")
+			if !exist {
+				return
+			}
+			e = &embed{kind: embedTwitter, link: "https://twitter.com/i/web/status/" + id}
+			e.lookup = h.lookupEmbed(twitterOEmbed + url.QueryEscape(e.link))
+		}
+
+		e.block = parseHTML(e.fallback()).Find("body").Children().First()
+		frame.ReplaceWithSelection(e.block)
+		embeds = append(embeds, e)
+	})
+
+	doc.Find("blockquote.twitter-tweet, blockquote.mastodon-embed").Each(func(i int, quote *goquery.Selection) {
+		cleanPost(quote)
+	})
+
+	return
+}
+
+// fallback is the block shown when the lookup of the embed fails.
+func (e *embed) fallback() string {
+	switch e.kind {
+	case embedVimeo:
+		return videoPreview(e.link, "", e.title, "Watch on Vimeo")
+	case embedMastodon:
+		u, _ := url.Parse(e.link)
+		return fmt.Sprintf(postLinkTemplate, html.EscapeString(e.link), "View post on "+html.EscapeString(u.Host))
+	default:
+		return fmt.Sprintf(postLinkTemplate, html.EscapeString(e.link), "View post on Twitter")
+	}
+}
+
+func (h *HtmlToEpub) lookupEmbed(endpoint string) *downloadTask {
+	task, err := h.download(endpoint)
+	if err != nil {
+		log.Printf("cannot query %s: %s", endpoint, err)
+	}
+	return task
+}
+
+// resolveEmbeds fills in the embeds of a page from their lookups, leaving the
+// plain link of those that failed. Vimeo thumbnails are downloaded here and
+// added to the downloads of the page, like its other images.
+func (h *HtmlToEpub) resolveEmbeds(p *page) {
+	for _, e := range p.embeds {
+		if e.lookup == nil {
+			continue
+		}
+		if err := e.lookup.Wait(); err != nil {
+			log.Printf("cannot query %s: %s", e.lookup.Link, err)
+			continue
+		}
+		data, err := os.ReadFile(e.lookup.Path)
+		if err != nil {
+			continue
+		}
+
+		var block string
+		switch e.kind {
+		case embedVimeo:
+			block, err = h.vimeoBlock(e, data, p.downloads)
+		case embedMastodon:
+			block, err = h.mastodonBlock(e, data)
+		case embedTwitter:
+			block, err = h.twitterBlock(e, data)
+		}
+		if err != nil {
+			log.Printf("cannot parse %s: %s", e.lookup.Link, err)
+			continue
+		}
+		e.block.ReplaceWithHtml(block)
+	}
+}
+
+func (h *HtmlToEpub) vimeoBlock(e *embed, data []byte, downloads map[string]*downloadTask) (string, error) {
+	var resp struct {
+		Title        string `json:"title"`
+		ThumbnailURL string `json:"thumbnail_url"`
+	}
+	err := json.Unmarshal(data, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	title := e.title
+	if title == "" {
+		title = strings.TrimSpace(resp.Title)
+	}
+	thumb := strings.TrimSpace(resp.ThumbnailURL)
+	if strings.HasPrefix(thumb, "http") {
+		if _, exist := downloads[thumb]; !exist {
+			task, err := h.download(thumb)
+			if err != nil {
+				return "", err
+			}
+			_ = task.Wait()
+			downloads[thumb] = task
+		}
+	} else {
+		thumb = ""
+	}
+
+	return videoPreview(e.link, thumb, title, "Watch on Vimeo"), nil
+}
+
+func (h *HtmlToEpub) mastodonBlock(e *embed, data []byte) (string, error) {
+	var resp struct {
+		Content   string    `json:"content"`
+		URL       string    `json:"url"`
+		CreatedAt time.Time `json:"created_at"`
+		Account   struct {
+			DisplayName string `json:"display_name"`
+			Acct        string `json:"acct"`
+		} `json:"account"`
+	}
+	err := json.Unmarshal(data, &resp)
+	if err != nil {
+		return "", err
+	}
+	if resp.Content == "" {
+		return "", fmt.Errorf("no post content")
+	}
+
+	author := "@" + resp.Account.Acct
+	if resp.Account.DisplayName != "" {
+		author = fmt.Sprintf("%s (%s)", resp.Account.DisplayName, author)
+	}
+	link := e.link
+	if strings.HasPrefix(resp.URL, "http") {
+		link = resp.URL
+	}
+
+	return h.postBlock(resp.Content, author, link, resp.CreatedAt.Format("January 2, 2006")), nil
+}
+
+func (h *HtmlToEpub) twitterBlock(e *embed, data []byte) (string, error) {
+	var resp struct {
+		HTML       string `json:"html"`
+		AuthorName string `json:"author_name"`
+		AuthorURL  string `json:"author_url"`
+	}
+	err := json.Unmarshal(data, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	// the oEmbed html is the blockquote of the tweet: its text, then the
+	// author and a link labelled with the date
+	quote := parseHTML(resp.HTML).Find("blockquote").First()
+	text, _ := quote.Find("p").First().Html()
+	if text == "" {
+		return "", fmt.Errorf("no tweet text")
+	}
+	date := quote.Find("a").Last()
+	link := date.AttrOr("href", e.link)
+	if !strings.HasPrefix(link, "http") {
+		link = e.link
+	}
+
+	author := resp.AuthorName
+	if u, err := url.Parse(resp.AuthorURL); err == nil && strings.Trim(u.Path, "/") != "" {
+		author = fmt.Sprintf("%s (@%s)", author, strings.Trim(u.Path, "/"))
+	}
+
+	return h.postBlock("<p>"+text+"</p>", author, link, strings.TrimSpace(date.Text())), nil
+}
+
+// postBlock renders a post fetched from the web, sanitized like the pages.
+func (h *HtmlToEpub) postBlock(content, author, link, date string) string {
+	doc := parseHTML(content)
+	doc = h.sanitizeDoc(doc)
+	doc.Find("img").Remove()
+	content, _ = doc.Find("body").Html()
+
+	return fmt.Sprintf(postTemplate, content, html.EscapeString(author), html.EscapeString(link), html.EscapeString(date))
+}
+
+// cleanPost turns the blockquote of a post embed into a static quote.
+func cleanPost(quote *goquery.Selection) {
+	quote.RemoveAttr("style")
+	quote.RemoveAttr("data-width")
+	quote.RemoveAttr("data-dnt")
+	quote.SetAttr("class", "embed-post")
+	quote.Find("script, svg").Remove()
+}
+
+func parseHTML(s string) *goquery.Document {
+	// reading from a string does not fail
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader(s))
+	return doc
+}
+
+func videoPreview(link, thumb, title, label string) string {
+	if title != "" {
+		label = title + " - " + label
+	}
+	if thumb == "" {
+		return fmt.Sprintf(videoLinkTemplate, html.EscapeString(link), html.EscapeString(label))
+	}
+	return fmt.Sprintf(videoPreviewTemplate, html.EscapeString(link), html.EscapeString(thumb), html.EscapeString("Video: "+label), html.EscapeString(label))
+}
@@ -0,0 +1,196 @@
+package html2epub
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// newEmbedServer serves the Vimeo, Twitter and Mastodon lookups of the tests
+// and points the lookup endpoints to it.
+func newEmbedServer(t *testing.T) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/vimeo":
+			if r.URL.Query().Get("url") != "https://vimeo.com/76979871" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintf(w, `{"title": "The New Vimeo Player", "thumbnail_url": "%s/thumb.jpg"}`, server.URL)
+		case "/thumb.jpg":
+			fmt.Fprint(w, "thumbnail")
+		case "/twitter":
+			if r.URL.Query().Get("url") != "https://twitter.com/i/web/status/20" {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprint(w, `{"author_name": "jack", "author_url": "https://twitter.com/jack", "html": "<blockquote class=\"twitter-tweet\"><p lang=\"en\" dir=\"ltr\">just setting up my twttr</p>&mdash; jack (@jack) <a href=\"https://twitter.com/jack/status/20\">March 21, 2006</a></blockquote>\n<script async src=\"https://platform.twitter.com/widgets.js\"></script>"}`)
+		case "/api/v1/statuses/110":
+			fmt.Fprint(w, `{"content": "<p>Hello <a href=\"https://example.com\" onclick=\"track()\">world</a></p><script>alert(1)</script>", "url": "https://social.example/@alice/110", "created_at": "2023-04-05T06:07:08.000Z", "account": {"display_name": "Alice", "acct": "alice"}}`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	vimeo, twitter := vimeoOEmbed, twitterOEmbed
+	vimeoOEmbed, twitterOEmbed = server.URL+"/vimeo?url=", server.URL+"/twitter?url="
+	t.Cleanup(func() { vimeoOEmbed, twitterOEmbed = vimeo, twitter })
+
+	return server
+}
+
+// convertTestEmbeds converts the embeds of body and resolves them, as parse
+// and add do.
+func convertTestEmbeds(t *testing.T, body string) (*goquery.Document, *page) {
+	h := new(HtmlToEpub)
+	h.ImagesDir = t.TempDir()
+	h.tasks = make(map[string]*downloadTask)
+	h.dl = newDownloader(2, 2, time.Millisecond, 10*time.Second, 0)
+	defer h.dl.Close()
+
+	p := &page{doc: parseHTML(body), downloads: make(map[string]*downloadTask)}
+	p.embeds = h.convertEmbeds(p.doc)
+	p.doc = h.sanitizeDoc(p.doc)
+	h.resolveEmbeds(p)
+	return p.doc, p
+}
+
+func TestConvertYoutube(t *testing.T) {
+	doc, p := convertTestEmbeds(t, `<iframe src="https://www.youtube-nocookie.com/embed/dQw4w9WgXcQ?rel=0" title="Never Gonna"></iframe>`)
+
+	if doc.Find("iframe").Length() > 0 {
+		t.Error("iframe left")
+	}
+	if len(p.embeds) != 0 {
+		t.Errorf("%d lookups, want none", len(p.embeds))
+	}
+	video := doc.Find("div.embed-video")
+	if href := video.Find("a").First().AttrOr("href", ""); href != "https://www.youtube.com/watch?v=dQw4w9WgXcQ" {
+		t.Errorf("link %q", href)
+	}
+	if src := video.Find("img").AttrOr("src", ""); src != "https://img.youtube.com/vi/dQw4w9WgXcQ/hqdefault.jpg" {
+		t.Errorf("thumbnail %q", src)
+	}
+	if text := video.Find("p").Text(); text != "▶ Never Gonna - Watch on YouTube" {
+		t.Errorf("label %q", text)
+	}
+}
+
+func TestConvertVimeo(t *testing.T) {
+	server := newEmbedServer(t)
+	doc, p := convertTestEmbeds(t, `<p>before</p><iframe src="https://player.vimeo.com/video/76979871?h=8272103f6e"></iframe><p>after</p>`)
+
+	video := doc.Find("div.embed-video")
+	if video.Length() != 1 || doc.Find("iframe").Length() > 0 {
+		t.Fatalf("no video preview:\n%s", htmlOf(doc))
+	}
+	if prev := video.Prev().Text(); prev != "before" {
+		t.Errorf("preview not in place of the iframe:\n%s", htmlOf(doc))
+	}
+	if href := video.Find("a").First().AttrOr("href", ""); href != "https://vimeo.com/76979871" {
+		t.Errorf("link %q", href)
+	}
+	thumb := server.URL + "/thumb.jpg"
+	if src := video.Find("img").AttrOr("src", ""); src != thumb {
+		t.Errorf("thumbnail %q, want %q", src, thumb)
+	}
+	if text := video.Find("p").Text(); text != "▶ The New Vimeo Player - Watch on Vimeo" {
+		t.Errorf("label %q", text)
+	}
+	// the thumbnail is downloaded like the other images of the page
+	if task := p.downloads[thumb]; task == nil || task.Wait() != nil {
+		t.Errorf("thumbnail not downloaded: %v", task)
+	}
+}
+
+func TestConvertTwitter(t *testing.T) {
+	newEmbedServer(t)
+	doc, _ := convertTestEmbeds(t, `<div class="twitter-tweet twitter-tweet-rendered"><iframe data-tweet-id="20" src="https://platform.twitter.com/embed/Tweet.html?id=20"></iframe></div>`)
+
+	post := doc.Find("blockquote.embed-post")
+	if post.Length() != 1 || doc.Find("iframe, script").Length() > 0 {
+		t.Fatalf("no post:\n%s", htmlOf(doc))
+	}
+	if text := post.Find("p").First().Text(); text != "just setting up my twttr" {
+		t.Errorf("text %q", text)
+	}
+	if byline := post.Find("p").Last().Text(); byline != "— jack (@jack) March 21, 2006" {
+		t.Errorf("author and date %q", byline)
+	}
+	if href := post.Find("a").Last().AttrOr("href", ""); href != "https://twitter.com/jack/status/20" {
+		t.Errorf("link %q", href)
+	}
+}
+
+func TestConvertTwitterBlockquote(t *testing.T) {
+	// a rendered tweet next to its original blockquote keeps the blockquote
+	doc, p := convertTestEmbeds(t, `<blockquote class="twitter-tweet" data-dnt="true"><p>just setting up my twttr</p>&mdash; jack (@jack) <a href="https://twitter.com/jack/status/20">March 21, 2006</a></blockquote><div class="twitter-tweet-rendered"><iframe data-tweet-id="20"></iframe></div><script src="https://platform.twitter.com/widgets.js"></script>`)
+
+	if len(p.embeds) != 0 {
+		t.Errorf("%d lookups, want none", len(p.embeds))
+	}
+	post := doc.Find("blockquote.embed-post")
+	if post.Length() != 1 || doc.Find("iframe, script, .twitter-tweet-rendered").Length() > 0 {
+		t.Fatalf("want only the blockquote:\n%s", htmlOf(doc))
+	}
+	if _, exist := post.Attr("data-dnt"); exist {
+		t.Error("widget attributes left")
+	}
+	if text := post.Text(); !strings.Contains(text, "just setting up my twttr") || !strings.Contains(text, "March 21, 2006") {
+		t.Errorf("text %q", text)
+	}
+}
+
+func TestConvertMastodon(t *testing.T) {
+	server := newEmbedServer(t)
+	doc, _ := convertTestEmbeds(t, fmt.Sprintf(`<iframe src="%s/@alice/110/embed" class="mastodon-embed"></iframe><script src="%[1]s/embed.js"></script>`, server.URL))
+
+	post := doc.Find("blockquote.embed-post")
+	if post.Length() != 1 || doc.Find("iframe, script").Length() > 0 {
+		t.Fatalf("no post:\n%s", htmlOf(doc))
+	}
+	if text := post.Find("p").First().Text(); text != "Hello world" {
+		t.Errorf("text %q", text)
+	}
+	// the post is sanitized like the page
+	if _, exist := post.Find("a").First().Attr("onclick"); exist {
+		t.Error("event handler left in the post")
+	}
+	if byline := post.Find("p").Last().Text(); byline != "— Alice (@alice) April 5, 2023" {
+		t.Errorf("author and date %q", byline)
+	}
+	if href := post.Find("a").Last().AttrOr("href", ""); href != "https://social.example/@alice/110" {
+		t.Errorf("link %q", href)
+	}
+}
+
+func TestConvertEmbedLookupFails(t *testing.T) {
+	server := newEmbedServer(t)
+	doc, _ := convertTestEmbeds(t, fmt.Sprintf(`<iframe src="%s/@bob/404/embed"></iframe><iframe data-tweet-id="404"></iframe>`, server.URL))
+
+	// without the post, a link to it is left
+	var links []string
+	doc.Find("blockquote.embed-post a").Each(func(i int, a *goquery.Selection) {
+		links = append(links, a.AttrOr("href", "")+" "+a.Text())
+	})
+	host := strings.TrimPrefix(server.URL, "http://")
+	want := []string{
+		server.URL + "/@bob/404 View post on " + host,
+		"https://twitter.com/i/web/status/404 View post on Twitter",
+	}
+	if strings.Join(links, "\n") != strings.Join(want, "\n") {
+		t.Errorf("links %q, want %q", links, want)
+	}
+}
+
+func htmlOf(doc *goquery.Document) string {
+	s, _ := doc.Find("body").Html()
+	return s
+}
@@ -0,0 +1,149 @@
+package html2epub
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// What to do with an <img> whose image cannot be embedded.
+const (
+	failureKeep        = "keep"        // leave the original src in place
+	failureRemove      = "remove"      // drop the <img> element
+	failurePlaceholder = "placeholder" // embed a graphic carrying the alt text
+)
+
+const placeholderTemplate = `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" width="480" height="120" viewBox="0 0 480 120">
+  <rect x="1" y="1" width="478" height="118" fill="#f4f4f4" stroke="#999999" stroke-width="2" stroke-dasharray="8 4"/>
+  <text x="240" y="52" font-family="sans-serif" font-size="16" fill="#666666" text-anchor="middle">Image unavailable</text>
+  <text x="240" y="80" font-family="sans-serif" font-size="14" fill="#333333" text-anchor="middle">%s</text>
+</svg>
+`
+
+// size of placeholderTemplate, for fixed-layout pages
+const (
+	placeholderWidth  = 480
+	placeholderHeight = 120
+)
+
+// imageFailure records an image that could not be embedded.
+type imageFailure struct {
+	File   string `json:"file"`
+	Src    string `json:"src"`
+	Alt    string `json:"alt,omitempty"`
+	Error  string `json:"error"`
+	Action string `json:"action"`
+}
+
+func (h *HtmlToEpub) imageFailed(htmlFile string, img *goquery.Selection, cause error) {
+	src, _ := img.Attr("src")
+	alt, _ := img.Attr("alt")
+
+	action := h.OnImageFailure
+	switch action {
+	case failureRemove:
+		img.Remove()
+	case failurePlaceholder:
+		ref, err := h.placeholder(alt)
+		if err != nil {
+			log.Printf("cannot add placeholder for %s: %s", src, err)
+			action = failureKeep
+			break
+		}
+		img.SetAttr("src", ref)
+		if alt == "" {
+			img.SetAttr("alt", src)
+		}
+	default:
+		action = failureKeep
+	}
+	if action == failureKeep {
+		h.listRemoteImage(src)
+	}
+
+	log.Printf("%s: %s, %s image", htmlFile, cause, action)
+
+	h.failures = append(h.failures, imageFailure{
+		File:   htmlFile,
+		Src:    src,
+		Alt:    alt,
+		Error:  cause.Error(),
+		Action: action,
+	})
+}
+
+// placeholder adds an SVG showing alt to the book, once per distinct text.
+func (h *HtmlToEpub) placeholder(alt string) (ref string, err error) {
+	ref, exist := h.placeholderRefs[alt]
+	if exist {
+		return
+	}
+
+	text := alt
+	if utf8.RuneCountInString(text) > 50 {
+		text = string([]rune(text)[:49]) + "…"
+	}
+
+	svg := fmt.Sprintf(placeholderTemplate, html.EscapeString(text))
+	ref, err = h.addImageBytes([]byte(svg), ".svg", "image/svg+xml")
+	if err != nil {
+		return
+	}
+	h.placeholderRefs[alt] = ref
+	h.placeholders[ref] = true
+
+	return
+}
+
+// listRemoteImage adds a kept image that stays on the web to the manifest,
+// once per URL, as EPUB readers only load remote resources listed there.
+func (h *HtmlToEpub) listRemoteImage(src string) {
+	if !isRemote(src) || h.remoteImages[src] {
+		return
+	}
+	h.remoteImages[src] = true
+
+	// the media type cannot be checked without the image, guess it from
+	// the extension
+	u, err := url.Parse(src)
+	if err != nil {
+		return
+	}
+	mediaType := mime.TypeByExtension(path.Ext(u.Path))
+	if !strings.HasPrefix(mediaType, "image/") {
+		mediaType = "image/jpeg"
+	}
+	if _, err := h.book.AddRemoteMedia(src, mediaType); err != nil {
+		log.Printf("cannot list remote image %s: %s", src, err)
+	}
+}
+
+// writeFailureReport saves the failed images as JSON for later processing.
+func (h *HtmlToEpub) writeFailureReport() error {
+	if len(h.failures) > 0 {
+		log.Printf("%d image(s) could not be embedded", len(h.failures))
+	}
+	if h.FailureReport == "" {
+		return nil
+	}
+
+	failures := h.failures
+	if failures == nil {
+		failures = []imageFailure{}
+	}
+	data, err := json.MarshalIndent(failures, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(h.FailureReport, append(data, '\n'), 0644)
+}
@@ -0,0 +1,157 @@
+package html2epub
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+// newFailureTest returns a converter failing images with the given policy.
+func newFailureTest(onFailure string) *HtmlToEpub {
+	h := new(HtmlToEpub)
+	h.OnImageFailure = onFailure
+	h.book = epub.NewEpub("Failures")
+	h.placeholders = make(map[string]bool)
+	h.placeholderRefs = make(map[string]string)
+	h.remoteImages = make(map[string]bool)
+	return h
+}
+
+func TestImageFailed(t *testing.T) {
+	const src = "https://example.com/photo.png"
+	cause := errors.New("download failed")
+
+	for _, tt := range []struct {
+		policy string
+		alt    string
+		action string
+	}{
+		{failureKeep, "Photo", failureKeep},
+		{"", "Photo", failureKeep},
+		{failureRemove, "Photo", failureRemove},
+		{failurePlaceholder, "Photo", failurePlaceholder},
+		{failurePlaceholder, "", failurePlaceholder},
+	} {
+		h := newFailureTest(tt.policy)
+		doc := parseHTML(`<p><img src="` + src + `" alt="` + tt.alt + `"/></p>`)
+		h.imageFailed("page.html", doc.Find("img"), cause)
+
+		img := doc.Find("img")
+		switch tt.action {
+		case failureKeep:
+			if img.AttrOr("src", "") != src {
+				t.Errorf("%q: image not kept:\n%s", tt.policy, htmlOf(doc))
+			}
+			// the image stays on the web, listed in the manifest
+			if opf := string(readZipFile(t, h.book, "EPUB/package.opf")); !strings.Contains(opf, `href="`+src+`" media-type="image/png"`) {
+				t.Errorf("%q: remote image not in the manifest:\n%s", tt.policy, opf)
+			}
+		case failureRemove:
+			if img.Length() > 0 {
+				t.Errorf("%q: image not removed:\n%s", tt.policy, htmlOf(doc))
+			}
+		case failurePlaceholder:
+			ref := img.AttrOr("src", "")
+			if !h.placeholders[ref] || !strings.HasSuffix(ref, ".svg") {
+				t.Errorf("%q: src %q, want a placeholder", tt.policy, ref)
+			}
+			// without alt text, the placeholder tells the original src
+			if alt := img.AttrOr("alt", ""); tt.alt == "" && alt != src || tt.alt != "" && alt != tt.alt {
+				t.Errorf("%q: alt %q", tt.policy, alt)
+			}
+		}
+
+		want := imageFailure{File: "page.html", Src: src, Alt: tt.alt, Error: "download failed", Action: tt.action}
+		if len(h.failures) != 1 || h.failures[0] != want {
+			t.Errorf("%q: failures %+v, want %+v", tt.policy, h.failures, want)
+		}
+	}
+}
+
+func TestKeepLocalImage(t *testing.T) {
+	h := newFailureTest(failureKeep)
+	doc := parseHTML(`<img src="images/missing.png" alt=""/>`)
+	h.imageFailed("page.html", doc.Find("img"), errors.New("not found"))
+
+	if len(h.remoteImages) > 0 {
+		t.Errorf("local image listed as remote: %v", h.remoteImages)
+	}
+	if opf := string(readZipFile(t, h.book, "EPUB/package.opf")); strings.Contains(opf, "missing.png") {
+		t.Errorf("local image in the manifest:\n%s", opf)
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	h := newFailureTest(failurePlaceholder)
+	cause := errors.New("not found")
+
+	doc := parseHTML(`<img src="a.png" alt="&lt;b&gt;Fish &amp; chips&lt;/b&gt;"/><img src="b.png" alt="&lt;b&gt;Fish &amp; chips&lt;/b&gt;"/><img src="c.png" alt="` + strings.Repeat("x", 60) + `"/>`)
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		h.imageFailed("page.html", img, cause)
+	})
+
+	var refs []string
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		refs = append(refs, img.AttrOr("src", ""))
+	})
+	// one placeholder per distinct text
+	if refs[0] != refs[1] || refs[0] == refs[2] || len(h.placeholders) != 2 {
+		t.Fatalf("placeholders %q", refs)
+	}
+
+	svg := string(readZipFile(t, h.book, "EPUB/images/"+path.Base(refs[0])))
+	if !strings.Contains(svg, ">&lt;b&gt;Fish &amp; chips&lt;/b&gt;</text>") {
+		t.Errorf("alt text not escaped in the placeholder:\n%s", svg)
+	}
+	svg = string(readZipFile(t, h.book, "EPUB/images/"+path.Base(refs[2])))
+	if !strings.Contains(svg, ">"+strings.Repeat("x", 49)+"…</text>") {
+		t.Errorf("long alt text not shortened:\n%s", svg)
+	}
+}
+
+func TestFailureReport(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(page, []byte(`<html><body><p><img src="missing.png" alt="Gone"/></p></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := new(HtmlToEpub)
+	h.Title, h.Author = "Failures", "Author"
+	h.Output = filepath.Join(dir, "output.epub")
+	h.ImagesDir = filepath.Join(dir, "images")
+	h.Concurrency, h.HostConcurrency = 2, 2
+	h.OnImageFailure = failureRemove
+	h.FailureReport = filepath.Join(dir, "failures.json")
+	// the second page does not exist, failing the run after the first one
+	h.HTML = []string{page, filepath.Join(dir, "missing.html")}
+
+	if err := h.Run(); err == nil {
+		t.Fatal("run with a missing page succeeded")
+	}
+	if _, err := os.Stat(h.Output); err == nil {
+		t.Error("output written by a failed run")
+	}
+
+	data, err := os.ReadFile(h.FailureReport)
+	if err != nil {
+		t.Fatalf("no failure report: %s", err)
+	}
+	var failures []imageFailure
+	if err := json.Unmarshal(data, &failures); err != nil {
+		t.Fatalf("cannot parse failure report: %s\n%s", err, data)
+	}
+	if len(failures) != 1 {
+		t.Fatalf("failures %+v, want one", failures)
+	}
+	if f := failures[0]; f.File != page || f.Src != "missing.png" || f.Alt != "Gone" || f.Action != failureRemove || f.Error == "" {
+		t.Errorf("failure %+v", f)
+	}
+}
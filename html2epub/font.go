@@ -0,0 +1,568 @@
+package html2epub
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+var (
+	fontFaceRe = regexp.MustCompile(`(?is)@font-face\s*\{([^}]*)\}`)
+	fontSrcRe  = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)(?:\s*format\(\s*["']?([\w-]+)["']?\s*\))?`)
+
+	cssCommentRe = regexp.MustCompile(`(?s)/\*.*?\*/`)
+	styleRuleRe  = regexp.MustCompile(`([^{}]*)\{([^{}]*)\}`)
+	fontFamilyRe = regexp.MustCompile(`(?i)(?:^|[{;\s])font-family\s*:\s*([^;}]*)`)
+)
+
+// font formats an epub can embed, by extension and by css format() hint
+var (
+	fontExts    = map[string]string{".ttf": "font/ttf", ".otf": "font/otf", ".woff": "font/woff", ".woff2": "font/woff2"}
+	fontFormats = map[string]string{"truetype": ".ttf", "opentype": ".otf", "woff": ".woff", "woff2": ".woff2"}
+)
+
+// font weights named in font filenames, heavier names that contain lighter ones first
+var fontWeights = []struct {
+	name   string
+	weight string
+}{
+	{"extralight", "200"},
+	{"ultralight", "200"},
+	{"semibold", "600"},
+	{"demibold", "600"},
+	{"extrabold", "800"},
+	{"ultrabold", "800"},
+	{"thin", "100"},
+	{"light", "300"},
+	{"medium", "500"},
+	{"bold", "700"},
+	{"black", "900"},
+	{"heavy", "900"},
+}
+
+// setFonts embeds the --font files and, with --source-fonts, the @font-face
+// fonts of source pages, and generates the stylesheet linked from every
+// section, the cover page and the TOC page. Fonts are subset to the text of the
+// sections, so it must run once all pages are parsed and before the sections
+// are added.
+func (h *HtmlToEpub) setFonts() error {
+	if len(h.Font) == 0 && !h.SourceFonts {
+		return nil
+	}
+	if h.ObfuscateFonts {
+		h.book.SetFontObfuscation(true)
+	}
+
+	var css strings.Builder
+	family := h.FontFamily
+	for _, file := range h.Font {
+		if fontExts[strings.ToLower(filepath.Ext(file))] == "" {
+			return fmt.Errorf("unsupported font %s, expect ttf, otf, woff or woff2", file)
+		}
+		if _, err := os.Stat(file); err != nil {
+			return fmt.Errorf("cannot add font %s", err)
+		}
+		ref, err := h.embedFont(file, false, filepath.Base(file), strings.ToLower(filepath.Ext(file)))
+		if err != nil {
+			return fmt.Errorf("cannot add font %s", err)
+		}
+		stem, weight, style := fontStyle(filepath.Base(file))
+		if family == "" {
+			family = stem
+		}
+		fmt.Fprintf(&css, "@font-face {\n  font-family: %s;\n  font-weight: %s;\n  font-style: %s;\n  src: url(%s);\n}\n", cssString(family), weight, style, cssString(ref))
+	}
+	if family != "" && len(h.Font) > 0 {
+		fmt.Fprintf(&css, "body {\n  font-family: %s;\n}\n", cssString(family))
+	}
+
+	if h.SourceFonts {
+		h.sourceFonts(&css)
+	}
+
+	if css.Len() == 0 {
+		return nil
+	}
+	data := []byte(css.String())
+	ref, err := h.book.AddCSSBytes(data, "fonts.css", "")
+	var used *epub.FilenameAlreadyUsedError
+	if errors.As(err, &used) {
+		ref, err = h.book.AddCSSBytes(data, "", "")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot add font stylesheet %s", err)
+	}
+	h.fontCSS = ref
+	h.book.SetPageStylesheets(ref)
+
+	return nil
+}
+
+// stylesheet is an inline or linked stylesheet of a source page, collected
+// while the page is parsed. Remote stylesheets are downloaded meanwhile and
+// their rules read once the fonts are embedded.
+type stylesheet struct {
+	html string        // page of the stylesheet
+	base string        // location of the stylesheet, a file path or an URL
+	css  string        // rules of a local stylesheet
+	task *downloadTask // download of a remote stylesheet, or nil
+}
+
+// sourceFonts writes the @font-face rules of the source pages, rewritten to
+// the fonts embedded in the book, to css, followed by the rules selecting the
+// embedded families. The pages of all sections share the stylesheet, so the
+// rules of one page apply to the others too, and rules in @media blocks apply
+// regardless of the media.
+func (h *HtmlToEpub) sourceFonts(css *strings.Builder) {
+	var faces, rules []string
+	families := make(map[string]bool)
+	seen := make(map[string]bool)
+	for _, sheet := range h.styles {
+		sheetFaces, sheetRules := h.sourceFontFaces(sheet, seen)
+		for _, face := range sheetFaces {
+			for _, family := range fontFamilies(face) {
+				families[family] = true
+			}
+		}
+		faces = append(faces, sheetFaces...)
+		rules = append(rules, sheetRules...)
+	}
+
+	for _, face := range faces {
+		css.WriteString(face)
+	}
+	for _, rule := range rules {
+		for _, family := range fontFamilies(rule) {
+			if families[family] {
+				css.WriteString(rule)
+				break
+			}
+		}
+	}
+}
+
+// pageStylesheets collects the stylesheets of a source page that may have
+// @font-face or font-family rules and schedules the download of remote ones.
+func (h *HtmlToEpub) pageStylesheets(html string, doc *goquery.Document) (sheets []stylesheet) {
+	doc.Find("style").Each(func(i int, style *goquery.Selection) {
+		if hasFontRules(style.Text()) {
+			sheets = append(sheets, stylesheet{html: html, base: html, css: style.Text()})
+		}
+	})
+	doc.Find("link[rel~=stylesheet][href]").Each(func(i int, link *goquery.Selection) {
+		href, _ := link.Attr("href")
+		location, remote := resolveRef(html, href)
+		if remote {
+			task, err := h.download(location)
+			if err != nil {
+				log.Printf("%s: cannot read stylesheet %s: %s", html, href, err)
+				return
+			}
+			sheets = append(sheets, stylesheet{html: html, base: location, task: task})
+			return
+		}
+
+		fd, err := h.openLocalFile(html, location)
+		if err != nil {
+			if h.Verbose {
+				log.Printf("%s: cannot read stylesheet %s: %s", html, href, err)
+			}
+			return
+		}
+		data, err := io.ReadAll(fd)
+		_ = fd.Close()
+		if err != nil {
+			log.Printf("%s: cannot read stylesheet %s: %s", html, href, err)
+			return
+		}
+		if hasFontRules(string(data)) {
+			sheets = append(sheets, stylesheet{html: html, base: fd.Name(), css: string(data)})
+		}
+	})
+
+	return
+}
+
+// sourceFontFaces returns the @font-face rules of a source page's stylesheet,
+// rewritten to fonts embedded in the book, and its rules setting font-family.
+// Stylesheets, fonts and rules already in seen are skipped.
+func (h *HtmlToEpub) sourceFontFaces(sheet stylesheet, seen map[string]bool) (faces []string, rules []string) {
+	css := sheet.css
+	if sheet.task != nil {
+		// pages of a site usually link the same stylesheets
+		if seen[sheet.base] {
+			return
+		}
+		seen[sheet.base] = true
+
+		data, err := readDownload(sheet.task)
+		if err != nil {
+			log.Printf("%s: cannot read stylesheet %s: %s", sheet.html, sheet.base, err)
+			return
+		}
+		css = string(data)
+	}
+
+	for _, face := range fontFaces(css) {
+		rule, err := h.sourceFontFace(sheet.html, face, sheet.base, seen)
+		if err != nil {
+			log.Printf("%s: cannot embed font: %s", sheet.html, err)
+			continue
+		}
+		if rule != "" {
+			faces = append(faces, rule)
+		}
+	}
+	for _, rule := range fontFamilyRules(css) {
+		if !seen[rule] {
+			seen[rule] = true
+			rules = append(rules, rule)
+		}
+	}
+
+	return
+}
+
+// hasFontRules reports whether a stylesheet may have @font-face or
+// font-family rules.
+func hasFontRules(css string) bool {
+	css = strings.ToLower(css)
+	return strings.Contains(css, "@font-face") || strings.Contains(css, "font-family")
+}
+
+// fontFaces returns the bodies of the @font-face rules of a stylesheet.
+func fontFaces(css string) (faces []string) {
+	for _, m := range fontFaceRe.FindAllStringSubmatch(css, -1) {
+		faces = append(faces, m[1])
+	}
+	return
+}
+
+// fontFamilyRules returns the style rules of a stylesheet that set
+// font-family, reduced to that declaration, e.g. "h1, h2 {\n  font-family:
+// Lora, serif;\n}\n". At-rules are skipped, but the rules nested in @media
+// blocks are returned.
+func fontFamilyRules(css string) (rules []string) {
+	css = cssCommentRe.ReplaceAllString(css, "")
+	css = fontFaceRe.ReplaceAllString(css, "")
+	for _, m := range styleRuleRe.FindAllStringSubmatch(css, -1) {
+		// the selector follows any @import or @charset statement
+		selector := m[1]
+		if i := strings.LastIndexByte(selector, ';'); i >= 0 {
+			selector = selector[i+1:]
+		}
+		selector = strings.Join(strings.Fields(selector), " ")
+		if selector == "" || strings.HasPrefix(selector, "@") {
+			continue
+		}
+		for _, decl := range strings.Split(m[2], ";") {
+			kv := strings.SplitN(decl, ":", 2)
+			if len(kv) == 2 && strings.ToLower(strings.TrimSpace(kv[0])) == "font-family" {
+				rules = append(rules, fmt.Sprintf("%s {\n  font-family: %s;\n}\n", selector, strings.TrimSpace(kv[1])))
+			}
+		}
+	}
+	return
+}
+
+// fontFamilies returns the lowercased family names of the font-family
+// declarations of a rule.
+func fontFamilies(rule string) (families []string) {
+	for _, m := range fontFamilyRe.FindAllStringSubmatch(rule, -1) {
+		for _, name := range strings.Split(m[1], ",") {
+			name = strings.Trim(strings.TrimSpace(name), `"'`)
+			if name != "" {
+				families = append(families, strings.ToLower(name))
+			}
+		}
+	}
+	return
+}
+
+// sourceFontFace embeds the first usable font of an @font-face rule and
+// returns the rule pointing to it, or "" if the font was embedded already.
+// base is the location of the stylesheet, a file path or an URL.
+func (h *HtmlToEpub) sourceFontFace(html string, rule string, base string, seen map[string]bool) (string, error) {
+	// pages of a site usually share their rules
+	if seen[rule] {
+		return "", nil
+	}
+	seen[rule] = true
+
+	var src, ext string
+	for _, m := range fontSrcRe.FindAllStringSubmatch(rule, -1) {
+		ref := m[1] + m[2] + m[3]
+		if strings.HasPrefix(ref, "data:") {
+			mediaType, _, _ := mime.ParseMediaType(strings.SplitN(strings.TrimPrefix(ref, "data:"), ";", 2)[0])
+			exts, _ := mime.ExtensionsByType(mediaType)
+			if len(exts) > 0 && fontExts[exts[0]] != "" {
+				src, ext = ref, exts[0]
+			} else if e, ok := fontFormats[strings.ToLower(m[4])]; ok {
+				src, ext = ref, e
+			}
+		} else {
+			u, err := url.Parse(ref)
+			if err != nil {
+				continue
+			}
+			if e := strings.ToLower(path.Ext(u.Path)); fontExts[e] != "" {
+				src, ext = ref, e
+			} else if e, ok := fontFormats[strings.ToLower(m[4])]; ok {
+				src, ext = ref, e
+			}
+		}
+		if src != "" {
+			break
+		}
+	}
+	if src == "" {
+		return "", errors.New("no ttf, otf, woff or woff2 source in @font-face")
+	}
+
+	var ref string
+	var err error
+	switch {
+	case strings.HasPrefix(src, "data:"):
+		comma := strings.IndexByte(src, ',')
+		if comma < 0 || !strings.HasSuffix(src[:comma], ";base64") {
+			return "", errors.New("unsupported data url")
+		}
+		if seen[src] {
+			return "", nil
+		}
+		seen[src] = true
+		data, err := base64.StdEncoding.DecodeString(src[comma+1:])
+		if err != nil {
+			return "", err
+		}
+		data = h.subsetFont("data url in "+html, data)
+		ref, err = h.addFont("font"+ext, func(name string) (string, error) {
+			return h.book.AddFontBytes(data, name, fontExts[ext])
+		})
+		if err != nil {
+			return "", err
+		}
+	default:
+		location, remote := resolveRef(base, src)
+		if seen[location] {
+			return "", nil
+		}
+		seen[location] = true
+		if !remote {
+			fd, err := h.openLocalFile(html, location)
+			if err != nil {
+				return "", err
+			}
+			location = fd.Name()
+			fd.Close()
+		}
+		name := path.Base(strings.SplitN(location, "?", 2)[0])
+		if !strings.HasSuffix(strings.ToLower(name), ext) {
+			name += ext
+		}
+		ref, err = h.embedFont(location, remote, name, ext)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// keep the descriptors of the rule but the source
+	var sb strings.Builder
+	sb.WriteString("@font-face {\n")
+	for _, decl := range strings.Split(fontSrcRe.ReplaceAllString(rule, ""), ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name := strings.ToLower(strings.TrimSpace(kv[0]))
+		if name == "src" {
+			continue
+		}
+		fmt.Fprintf(&sb, "  %s: %s;\n", name, strings.TrimSpace(kv[1]))
+	}
+	fmt.Fprintf(&sb, "  src: url(%s);\n}\n", cssString(ref))
+
+	return sb.String(), nil
+}
+
+// readDownload waits for a download and reads the downloaded file.
+func readDownload(task *downloadTask) ([]byte, error) {
+	if err := task.Wait(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(task.Path)
+}
+
+// embedFont adds the font at location, a file path or an URL downloaded along
+// with the images, subset to the text of the sections unless --no-subset-fonts
+// is set.
+func (h *HtmlToEpub) embedFont(location string, remote bool, name string, ext string) (string, error) {
+	source := location
+	if remote {
+		task, err := h.download(location)
+		if err != nil {
+			return "", err
+		}
+		if err = task.Wait(); err != nil {
+			return "", err
+		}
+		location = task.Path
+	}
+
+	if h.NoSubsetFonts {
+		return h.addFont(name, func(name string) (string, error) {
+			return h.book.AddFont(location, name)
+		})
+	}
+
+	data, err := os.ReadFile(location)
+	if err != nil {
+		return "", err
+	}
+	data = h.subsetFont(source, data)
+
+	return h.addFont(name, func(name string) (string, error) {
+		return h.book.AddFontBytes(data, name, fontExts[ext])
+	})
+}
+
+// subsetFont keeps only the glyphs of a font that the sections use, which
+// shrinks CJK fonts from megabytes to kilobytes. Fonts that cannot be subset,
+// such as woff and woff2 fonts, are returned as is.
+func (h *HtmlToEpub) subsetFont(source string, data []byte) []byte {
+	if h.NoSubsetFonts {
+		return data
+	}
+	if h.runes == nil {
+		// the nav, the TOC page and the cover show these too
+		texts := append(h.book.SectionTitles(), h.book.Title(), h.book.TocHeading())
+		h.runes = usedRunes(h.sections, texts...)
+	}
+
+	subset, err := subsetFont(data, h.runes)
+	if err != nil {
+		log.Printf("cannot subset font %s: %s, embedding it whole", source, err)
+		return data
+	}
+	if h.Verbose {
+		log.Printf("subset font %s from %d to %d bytes", source, len(data), len(subset))
+	}
+
+	return subset
+}
+
+// addFont adds a font under the given name, or a generated one if the name is
+// taken by a font of an appended book.
+func (h *HtmlToEpub) addFont(name string, add func(name string) (string, error)) (ref string, err error) {
+	ref, err = add(name)
+	var used *epub.FilenameAlreadyUsedError
+	if name != "" && errors.As(err, &used) {
+		ref, err = add("")
+	}
+	return
+}
+
+// usedRunes returns the characters of the sections and of the other texts of
+// the book, such as its title, along with printable ASCII for text added by
+// reading systems such as page numbers.
+func usedRunes(sections []section, texts ...string) map[rune]bool {
+	runes := make(map[rune]bool)
+	for r := rune(0x20); r < 0x7f; r++ {
+		runes[r] = true
+	}
+	for _, s := range sections {
+		// markup is ASCII, which is kept anyway
+		for _, r := range html.UnescapeString(s.title + s.content) {
+			runes[r] = true
+		}
+	}
+	for _, text := range texts {
+		for _, r := range text {
+			runes[r] = true
+		}
+	}
+	return runes
+}
+
+// resolveRef resolves a reference found in a stylesheet or page at base, a
+// file path or an URL, and reports whether the result is remote.
+func resolveRef(base string, ref string) (string, bool) {
+	if strings.HasPrefix(ref, "//") {
+		ref = "https:" + ref
+	}
+	if u, err := url.Parse(ref); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+		return ref, true
+	}
+	if b, err := url.Parse(base); err == nil && (b.Scheme == "http" || b.Scheme == "https") {
+		if u, err := b.Parse(ref); err == nil {
+			return u.String(), true
+		}
+	}
+	if u, err := url.Parse(ref); err == nil && u.Path != "" {
+		ref, _ = url.PathUnescape(u.Path)
+	}
+	return filepath.Join(filepath.Dir(base), ref), false
+}
+
+// cssString quotes s as a CSS string. Quotes and backslashes are escaped with a
+// backslash and control characters as hex escapes, which CSS ends with a space.
+func cssString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		case r < 0x20 || r == 0x7f:
+			fmt.Fprintf(&sb, "\\%x ", r)
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// fontStyle guesses the family, weight and style of a font from its filename,
+// e.g. NotoSerif-BoldItalic.ttf is NotoSerif, 700 and italic.
+func fontStyle(filename string) (family string, weight string, style string) {
+	family = strings.TrimSuffix(filename, filepath.Ext(filename))
+	variant := ""
+	if i := strings.LastIndexAny(family, "-_ "); i > 0 {
+		family, variant = family[:i], strings.ToLower(family[i+1:])
+	}
+
+	weight, style = "normal", "normal"
+	for _, w := range fontWeights {
+		if strings.Contains(variant, w.name) {
+			weight = w.weight
+			break
+		}
+	}
+	if strings.Contains(variant, "italic") || strings.Contains(variant, "oblique") {
+		style = "italic"
+	}
+
+	// the suffix is part of the name rather than a style, e.g. Noto-Serif
+	plain := variant == "regular" || variant == "normal" || variant == "book"
+	if weight == "normal" && style == "normal" && !plain {
+		family = strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+
+	return
+}
@@ -0,0 +1,196 @@
+package html2epub
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+func TestCSSString(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want string
+	}{
+		{"Noto Serif", `"Noto Serif"`},
+		{`My "Font"`, `"My \"Font\""`},
+		{`C:\fonts`, `"C:\\fonts"`},
+		{"a\nb\tc", `"a\a b\9 c"`},
+		{"宋体 é", `"宋体 é"`},
+		{"../fonts/a b.ttf", `"../fonts/a b.ttf"`},
+	} {
+		if got := cssString(tt.in); got != tt.want {
+			t.Errorf("cssString(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUsedRunes(t *testing.T) {
+	sections := []section{{title: "1. 序章", content: "<p>caf&eacute;</p>"}}
+	runes := usedRunes(sections, "书名", "目录")
+
+	for _, r := range "序章é书名目录 ~" {
+		if !runes[r] {
+			t.Errorf("%q missing", r)
+		}
+	}
+	if !runes['&'] || runes['\n'] {
+		t.Error("want printable ASCII only")
+	}
+}
+
+func TestFontStyle(t *testing.T) {
+	for _, tt := range []struct {
+		filename string
+		family   string
+		weight   string
+		style    string
+	}{
+		{"NotoSerif-BoldItalic.ttf", "NotoSerif", "700", "italic"},
+		{"NotoSerif-Regular.ttf", "NotoSerif", "normal", "normal"},
+		{"Lora_SemiBold.woff2", "Lora", "600", "normal"},
+		{"Inter ExtraLight.otf", "Inter", "200", "normal"},
+		{"SourceSans-LightOblique.woff", "SourceSans", "300", "italic"},
+		{"Roboto-Black.ttf", "Roboto", "900", "normal"},
+		{"Font-Italic.ttf", "Font", "normal", "italic"},
+		{"Font-Book.ttf", "Font", "normal", "normal"},
+		// the suffix is part of the name
+		{"Noto-Serif.ttf", "Noto-Serif", "normal", "normal"},
+		{"Merriweather.ttf", "Merriweather", "normal", "normal"},
+	} {
+		family, weight, style := fontStyle(tt.filename)
+		if family != tt.family || weight != tt.weight || style != tt.style {
+			t.Errorf("fontStyle(%q) = %q, %q, %q, want %q, %q, %q", tt.filename, family, weight, style, tt.family, tt.weight, tt.style)
+		}
+	}
+}
+
+func TestSourceFontFace(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/fonts/remote.ttf", "/font":
+			fmt.Fprint(w, "font")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for _, name := range []string{"fonts/Lora-Bold.woff2", "fonts/plain.woff", "css/near.ttf"} {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte("font"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := filepath.Join(dir, "page.html")
+	sheet := filepath.Join(dir, "css", "site.css")
+	data := "data:font/woff2;base64," + base64.StdEncoding.EncodeToString([]byte("font"))
+
+	h := new(HtmlToEpub)
+	h.NoSubsetFonts = true
+	h.ImagesDir = t.TempDir()
+	h.book = epub.NewEpub("Fonts")
+	h.tasks = make(map[string]*downloadTask)
+	h.dl = newDownloader(2, 2, time.Millisecond, 10*time.Second, 0)
+	defer h.dl.Close()
+
+	seen := make(map[string]bool)
+	for _, tt := range []struct {
+		name string
+		rule string
+		base string
+		want string // the rule, or the start of the error
+	}{
+		{
+			name: "relative to the stylesheet",
+			rule: `font-family: "Lora"; font-weight: bold; src: url("../fonts/Lora-Bold.woff2") format("woff2")`,
+			base: sheet,
+			want: "@font-face {\n  font-family: \"Lora\";\n  font-weight: bold;\n  src: url(\"../fonts/Lora-Bold.woff2\");\n}\n",
+		},
+		{
+			name: "first usable source",
+			rule: `font-family: Plain; src: url(../fonts/plain.eot?#iefix) format("embedded-opentype"), url('../fonts/plain.woff') format('woff')`,
+			base: sheet,
+			want: "@font-face {\n  font-family: Plain;\n  src: url(\"../fonts/plain.woff\");\n}\n",
+		},
+		{
+			name: "next to the stylesheet",
+			rule: `font-family: Near; src: url(near.ttf)`,
+			base: sheet,
+			want: "@font-face {\n  font-family: Near;\n  src: url(\"../fonts/near.ttf\");\n}\n",
+		},
+		{
+			name: "relative to a remote stylesheet",
+			rule: `font-family: Remote; src: url(../fonts/remote.ttf?v=2)`,
+			base: server.URL + "/css/site.css",
+			want: "@font-face {\n  font-family: Remote;\n  src: url(\"../fonts/remote.ttf\");\n}\n",
+		},
+		{
+			name: "extension from the format hint",
+			rule: `font-family: Hinted; src: url(` + server.URL + `/font) format("truetype")`,
+			base: sheet,
+			want: "@font-face {\n  font-family: Hinted;\n  src: url(\"../fonts/font.ttf\");\n}\n",
+		},
+		{
+			name: "data url",
+			rule: `font-family: Data; src: url(` + data + `)`,
+			base: sheet,
+			want: "@font-face {\n  font-family: Data;\n  src: url(\"../fonts/font.woff2\");\n}\n",
+		},
+		{
+			name: "font embedded already",
+			rule: `font-family: Again; src: url("../fonts/Lora-Bold.woff2")`,
+			base: sheet,
+			want: "",
+		},
+		{
+			name: "no usable source",
+			rule: `font-family: Old; src: url(old.eot)`,
+			base: sheet,
+			want: "no ttf, otf, woff or woff2 source",
+		},
+	} {
+		rule, err := h.sourceFontFace(page, tt.rule, tt.base, seen)
+		if err != nil {
+			if tt.want == "" || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("%s: %s", tt.name, err)
+			}
+			continue
+		}
+		if rule != tt.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", tt.name, rule, tt.want)
+		}
+	}
+}
+
+func TestFontFamilyRules(t *testing.T) {
+	css := `@charset "utf-8";
+@font-face { font-family: Lora; src: url(lora.woff2); }
+/* body { font-family: Commented; } */
+h1, .title { color: red; font-family: "Lora", serif }
+@media screen { p.note { font-family: Lora } }
+code { font: 12px monospace }
+`
+	want := []string{
+		"h1, .title {\n  font-family: \"Lora\", serif;\n}\n",
+		"p.note {\n  font-family: Lora;\n}\n",
+	}
+	got := fontFamilyRules(css)
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if families := fontFamilies(got[0]); strings.Join(families, ",") != "lora,serif" {
+		t.Errorf("families %q", families)
+	}
+}
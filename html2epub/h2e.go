@@ -9,11 +9,11 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gabriel-vasile/mimetype"
-	"github.com/gonejack/get"
 
 	"github.com/gonejack/html-to-epub/go-epub"
 )
@@ -22,40 +22,165 @@ type HtmlToEpub struct {
 	Options
 	DefaultCover []byte
 
-	book   *epub.Epub
-	imgIdx int
+	book     *epub.Epub
+	imgIdx   int
+	chapters int
+	fontCSS  string
+	pageCSS  string
+	pageIdx  int
+	sections []section
+	styles   []stylesheet
+	runes    map[rune]bool
+	dl       *downloader
+	tasks    map[string]*downloadTask
+	tasksMu  sync.Mutex // tasks is shared by parse and add
+
+	// local files of the embedded images, by internal path
+	imageFiles   map[string]string
+	placeholders map[string]bool
+
+	// internal paths of the placeholders, by alt text
+	placeholderRefs map[string]string
+
+	// kept images that stay on the web, listed in the manifest
+	remoteImages map[string]bool
+
+	failures []imageFailure
 }
 
+// page is an input file parsed ahead of being added to the book, with its
+// images already scheduled on the shared downloader.
+type page struct {
+	index     int
+	html      string
+	doc       *goquery.Document
+	downloads map[string]*downloadTask
+	embeds    []*embed
+	err       error
+
+	// stylesheets whose @font-face rules are embedded with --source-fonts
+	stylesheets []stylesheet
+}
+
+// section is a parsed page waiting for the fonts, which are subset to the
+// text of all sections, before it is added to the book. Fixed-layout pages
+// have the size of their image.
+type section struct {
+	title      string
+	content    string
+	width      int
+	height     int
+	properties []string
+}
+
+// parseAhead bounds how many parsed pages may wait for their images.
+const parseAhead = 16
+
 func (h *HtmlToEpub) Run() (err error) {
 	if h.About {
 		fmt.Println("Visit https://github.com/gonejack/html-to-epub")
 		return
 	}
+	if h.Command == "validate" {
+		return h.validate()
+	}
 	_, exx := os.Stat(h.Output)
-	if exx == nil {
+	if h.Append && exx != nil {
+		return fmt.Errorf("cannot append to output file %s: %s", h.Output, exx)
+	}
+	if !h.Append && exx == nil {
 		return fmt.Errorf("output file %s already exist", h.Output)
 	}
 	if len(h.HTML) == 0 {
 		return errors.New("no .html file given")
 	}
+	if h.SeriesIndex != "" && h.Series == "" {
+		return errors.New("--series-index given without --series")
+	}
+	if err = validateKeep(h.Keep); err != nil {
+		return fmt.Errorf("invalid --keep selector: %s", err)
+	}
 	return h.run()
 }
 func (h *HtmlToEpub) run() (err error) {
-	err = h.makeBook()
+	if h.Append {
+		err = h.openBook()
+	} else {
+		err = h.makeBook()
+	}
 	if err != nil {
 		return
 	}
 
-	refs := make(map[string]string)
-	for i, html := range h.HTML {
-		err = h.add(i+1, refs, html)
+	h.dl = newDownloader(h.Concurrency, h.HostConcurrency, h.HostInterval, h.Timeout, h.Retries)
+	defer func() {
+		// on error, don't wait for the images nobody will embed
 		if err != nil {
-			err = fmt.Errorf("parse %s failed: %s", html, err)
+			h.dl.Abort()
+		} else {
+			h.dl.Close()
+		}
+	}()
+	h.tasks = make(map[string]*downloadTask)
+	h.imageFiles = make(map[string]string)
+	h.placeholders = make(map[string]bool)
+	h.placeholderRefs = make(map[string]string)
+	h.remoteImages = make(map[string]bool)
+	defer func() {
+		// report the failed images even if the book is not written
+		if exx := h.writeFailureReport(); exx != nil && err == nil {
+			err = fmt.Errorf("cannot write failure report: %s", exx)
+		}
+	}()
+
+	// parse and schedule downloads ahead, add sections in input order
+	stop := make(chan struct{})
+	pages := make(chan *page, parseAhead)
+	defer func() {
+		// the producer may still be parsing a page, scheduling downloads and
+		// adding tasks; wait for it before the downloader is stopped
+		close(stop)
+		for range pages {
+		}
+	}()
+	go func() {
+		defer close(pages)
+		for i, html := range h.HTML {
+			select {
+			case pages <- h.parse(h.chapters+i+1, html):
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	refs := make(map[string]string)
+	for p := range pages {
+		if p.err == nil {
+			p.err = h.add(p, refs)
+		}
+		if p.err != nil {
+			err = fmt.Errorf("parse %s failed: %s", p.html, p.err)
 			return
 		}
 	}
 
-	err = h.book.Write(h.Output)
+	err = h.setFonts()
+	if err != nil {
+		return
+	}
+	for _, s := range h.sections {
+		if h.FixedLayout {
+			err = h.addPage(s)
+		} else {
+			_, err = h.book.AddSection(s.content, s.title, "", h.fontCSS, epub.ManifestProperties(s.properties...))
+		}
+		if err != nil {
+			return fmt.Errorf("cannot add section %s: %s", s.title, err)
+		}
+	}
+
+	err = h.writeBook()
 	if err != nil {
 		return fmt.Errorf("cannot write output epub: %s", err)
 	}
@@ -65,102 +190,219 @@ func (h *HtmlToEpub) run() (err error) {
 func (h *HtmlToEpub) makeBook() error {
 	h.book = epub.NewEpub(h.Title)
 	h.book.SetAuthor(h.Author)
-	h.book.SetDescription(fmt.Sprintf("Epub generated at %s with github.com/gonejack/html-to-epub", time.Now().Format("2006-01-02")))
-	return h.setCover()
+	generated := h.book.Modified()
+	if generated.IsZero() {
+		generated = time.Now()
+	}
+	h.book.SetDescription(fmt.Sprintf("Epub generated at %s with github.com/gonejack/html-to-epub", generated.Format("2006-01-02")))
+	h.setSeries()
+	if err := h.setToc(); err != nil {
+		return err
+	}
+	if err := h.setCover(); err != nil {
+		return err
+	}
+	return h.setLayout()
 }
-func (h *HtmlToEpub) setCover() (err error) {
-	if h.Cover == "" {
-		temp, err := os.CreateTemp("", "html-to-epub")
-		if err != nil {
-			return fmt.Errorf("cannot create tempfile: %s", err)
+func (h *HtmlToEpub) openBook() (err error) {
+	h.book, err = epub.Open(h.Output)
+	if err != nil {
+		return fmt.Errorf("cannot open output epub: %s", err)
+	}
+
+	h.setSeries()
+	if err = h.setToc(); err != nil {
+		return
+	}
+	if err = h.setLayout(); err != nil {
+		return
+	}
+
+	// continue chapter numbering after the chapters already in the book
+	for _, title := range h.book.SectionTitles() {
+		if title != "" {
+			h.chapters += 1
 		}
-		_, err = temp.Write(h.DefaultCover)
-		if err != nil {
-			return fmt.Errorf("cannot write tempfile: %s", err)
+	}
+
+	return
+}
+func (h *HtmlToEpub) setSeries() {
+	if h.Series != "" {
+		h.book.SetSeries(h.Series, h.SeriesIndex)
+	}
+}
+func (h *HtmlToEpub) setToc() error {
+	if h.TocHeading != "" {
+		h.book.SetTocHeading(h.TocHeading)
+	}
+	if h.TocPage && !h.book.TocPage() {
+		if err := h.book.SetTocPage(""); err != nil {
+			return fmt.Errorf("cannot add table of contents page: %s", err)
 		}
-		_ = temp.Close()
+	}
+	return nil
+}
+func (h *HtmlToEpub) writeBook() (err error) {
+	if !h.Append {
+		return h.book.Write(h.Output)
+	}
 
-		h.Cover = temp.Name()
+	// replace the existing book only once the new one is complete
+	tmp, err := os.CreateTemp(filepath.Dir(h.Output), "."+filepath.Base(h.Output)+".*")
+	if err != nil {
+		return
 	}
+	_ = tmp.Close()
+	defer os.Remove(tmp.Name())
 
-	m, err := mimetype.DetectFile(h.Cover)
+	err = h.book.Write(tmp.Name())
 	if err != nil {
-		return fmt.Errorf("cannot detect cover mime type %s", err)
+		return
+	}
+	if info, err := os.Stat(h.Output); err == nil {
+		_ = os.Chmod(tmp.Name(), info.Mode())
+	}
+
+	return os.Rename(tmp.Name(), h.Output)
+}
+func (h *HtmlToEpub) setCover() (err error) {
+	var coverRef string
+	if h.Cover == "" {
+		m := mimetype.Detect(h.DefaultCover)
+		coverRef, err = h.book.AddImageBytes(h.DefaultCover, "cover"+m.Extension(), m.String())
+		if err != nil {
+			return fmt.Errorf("cannot add cover %s", err)
+		}
+	} else {
+		m, err := mimetype.DetectFile(h.Cover)
+		if err != nil {
+			return fmt.Errorf("cannot detect cover mime type %s", err)
+		}
+		coverRef, err = h.book.AddImage(h.Cover, "cover"+m.Extension())
+		if err != nil {
+			return fmt.Errorf("cannot add cover %s", err)
+		}
 	}
-	coverRef, err := h.book.AddImage(h.Cover, "cover"+m.Extension())
+	err = h.book.SetCover(coverRef, "")
 	if err != nil {
-		return fmt.Errorf("cannot add cover %s", err)
+		return fmt.Errorf("cannot set cover %s", err)
 	}
-	h.book.SetCover(coverRef, "")
 
 	return
 }
-func (h *HtmlToEpub) add(index int, refs map[string]string, html string) (err error) {
+func (h *HtmlToEpub) parse(index int, html string) (p *page) {
+	p = &page{index: index, html: html}
+
+	// image inputs of fixed-layout books are shown as they are
+	if h.FixedLayout && isImage(html) {
+		p.doc = imageDoc(html)
+		p.downloads = h.saveImages(p.doc)
+		return
+	}
+
 	fd, err := os.Open(html)
 	if err != nil {
+		p.err = err
 		return
 	}
 	defer fd.Close()
 
-	doc, err := goquery.NewDocumentFromReader(fd)
-	if err != nil {
+	p.doc, p.err = goquery.NewDocumentFromReader(fd)
+	if p.err != nil {
 		return
 	}
-	doc = h.cleanDoc(doc)
+	if h.SourceFonts {
+		p.stylesheets = h.pageStylesheets(html, p.doc)
+	}
+	p.doc = h.cleanDoc(p.doc)
+	p.embeds = h.convertEmbeds(p.doc)
+	p.doc = h.sanitizeDoc(p.doc)
+	p.downloads = h.saveImages(p.doc)
 
-	images := h.saveImages(doc)
-	doc.Find("img").Each(func(i int, img *goquery.Selection) { h.changeRef(html, img, refs, images) })
+	return
+}
+func (h *HtmlToEpub) add(p *page, refs map[string]string) (err error) {
+	html, doc := p.html, p.doc
+
+	for _, t := range p.downloads {
+		_ = t.Wait()
+	}
+	h.resolveEmbeds(p)
+	h.styles = append(h.styles, p.stylesheets...)
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		err := h.changeRef(html, img, refs, p.downloads)
+		if err != nil {
+			h.imageFailed(html, img, err)
+		}
+	})
 
 	title := doc.Find("title").Text()
 	if title == "" {
 		title = strings.TrimSuffix(filepath.Base(html), filepath.Ext(html))
 	}
-	title = fmt.Sprintf("%d. %s", index, title)
+	title = fmt.Sprintf("%d. %s", p.index, title)
+
+	if h.FixedLayout {
+		h.addPages(html, title, doc)
+		return
+	}
 
 	content, err := doc.Find("body").Html()
 	if err != nil {
 		return
 	}
 
-	_, err = h.book.AddSection(content, title, "", "")
+	h.sections = append(h.sections, section{title: title, content: content, properties: manifestProperties(doc.Find("body"))})
 
 	return
 }
-func (h *HtmlToEpub) saveImages(doc *goquery.Document) map[string]string {
-	downloads := make(map[string]string)
+func (h *HtmlToEpub) saveImages(doc *goquery.Document) map[string]*downloadTask {
+	// schedule without waiting, a link is only downloaded once per run
+	downloads := make(map[string]*downloadTask)
 
-	tasks := get.NewDownloadTasks()
 	doc.Find("img").Each(func(i int, img *goquery.Selection) {
 		src, _ := img.Attr("src")
 		if !strings.HasPrefix(src, "http") {
 			return
 		}
 
-		localFile, exist := downloads[src]
+		_, exist := downloads[src]
 		if exist {
 			return
 		}
-
-		uri, err := url.Parse(src)
+		task, err := h.download(src)
 		if err != nil {
 			log.Printf("parse %s fail: %s", src, err)
 			return
 		}
-		_ = os.MkdirAll(h.ImagesDir, 0766)
-		localFile = filepath.Join(h.ImagesDir, fmt.Sprintf("%s%s", md5str(src), filepath.Ext(uri.Path)))
-
-		tasks.Add(src, localFile)
-		downloads[src] = localFile
-	})
-	get.Batch(tasks, 3, time.Minute*2).ForEach(func(t *get.DownloadTask) {
-		if t.Err != nil {
-			log.Printf("download %s fail: %s", t.Link, t.Err)
-		}
+		downloads[src] = task
 	})
 
 	return downloads
 }
-func (h *HtmlToEpub) changeRef(htmlFile string, img *goquery.Selection, refs, downloads map[string]string) {
+func (h *HtmlToEpub) download(link string) (*downloadTask, error) {
+	h.tasksMu.Lock()
+	defer h.tasksMu.Unlock()
+
+	task, exist := h.tasks[link]
+	if exist {
+		return task, nil
+	}
+
+	uri, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	_ = os.MkdirAll(h.ImagesDir, 0766)
+	localFile := filepath.Join(h.ImagesDir, fmt.Sprintf("%s%s", md5str(link), filepath.Ext(uri.Path)))
+
+	task = h.dl.Add(link, localFile)
+	h.tasks[link] = task
+
+	return task, nil
+}
+func (h *HtmlToEpub) changeRef(htmlFile string, img *goquery.Selection, refs map[string]string, downloads map[string]*downloadTask) error {
 	img.RemoveAttr("loading")
 	img.RemoveAttr("srcset")
 
@@ -169,24 +411,26 @@ func (h *HtmlToEpub) changeRef(htmlFile string, img *goquery.Selection, refs, do
 	internalRef, exist := refs[src]
 	if exist {
 		img.SetAttr("src", internalRef)
-		return
+		return nil
 	}
 
 	var localFile string
 	switch {
 	case strings.HasPrefix(src, "data:"):
-		return
+		return nil
 	case strings.HasPrefix(src, "http"):
-		localFile, exist = downloads[src]
+		task, exist := downloads[src]
 		if !exist {
-			log.Printf("local file of %s not exist", src)
-			return
+			return fmt.Errorf("local file of %s not exist", src)
+		}
+		if task.Err != nil {
+			return fmt.Errorf("download %s fail: %s", src, task.Err)
 		}
+		localFile = task.Path
 	default:
 		fd, err := h.openLocalFile(htmlFile, src)
 		if err != nil {
-			log.Printf("local ref %s not found: %s", src, err)
-			return
+			return fmt.Errorf("local ref %s not found: %s", src, err)
 		}
 		_ = fd.Close()
 		localFile = fd.Name()
@@ -196,35 +440,56 @@ func (h *HtmlToEpub) changeRef(htmlFile string, img *goquery.Selection, refs, do
 	fmime, err := mimetype.DetectFile(localFile)
 	{
 		if err != nil {
-			log.Printf("cannot detect image mime of %s: %s", src, err)
-			return
+			return fmt.Errorf("cannot detect image mime of %s: %s", src, err)
 		}
 		if !strings.HasPrefix(fmime.String(), "image") {
-			log.Printf("mime of %s is %s instead of images", src, fmime.String())
-			return
+			return fmt.Errorf("mime of %s is %s instead of images", src, fmime.String())
 		}
 	}
 
 	// add image
-	internalName := fmt.Sprintf("image_%03d", h.imgIdx)
-	{
-		h.imgIdx += 1
-		if !strings.HasSuffix(internalName, fmime.Extension()) {
-			internalName += fmime.Extension()
-		}
-		internalRef, err = h.book.AddImage(localFile, internalName)
-		if err != nil {
-			log.Printf("cannot add image %s: %s", localFile, err)
-			return
-		}
-		refs[src] = internalRef
+	internalRef, err = h.addImage(localFile, fmime.Extension())
+	if err != nil {
+		return fmt.Errorf("cannot add image %s: %s", localFile, err)
 	}
+	refs[src] = internalRef
+	h.imageFiles[internalRef] = localFile
 
 	if h.Verbose {
 		log.Printf("replace %s as %s", src, localFile)
 	}
 
 	img.SetAttr("src", internalRef)
+
+	return nil
+}
+func (h *HtmlToEpub) addImage(localFile string, ext string) (internalRef string, err error) {
+	return h.addUniqueImage(ext, func(name string) (string, error) {
+		return h.book.AddImage(localFile, name)
+	})
+}
+func (h *HtmlToEpub) addImageBytes(data []byte, ext string, mediaType string) (internalRef string, err error) {
+	return h.addUniqueImage(ext, func(name string) (string, error) {
+		return h.book.AddImageBytes(data, name, mediaType)
+	})
+}
+func (h *HtmlToEpub) addUniqueImage(ext string, add func(name string) (string, error)) (internalRef string, err error) {
+	// skip names taken by images of an appended book
+	for {
+		internalRef, err = add(h.imageName(ext))
+		var used *epub.FilenameAlreadyUsedError
+		if !errors.As(err, &used) {
+			return
+		}
+	}
+}
+func (h *HtmlToEpub) imageName(ext string) string {
+	internalName := fmt.Sprintf("image_%03d", h.imgIdx)
+	h.imgIdx += 1
+	if !strings.HasSuffix(internalName, ext) {
+		internalName += ext
+	}
+	return internalName
 }
 func (h *HtmlToEpub) openLocalFile(htmlFile string, ref string) (fd *os.File, err error) {
 	fd, err = os.Open(ref)
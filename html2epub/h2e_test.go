@@ -0,0 +1,28 @@
+package html2epub
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSeriesIndexWithoutSeries(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "page.html")
+	if err := os.WriteFile(page, []byte(`<html><body><p>Text</p></body></html>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := new(HtmlToEpub)
+	h.Output = filepath.Join(dir, "output.epub")
+	h.HTML = []string{page}
+	h.SeriesIndex = "2"
+
+	if err := h.Run(); err == nil || !strings.Contains(err.Error(), "--series") {
+		t.Errorf("got %v, want an error about --series", err)
+	}
+	if _, err := os.Stat(h.Output); err == nil {
+		t.Error("output written")
+	}
+}
@@ -0,0 +1,174 @@
+package html2epub
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gabriel-vasile/mimetype"
+
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+// pageCSS fills the viewport of a fixed-layout page with its image
+const pageCSS = `html, body {
+  margin: 0;
+  padding: 0;
+  width: 100%;
+  height: 100%;
+}
+img {
+  display: block;
+  width: 100%;
+  height: 100%;
+}
+`
+
+// setLayout makes the book fixed-layout with --fixed-layout. Appending to a
+// fixed-layout book always adds fixed-layout pages.
+func (h *HtmlToEpub) setLayout() (err error) {
+	if h.book.Layout() == epub.LayoutPrePaginated {
+		h.FixedLayout = true
+	}
+	if !h.FixedLayout {
+		return
+	}
+	if h.Append && h.book.Layout() != epub.LayoutPrePaginated {
+		return errors.New("cannot append fixed-layout pages to a reflowable epub")
+	}
+
+	// an appended book keeps its spread
+	h.book.SetLayout(epub.LayoutPrePaginated)
+	if !h.Append {
+		h.book.SetSpread(h.Spread)
+	}
+	h.Spread = h.book.Spread()
+
+	h.pageCSS, err = h.book.AddCSSBytes([]byte(pageCSS), "page.css", "")
+	var used *epub.FilenameAlreadyUsedError
+	if errors.As(err, &used) {
+		h.pageCSS, err = h.book.AddCSSBytes([]byte(pageCSS), "", "")
+	}
+	if err != nil {
+		return fmt.Errorf("cannot add page stylesheet %s", err)
+	}
+
+	// pages alternate between the sides of a spread, starting on the right
+	h.pageIdx = len(h.book.SectionTitles())
+
+	return
+}
+
+// addPages makes a fixed-layout page, sized to the image, of each image
+// embedded in a source page, placeholders of failed images included. The first
+// one is listed in the table of contents with the title of the source page.
+func (h *HtmlToEpub) addPages(file string, title string, doc *goquery.Document) {
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		src, _ := img.Attr("src")
+		width, height := placeholderWidth, placeholderHeight
+		if !h.placeholders[src] {
+			localFile, ok := h.imageFiles[src]
+			if !ok {
+				return
+			}
+			var err error
+			width, height, err = imageSize(localFile)
+			if err != nil {
+				log.Printf("%s: cannot read size of %s: %s", file, src, err)
+				return
+			}
+		}
+
+		alt, _ := img.Attr("alt")
+		content := fmt.Sprintf(`<img src="%s" alt="%s" />`, html.EscapeString(src), html.EscapeString(alt))
+		h.sections = append(h.sections, section{title: title, content: content, width: width, height: height})
+		title = ""
+	})
+	if title != "" {
+		log.Printf("%s: no image to make a fixed-layout page of", file)
+	}
+}
+
+// addPage adds a fixed-layout page with its viewport and side of the spread.
+func (h *HtmlToEpub) addPage(s section) error {
+	filename, err := h.book.AddSection(s.content, s.title, "", h.pageCSS)
+	if err != nil {
+		return err
+	}
+	err = h.book.SetSectionViewport(filename, s.width, s.height)
+	if err != nil {
+		return err
+	}
+
+	spread := epub.PageSpreadRight
+	if h.pageIdx%2 == 1 {
+		spread = epub.PageSpreadLeft
+	}
+	h.pageIdx += 1
+	if h.Spread == epub.SpreadNone {
+		return nil
+	}
+
+	return h.book.SetSectionPageSpread(filename, spread)
+}
+
+// imageDoc returns a source page showing an image input of a fixed-layout book.
+func imageDoc(file string) *goquery.Document {
+	doc, _ := goquery.NewDocumentFromReader(strings.NewReader("<html><head><title></title></head><body><img alt=\"\"></body></html>"))
+	doc.Find("title").SetText(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)))
+	doc.Find("img").SetAttr("src", file)
+	return doc
+}
+
+func isImage(file string) bool {
+	m, err := mimetype.DetectFile(file)
+	return err == nil && strings.HasPrefix(m.String(), "image")
+}
+
+// imageSize returns the size in pixels of a gif, jpeg, png or webp image.
+func imageSize(file string) (width int, height int, err error) {
+	fd, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer fd.Close()
+
+	config, _, err := image.DecodeConfig(fd)
+	if err == nil {
+		return config.Width, config.Height, nil
+	}
+
+	// webp has no decoder in the standard library, its header is enough
+	header := make([]byte, 30)
+	if n, _ := fd.ReadAt(header, 0); n < len(header) {
+		return
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WEBP" {
+		return
+	}
+	switch string(header[12:16]) {
+	case "VP8 ":
+		width = int(binary.LittleEndian.Uint16(header[26:]) & 0x3fff)
+		height = int(binary.LittleEndian.Uint16(header[28:]) & 0x3fff)
+	case "VP8L":
+		bits := binary.LittleEndian.Uint32(header[21:])
+		width = int(bits&0x3fff) + 1
+		height = int(bits>>14&0x3fff) + 1
+	case "VP8X":
+		width = (int(header[24]) | int(header[25])<<8 | int(header[26])<<16) + 1
+		height = (int(header[27]) | int(header[28])<<8 | int(header[29])<<16) + 1
+	default:
+		return
+	}
+	return width, height, nil
+}
@@ -0,0 +1,142 @@
+package html2epub
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+var itemrefRe = regexp.MustCompile(`<itemref idref="[^"]*"(?: properties="([^"]*)")?>`)
+
+// webpHeader returns the start of a webp file with a chunk of the given type
+// holding data.
+func webpHeader(chunk string, data ...byte) []byte {
+	b := []byte("RIFF\x00\x00\x00\x00WEBP" + chunk + "\x00\x00\x00\x00")
+	return append(b, data...)
+}
+
+func TestImageSize(t *testing.T) {
+	var pngData bytes.Buffer
+	if err := png.Encode(&pngData, image.NewGray(image.Rect(0, 0, 640, 480))); err != nil {
+		t.Fatal(err)
+	}
+	vp8 := webpHeader("VP8 ", 0, 0, 0, 0x9d, 0x01, 0x2a)
+	vp8 = binary.LittleEndian.AppendUint16(vp8, 1024|2<<14) // the scale bits are not part of the width
+	vp8 = binary.LittleEndian.AppendUint16(vp8, 768)
+	vp8l := webpHeader("VP8L", 0x2f)
+	vp8l = binary.LittleEndian.AppendUint32(vp8l, (300-1)|(200-1)<<14)
+	vp8l = append(vp8l, 0, 0, 0, 0, 0)
+	vp8x := webpHeader("VP8X", 0, 0, 0, 0)
+	vp8x = append(vp8x, 0x7f, 0x38, 0x01) // 80000 - 1
+	vp8x = append(vp8x, 0x3f, 0x42, 0x0f) // 1000000 - 1
+
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name          string
+		data          []byte
+		width, height int
+	}{
+		{"png", pngData.Bytes(), 640, 480},
+		{"vp8", vp8, 1024, 768},
+		{"vp8l", vp8l, 300, 200},
+		{"vp8x", vp8x, 80000, 1000000},
+		{"truncated vp8", vp8[:26], 0, 0},
+		{"truncated vp8x", vp8x[:28], 0, 0},
+		{"truncated png", pngData.Bytes()[:20], 0, 0},
+		{"unknown webp chunk", webpHeader("ALPH", make([]byte, 10)...), 0, 0},
+		{"text", []byte(strings.Repeat("not an image ", 4)), 0, 0},
+	} {
+		file := filepath.Join(dir, tt.name)
+		if err := os.WriteFile(file, tt.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		width, height, err := imageSize(file)
+		if tt.width == 0 {
+			if err == nil {
+				t.Errorf("%s: got %dx%d, want an error", tt.name, width, height)
+			}
+			continue
+		}
+		if err != nil || width != tt.width || height != tt.height {
+			t.Errorf("%s: got %dx%d, %v, want %dx%d", tt.name, width, height, err, tt.width, tt.height)
+		}
+	}
+}
+
+func TestAddPage(t *testing.T) {
+	for _, tt := range []struct {
+		spread string
+		want   []string
+	}{
+		{epub.SpreadAuto, []string{"page-spread-right", "page-spread-left", "page-spread-right"}},
+		{epub.SpreadNone, []string{"", "", ""}},
+	} {
+		h := new(HtmlToEpub)
+		h.book = epub.NewEpub("Pages")
+		h.FixedLayout = true
+		h.Spread = tt.spread
+		if err := h.setLayout(); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 3; i++ {
+			if err := h.addPage(section{content: `<img src="../images/p.png" alt="" />`, width: 600, height: 800}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		opf := string(readZipFile(t, h.book, "EPUB/package.opf"))
+		var got []string
+		for _, m := range itemrefRe.FindAllStringSubmatch(opf, -1) {
+			got = append(got, m[1])
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: page spreads %q, want %q", tt.spread, got, tt.want)
+		}
+		if !strings.Contains(opf, `<meta property="rendition:layout">pre-paginated</meta>`) {
+			t.Errorf("%s: not pre-paginated:\n%s", tt.spread, opf)
+		}
+		page := string(readZipFile(t, h.book, "EPUB/xhtml/section0001.xhtml"))
+		if !strings.Contains(page, `content="width=600, height=800"`) || !strings.Contains(page, "page.css") {
+			t.Errorf("%s: page without viewport or stylesheet:\n%s", tt.spread, page)
+		}
+	}
+}
+
+// readZipFile writes the book and returns one of its files.
+func readZipFile(t *testing.T, book *epub.Epub, name string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if _, err := book.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	z, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range z.File {
+		if f.Name == name {
+			r, err := f.Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return data
+		}
+	}
+	t.Fatalf("no file %s", name)
+	return nil
+}
@@ -2,6 +2,8 @@ package html2epub
 
 import (
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/kong"
 )
@@ -11,20 +13,62 @@ type Options struct {
 	Title   string `default:"HTML" help:"Set epub title."`
 	Author  string `default:"HTML to Epub" help:"Set epub author."`
 	Output  string `short:"o" default:"output.epub" help:"Output filename."`
+	Append  bool   `short:"a" help:"Append .html files to the existing output epub."`
 	Verbose bool   `short:"v" help:"Verbose printing."`
 	About   bool   `help:"About."`
 
+	Series      string `help:"Set series the epub belongs to."`
+	SeriesIndex string `placeholder:"N" help:"Set position of the epub in its series."`
+
+	FixedLayout bool   `help:"Make a fixed-layout page of each image, for comics and scans; images may be given instead of .html files."`
+	Spread      string `enum:"auto,none,landscape,both" default:"auto" help:"Show fixed-layout pages side by side (auto,none,landscape,both)."`
+
+	TocPage    bool   `help:"Add a table of contents page after the cover."`
+	TocHeading string `placeholder:"TEXT" help:"Set heading of the table of contents."`
+
+	Font           []string `placeholder:"FILE" help:"Embed font files (ttf,otf,woff,woff2) as the text font."`
+	FontFamily     string   `placeholder:"NAME" help:"Font family of the --font files, guessed from the first filename by default."`
+	SourceFonts    bool     `help:"Also embed @font-face fonts of source pages along with the font-family rules using them."`
+	ObfuscateFonts bool     `help:"Obfuscate embedded fonts, as font licenses often require."`
+	NoSubsetFonts  bool     `help:"Embed whole fonts instead of only the glyphs used by the book."`
+
+	Concurrency     int           `default:"6" help:"Max parallel downloads of images, fonts and stylesheets."`
+	HostConcurrency int           `default:"2" help:"Max parallel downloads per host."`
+	HostInterval    time.Duration `default:"500ms" help:"Min delay between requests to the same host."`
+	Retries         int           `default:"3" help:"Retries of a failed download."`
+	Timeout         time.Duration `default:"2m" help:"Timeout of a single download."`
+
+	OnImageFailure string `enum:"keep,remove,placeholder" default:"keep" help:"What to do with images that cannot be embedded (keep,remove,placeholder)."`
+	FailureReport  string `help:"Write images that cannot be embedded to this JSON file."`
+
+	NoEmbeds   bool     `help:"Do not convert video and social media embeds to static previews."`
+	NoSanitize bool     `help:"Keep scripts, trackers, forms and iframes of source pages."`
+	Keep       []string `placeholder:"SELECTOR" help:"CSS selectors of elements to spare from sanitizing."`
+
 	ImagesDir string `hidden:"" default:"images"`
 
-	HTML []string `arg:"" optional:""`
+	Convert struct {
+		HTML []string `arg:"" optional:""`
+	} `cmd:"" default:"withargs" help:"Convert .html files to epub (default)."`
+	Validate struct {
+		EPUB []string `arg:"" name:"epub" help:"Epub files to validate."`
+	} `cmd:"" help:"Check epub files for structural problems."`
+
+	HTML    []string `kong:"-"`
+	Command string   `kong:"-"`
 }
 
 func MustParseOptions() (opts Options) {
-	kong.Parse(&opts,
+	ctx := kong.Parse(&opts,
 		kong.Name("html-to-epub"),
 		kong.Description("This command line converts .html to .epub with images embed"),
 		kong.UsageOnError(),
 	)
+	opts.Command = strings.Fields(ctx.Command())[0]
+	if opts.Command == "validate" {
+		return
+	}
+	opts.HTML = opts.Convert.HTML
 	if len(opts.HTML) == 0 || opts.HTML[0] == "*.html" {
 		opts.HTML, _ = filepath.Glob("*.html")
 	}
@@ -0,0 +1,192 @@
+package html2epub
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+// Elements that have no place in an EPUB content document.
+var unsafeSelectors = []string{
+	"script",
+	"iframe", "frame", "frameset",
+	"object", "embed", "applet",
+	"form", "input", "button", "select", "textarea",
+}
+
+// Hosts serving analytics beacons and tracking pixels.
+var trackerHosts = []string{
+	"google-analytics.com",
+	"googletagmanager.com",
+	"doubleclick.net",
+	"facebook.com/tr",
+	"feeds.feedburner.com/~r",
+	"feedburner.google.com/~r",
+	"pixel.wp.com",
+	"stats.wp.com",
+	"quantserve.com",
+	"scorecardresearch.com",
+	"pixel.quantserve.com",
+	"analytics.twitter.com",
+	"pixel.mathtag.com",
+	"mc.yandex.ru",
+	"hm.baidu.com",
+}
+
+// Elements that load what they show, by the attribute holding its url.
+var resourceAttrs = map[string][]string{
+	"img":    {"src"},
+	"iframe": {"src"},
+	"frame":  {"src"},
+	"video":  {"src", "poster"},
+	"audio":  {"src"},
+	"source": {"src"},
+	"track":  {"src"},
+	"embed":  {"src"},
+	"object": {"data"},
+	"script": {"src"},
+	"input":  {"src"},
+}
+
+var styleSizeRe = regexp.MustCompile(`(?i)(?:^|;)\s*(width|height)\s*:\s*([0-9.]+)px`)
+
+// validateKeep checks the allowlist selectors given on the command line.
+func validateKeep(selectors []string) error {
+	for _, s := range selectors {
+		_, err := cascadia.Compile(s)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sanitizeDoc removes scripts, event handlers, tracking pixels, forms and
+// frames, sparing elements matched by the --keep allowlist.
+func (h *HtmlToEpub) sanitizeDoc(doc *goquery.Document) *goquery.Document {
+	if h.NoSanitize {
+		return doc
+	}
+
+	keep := strings.Join(h.Keep, ",")
+	kept := func(s *goquery.Selection) bool {
+		return keep != "" && s.Closest(keep).Length() > 0
+	}
+
+	doc.Find(strings.Join(unsafeSelectors, ",")).Each(func(i int, s *goquery.Selection) {
+		if !kept(s) {
+			s.Remove()
+		}
+	})
+	doc.Find("img").Each(func(i int, img *goquery.Selection) {
+		if isTracker(img) && !kept(img) {
+			img.Remove()
+		}
+	})
+	doc.Find("*").Each(func(i int, s *goquery.Selection) {
+		if !kept(s) {
+			removeHandlers(s.Nodes[0])
+		}
+	})
+
+	return doc
+}
+
+// manifestProperties returns the manifest properties a section needs for the
+// scripts and remote resources left in it by --no-sanitize, --keep or
+// --on-image-failure=keep.
+func manifestProperties(body *goquery.Selection) (properties []string) {
+	scripted, remote := body.Find("script,form").Length() > 0, false
+	body.Find("*").Each(func(i int, s *goquery.Selection) {
+		n := s.Nodes[0]
+		for _, a := range n.Attr {
+			key := strings.ToLower(a.Key)
+			if strings.HasPrefix(key, "on") || isScriptURL(key, a.Val) {
+				scripted = true
+			}
+		}
+		for _, attr := range resourceAttrs[n.Data] {
+			if isRemote(s.AttrOr(attr, "")) {
+				remote = true
+			}
+		}
+	})
+	if scripted {
+		properties = append(properties, epub.ManifestScripted)
+	}
+	if remote {
+		properties = append(properties, epub.ManifestRemoteResources)
+	}
+	return
+}
+
+func isScriptURL(key string, val string) bool {
+	return (key == "href" || key == "src" || key == "action") && strings.HasPrefix(strings.ToLower(strings.TrimSpace(val)), "javascript:")
+}
+
+func isRemote(ref string) bool {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	return strings.HasPrefix(ref, "http:") || strings.HasPrefix(ref, "https:") || strings.HasPrefix(ref, "//")
+}
+
+// removeHandlers drops on* event attributes and javascript: URLs.
+func removeHandlers(n *html.Node) {
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if strings.HasPrefix(key, "on") {
+			continue
+		}
+		if isScriptURL(key, a.Val) {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+}
+
+func isTracker(img *goquery.Selection) bool {
+	src, _ := img.Attr("src")
+	if u, err := url.Parse(src); err == nil && u.Host != "" {
+		link := strings.TrimPrefix(u.Host, "www.") + u.Path
+		for _, t := range trackerHosts {
+			if strings.HasPrefix(link, t) || strings.Contains(link, "."+t) {
+				return true
+			}
+		}
+	}
+
+	width, height := -1.0, -1.0
+	if w, exist := img.Attr("width"); exist {
+		width = parseSize(w)
+	}
+	if h, exist := img.Attr("height"); exist {
+		height = parseSize(h)
+	}
+	style, _ := img.Attr("style")
+	for _, m := range styleSizeRe.FindAllStringSubmatch(style, -1) {
+		v, _ := strconv.ParseFloat(m[2], 64)
+		if strings.ToLower(m[1]) == "width" {
+			width = v
+		} else {
+			height = v
+		}
+	}
+
+	return width >= 0 && width <= 1 && height >= 0 && height <= 1
+}
+
+func parseSize(s string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "px"), 64)
+	if err != nil {
+		return -1
+	}
+	return v
+}
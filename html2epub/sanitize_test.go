@@ -0,0 +1,138 @@
+package html2epub
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+const sanitizeTestPage = `<p onclick="track()" class="text">Text <a href="javascript:void(0)" title="link">link</a> <a href=" JavaScript:alert(1)">other</a></p>
+<script>alert(1)</script>
+<form action="/search"><input name="q"/><button>Go</button></form>
+<iframe src="https://example.com/frame"></iframe>
+<object data="movie.swf"></object>
+<img src="photo.jpg" alt="photo" onload="track()" onerror="track()"/>
+<img src="https://www.google-analytics.com/collect?v=1" alt=""/>
+<div class="interactive" onclick="toggle()"><script src="widget.js"></script><form><input type="checkbox"/></form></div>`
+
+func TestSanitizeDoc(t *testing.T) {
+	h := new(HtmlToEpub)
+	doc := h.sanitizeDoc(parseHTML(sanitizeTestPage))
+
+	if n := doc.Find("script, form, input, button, iframe, object").Length(); n > 0 {
+		t.Errorf("%d unsafe elements left:\n%s", n, htmlOf(doc))
+	}
+	if n := doc.Find("[onclick], [onload], [onerror]").Length(); n > 0 {
+		t.Errorf("%d event handlers left:\n%s", n, htmlOf(doc))
+	}
+	doc.Find("a").Each(func(i int, a *goquery.Selection) {
+		if _, exist := a.Attr("href"); exist {
+			t.Errorf("javascript: link left: %s", a.AttrOr("href", ""))
+		}
+	})
+	// the rest is untouched
+	if title := doc.Find("a").First().AttrOr("title", ""); title != "link" {
+		t.Errorf("title %q", title)
+	}
+	if class := doc.Find("p").AttrOr("class", ""); class != "text" {
+		t.Errorf("class %q", class)
+	}
+	if imgs := doc.Find("img"); imgs.Length() != 1 || imgs.AttrOr("src", "") != "photo.jpg" {
+		t.Errorf("images:\n%s", htmlOf(doc))
+	}
+	if props := manifestProperties(doc.Find("body")); len(props) != 0 {
+		t.Errorf("properties %q of a sanitized page", props)
+	}
+}
+
+func TestSanitizeKeep(t *testing.T) {
+	h := new(HtmlToEpub)
+	h.Keep = []string{".interactive", `img[src*="google-analytics"]`}
+	doc := h.sanitizeDoc(parseHTML(sanitizeTestPage))
+
+	kept := doc.Find("div.interactive")
+	if kept.AttrOr("onclick", "") != "toggle()" || kept.Find("script").Length() != 1 || kept.Find("form input").Length() != 1 {
+		t.Errorf("allowlisted element not kept:\n%s", htmlOf(doc))
+	}
+	if doc.Find(`img[src*="google-analytics"]`).Length() != 1 {
+		t.Errorf("allowlisted tracker removed:\n%s", htmlOf(doc))
+	}
+	// outside the allowlist, everything is sanitized
+	if n := doc.Find("script, form").Length(); n != 2 {
+		t.Errorf("%d scripts and forms, want only the kept ones:\n%s", n, htmlOf(doc))
+	}
+	if doc.Find("p[onclick], iframe, object").Length() > 0 {
+		t.Errorf("element outside the allowlist kept:\n%s", htmlOf(doc))
+	}
+
+	if err := validateKeep(h.Keep); err != nil {
+		t.Errorf("validateKeep(%q) = %s", h.Keep, err)
+	}
+	if err := validateKeep([]string{".ok", "div["}); err == nil {
+		t.Error("validateKeep accepted an invalid selector")
+	}
+}
+
+func TestNoSanitize(t *testing.T) {
+	h := new(HtmlToEpub)
+	h.NoSanitize = true
+	doc := h.sanitizeDoc(parseHTML(sanitizeTestPage))
+
+	if doc.Find("script").Length() != 2 || doc.Find("[onclick]").Length() != 2 || doc.Find("img").Length() != 2 {
+		t.Errorf("page sanitized with --no-sanitize:\n%s", htmlOf(doc))
+	}
+}
+
+func TestIsTracker(t *testing.T) {
+	for _, tt := range []struct {
+		img     string
+		tracker bool
+	}{
+		{`<img src="https://www.google-analytics.com/collect?v=1"/>`, true},
+		{`<img src="https://ssl.google-analytics.com/__utm.gif"/>`, true},
+		{`<img src="https://www.facebook.com/tr?id=1&ev=PageView"/>`, true},
+		{`<img src="https://pixel.wp.com/g.gif"/>`, true},
+		{`<img src="https://example.com/pixel.gif" width="1" height="1"/>`, true},
+		{`<img src="https://example.com/pixel.gif" width="0" height="0"/>`, true},
+		{`<img src="https://example.com/pixel.gif" width="1px" height="1px"/>`, true},
+		{`<img src="https://example.com/pixel.gif" style="width: 1px; height:1px"/>`, true},
+		{`<img src="https://example.com/pixel.gif" width="600" style="WIDTH:1px;height:1px"/>`, true},
+		{`<img src="https://www.facebook.com/photo.jpg"/>`, false},
+		{`<img src="https://notgoogle-analytics.com/photo.jpg"/>`, false},
+		{`<img src="https://example.com/photo.jpg" width="1"/>`, false},
+		{`<img src="https://example.com/photo.jpg" width="1" height="300"/>`, false},
+		{`<img src="https://example.com/photo.jpg" width="auto" height="1"/>`, false},
+		{`<img src="https://example.com/photo.jpg"/>`, false},
+		{`<img src="/collect.gif"/>`, false},
+	} {
+		if got := isTracker(parseHTML(tt.img).Find("img")); got != tt.tracker {
+			t.Errorf("isTracker(%s) = %t, want %t", tt.img, got, tt.tracker)
+		}
+	}
+}
+
+func TestManifestProperties(t *testing.T) {
+	for _, tt := range []struct {
+		body string
+		want []string
+	}{
+		{`<p>Text <img src="../images/image_000.png"/></p>`, nil},
+		{`<p>Text</p><script>alert(1)</script>`, []string{epub.ManifestScripted}},
+		{`<form></form>`, []string{epub.ManifestScripted}},
+		{`<p onClick="track()">Text</p>`, []string{epub.ManifestScripted}},
+		{`<a href="javascript:void(0)">link</a>`, []string{epub.ManifestScripted}},
+		{`<a href="https://example.com/">link</a>`, nil},
+		{`<img src="https://example.com/photo.jpg"/>`, []string{epub.ManifestRemoteResources}},
+		{`<img src="//example.com/photo.jpg"/>`, []string{epub.ManifestRemoteResources}},
+		{`<video poster="https://example.com/poster.jpg"></video>`, []string{epub.ManifestRemoteResources}},
+		{`<object data="http://example.com/movie.swf"></object>`, []string{epub.ManifestRemoteResources}},
+		{`<p>Text</p><script src="https://example.com/widget.js"></script>`, []string{epub.ManifestScripted, epub.ManifestRemoteResources}},
+	} {
+		got := manifestProperties(parseHTML(tt.body).Find("body"))
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("manifestProperties(%s) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
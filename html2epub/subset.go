@@ -0,0 +1,811 @@
+package html2epub
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+)
+
+// subsetFont shrinks a TrueType or OpenType font to the glyphs needed to show
+// the given characters. Glyph ids are kept as they are, so that every table
+// indexed by glyph id stays valid, but the outlines of all other glyphs are
+// dropped; outlines make up nearly all of the size of a CJK font.
+//
+// Glyphs reachable from the needed ones through composite glyphs and GSUB
+// substitutions (ligatures, vertical or localized forms, ...) are kept too.
+func subsetFont(data []byte, used map[rune]bool) ([]byte, error) {
+	version, tables, err := parseSfnt(data)
+	if err != nil {
+		return nil, err
+	}
+
+	head, maxp, cmap := tables["head"], tables["maxp"], tables["cmap"]
+	if len(head) < 54 || len(maxp) < 6 || cmap == nil {
+		return nil, errors.New("missing head, maxp or cmap table")
+	}
+	numGlyphs := int(fontData(maxp).u16(4))
+
+	keep := make([]bool, numGlyphs)
+	keep[0] = true // .notdef
+	glyphs, err := cmapGlyphs(cmap, used)
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range glyphs {
+		if int(g) < numGlyphs {
+			keep[g] = true
+		}
+	}
+
+	switch {
+	case tables["glyf"] != nil && tables["loca"] != nil:
+		loca, err := parseLoca(tables["loca"], fontData(head).u16(50) == 1, numGlyphs, len(tables["glyf"]))
+		if err != nil {
+			return nil, err
+		}
+		// Composite glyphs may use glyphs added by GSUB and the other way round
+		for changed := true; changed; {
+			changed = gsubClosure(tables["GSUB"], keep)
+			changed = glyfClosure(tables["glyf"], loca, keep) || changed
+		}
+		head = append([]byte(nil), head...)
+		tables["glyf"], tables["loca"] = subsetGlyf(tables["glyf"], loca, keep)
+		// The new loca table is always in the long format
+		binary.BigEndian.PutUint16(head[50:], 1)
+		tables["head"] = head
+	case tables["CFF "] != nil:
+		for gsubClosure(tables["GSUB"], keep) {
+		}
+		tables["CFF "], err = subsetCFF(tables["CFF "], keep)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("no TrueType or CFF outlines")
+	}
+
+	// A signature would no longer match
+	delete(tables, "DSIG")
+
+	return writeSfnt(version, tables), nil
+}
+
+// fontData reads big-endian values from font tables, yielding zero beyond the
+// end of the data so that malformed fonts cannot cause a panic.
+type fontData []byte
+
+func (d fontData) u8(off int) int {
+	if off < 0 || off >= len(d) {
+		return 0
+	}
+	return int(d[off])
+}
+
+func (d fontData) u16(off int) uint16 {
+	if off < 0 || off+2 > len(d) {
+		return 0
+	}
+	return binary.BigEndian.Uint16(d[off:])
+}
+
+func (d fontData) u32(off int) uint32 {
+	if off < 0 || off+4 > len(d) {
+		return 0
+	}
+	return binary.BigEndian.Uint32(d[off:])
+}
+
+func (d fontData) slice(off int, n int) fontData {
+	if off < 0 || n < 0 || off+n > len(d) {
+		return nil
+	}
+	return d[off : off+n]
+}
+
+// parseSfnt returns the version and the tables of a font, by tag.
+func parseSfnt(data []byte) (uint32, map[string][]byte, error) {
+	d := fontData(data)
+	version := d.u32(0)
+	switch version {
+	case 0x00010000, 0x4F54544F, 0x74727565: // TrueType, OTTO, true
+	case 0x74746366:
+		return 0, nil, errors.New("font collections are not supported")
+	default:
+		return 0, nil, errors.New("not a TrueType or OpenType font")
+	}
+
+	numTables := int(d.u16(4))
+	tables := make(map[string][]byte, numTables)
+	for i := 0; i < numTables; i++ {
+		record := d.slice(12+16*i, 16)
+		if record == nil {
+			return 0, nil, errors.New("truncated table directory")
+		}
+		table := d.slice(int(record.u32(8)), int(record.u32(12)))
+		if table == nil {
+			return 0, nil, errors.New("table " + string(record[:4]) + " out of bounds")
+		}
+		tables[string(record[:4])] = table
+	}
+
+	return version, tables, nil
+}
+
+// writeSfnt assembles a font from its tables and sets the checksums.
+func writeSfnt(version uint32, tables map[string][]byte) []byte {
+	tags := make([]string, 0, len(tables))
+	for tag := range tables {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	n := len(tags)
+	entrySelector := bits.Len(uint(n)) - 1
+	searchRange := (1 << entrySelector) * 16
+
+	out := make([]byte, 12+16*n)
+	binary.BigEndian.PutUint32(out[0:], version)
+	binary.BigEndian.PutUint16(out[4:], uint16(n))
+	binary.BigEndian.PutUint16(out[6:], uint16(searchRange))
+	binary.BigEndian.PutUint16(out[8:], uint16(entrySelector))
+	binary.BigEndian.PutUint16(out[10:], uint16(n*16-searchRange))
+
+	headOffset := -1
+	for i, tag := range tags {
+		table := tables[tag]
+		if tag == "head" {
+			// The adjustment is computed over the whole font below
+			table = append([]byte(nil), table...)
+			binary.BigEndian.PutUint32(table[8:], 0)
+			headOffset = len(out)
+		}
+		record := out[12+16*i:]
+		copy(record, tag)
+		binary.BigEndian.PutUint32(record[4:], sfntChecksum(table))
+		binary.BigEndian.PutUint32(record[8:], uint32(len(out)))
+		binary.BigEndian.PutUint32(record[12:], uint32(len(table)))
+		out = append(out, table...)
+		for len(out)%4 != 0 {
+			out = append(out, 0)
+		}
+	}
+	if headOffset >= 0 {
+		binary.BigEndian.PutUint32(out[headOffset+8:], 0xB1B0AFBA-sfntChecksum(out))
+	}
+
+	return out
+}
+
+func sfntChecksum(data []byte) uint32 {
+	var sum uint32
+	for i := 0; i < len(data); i += 4 {
+		var word [4]byte
+		copy(word[:], data[i:])
+		sum += binary.BigEndian.Uint32(word[:])
+	}
+	return sum
+}
+
+// cmapGlyphs returns the glyphs of the given characters, using the best
+// Unicode subtable of the cmap table.
+func cmapGlyphs(cmap []byte, used map[rune]bool) ([]uint16, error) {
+	d := fontData(cmap)
+	var format4, format12 fontData
+	for i := 0; i < int(d.u16(2)); i++ {
+		platform, encoding := d.u16(4+8*i), d.u16(6+8*i)
+		off := int(d.u32(8 + 8*i))
+		sub := d.slice(off, len(d)-off)
+		unicode := platform == 0 || (platform == 3 && (encoding == 1 || encoding == 10))
+		if !unicode {
+			continue
+		}
+		switch sub.u16(0) {
+		case 4:
+			format4 = sub
+		case 12:
+			format12 = sub
+		}
+	}
+
+	var glyphs []uint16
+	switch {
+	case format12 != nil:
+		nGroups := int(format12.u32(12))
+		for r := range used {
+			for i := 0; i < nGroups; i++ {
+				group := 16 + 12*i
+				start, end := rune(format12.u32(group)), rune(format12.u32(group+4))
+				if start <= r && r <= end {
+					glyphs = append(glyphs, uint16(format12.u32(group+8)+uint32(r-start)))
+					break
+				}
+			}
+		}
+	case format4 != nil:
+		segCount := int(format4.u16(6)) / 2
+		endCodes := 14
+		startCodes := endCodes + 2*segCount + 2
+		idDeltas := startCodes + 2*segCount
+		idRangeOffsets := idDeltas + 2*segCount
+		for r := range used {
+			if r > 0xFFFF {
+				continue
+			}
+			for i := 0; i < segCount; i++ {
+				if rune(format4.u16(endCodes+2*i)) < r {
+					continue
+				}
+				start := rune(format4.u16(startCodes + 2*i))
+				if start > r {
+					break
+				}
+				delta := format4.u16(idDeltas + 2*i)
+				rangeOffset := int(format4.u16(idRangeOffsets + 2*i))
+				if rangeOffset == 0 {
+					glyphs = append(glyphs, uint16(r)+delta)
+				} else if g := format4.u16(idRangeOffsets + 2*i + rangeOffset + 2*int(r-start)); g != 0 {
+					glyphs = append(glyphs, g+delta)
+				}
+				break
+			}
+		}
+	default:
+		return nil, errors.New("no Unicode cmap subtable")
+	}
+
+	return glyphs, nil
+}
+
+// parseLoca returns the offsets of the glyphs in the glyf table.
+func parseLoca(loca []byte, long bool, numGlyphs int, glyfSize int) ([]int, error) {
+	d := fontData(loca)
+	offsets := make([]int, numGlyphs+1)
+	for i := range offsets {
+		if long {
+			offsets[i] = int(d.u32(4 * i))
+		} else {
+			offsets[i] = 2 * int(d.u16(2*i))
+		}
+		if offsets[i] > glyfSize || (i > 0 && offsets[i] < offsets[i-1]) {
+			return nil, errors.New("invalid loca table")
+		}
+	}
+	return offsets, nil
+}
+
+// glyfClosure keeps the components of kept composite glyphs and reports
+// whether glyphs were added.
+func glyfClosure(glyf []byte, loca []int, keep []bool) (changed bool) {
+	for g := range keep {
+		if !keep[g] {
+			continue
+		}
+		glyph := fontData(glyf[loca[g]:loca[g+1]])
+		if len(glyph) < 10 || int16(glyph.u16(0)) >= 0 {
+			continue
+		}
+		for off := 10; off+4 <= len(glyph); {
+			flags, component := glyph.u16(off), int(glyph.u16(off+2))
+			if component < len(keep) && !keep[component] {
+				keep[component] = true
+				changed = true
+			}
+			off += 4
+			if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+				off += 4
+			} else {
+				off += 2
+			}
+			switch {
+			case flags&0x0008 != 0: // WE_HAVE_A_SCALE
+				off += 2
+			case flags&0x0040 != 0: // WE_HAVE_AN_X_AND_Y_SCALE
+				off += 4
+			case flags&0x0080 != 0: // WE_HAVE_A_TWO_BY_TWO
+				off += 8
+			}
+			if flags&0x0020 == 0 { // MORE_COMPONENTS
+				break
+			}
+		}
+	}
+	return
+}
+
+// subsetGlyf drops the outlines of the glyphs that aren't kept and returns the
+// new glyf table and its loca table in the long format.
+func subsetGlyf(glyf []byte, loca []int, keep []bool) ([]byte, []byte) {
+	var newGlyf []byte
+	newLoca := make([]byte, 4*(len(keep)+1))
+	for g := range keep {
+		binary.BigEndian.PutUint32(newLoca[4*g:], uint32(len(newGlyf)))
+		if keep[g] {
+			newGlyf = append(newGlyf, glyf[loca[g]:loca[g+1]]...)
+			for len(newGlyf)%4 != 0 {
+				newGlyf = append(newGlyf, 0)
+			}
+		}
+	}
+	binary.BigEndian.PutUint32(newLoca[4*len(keep):], uint32(len(newGlyf)))
+	return newGlyf, newLoca
+}
+
+// gsubClosure keeps the glyphs that GSUB lookups can substitute for kept
+// glyphs and reports whether glyphs were added. All lookups are followed,
+// whatever feature or script they belong to.
+func gsubClosure(gsub []byte, keep []bool) (changed bool) {
+	d := fontData(gsub)
+	if d == nil {
+		return false
+	}
+	add := func(g uint16) {
+		if int(g) < len(keep) && !keep[g] {
+			keep[g] = true
+			changed = true
+		}
+	}
+	kept := func(g uint16) bool {
+		return int(g) < len(keep) && keep[g]
+	}
+
+	lookupList := int(d.u16(8))
+	for i := 0; i < int(d.u16(lookupList)); i++ {
+		lookup := lookupList + int(d.u16(lookupList+2+2*i))
+		lookupType := d.u16(lookup)
+		for j := 0; j < int(d.u16(lookup+4)); j++ {
+			sub, subType := lookup+int(d.u16(lookup+6+2*j)), lookupType
+			if subType == 7 { // Extension
+				sub, subType = sub+int(d.u32(sub+4)), d.u16(sub+2)
+			}
+			coverage := coverageGlyphs(d, sub+int(d.u16(sub+2)))
+
+			switch subType {
+			case 1: // Single
+				if d.u16(sub) == 1 {
+					delta := d.u16(sub + 4)
+					for _, g := range coverage {
+						if kept(g) {
+							add(g + delta)
+						}
+					}
+				} else {
+					for k, g := range coverage {
+						if kept(g) && k < int(d.u16(sub+4)) {
+							add(d.u16(sub + 6 + 2*k))
+						}
+					}
+				}
+			case 2, 3: // Multiple, Alternate
+				for k, g := range coverage {
+					if !kept(g) || k >= int(d.u16(sub+4)) {
+						continue
+					}
+					seq := sub + int(d.u16(sub+6+2*k))
+					for l := 0; l < int(d.u16(seq)); l++ {
+						add(d.u16(seq + 2 + 2*l))
+					}
+				}
+			case 4: // Ligature
+				for k, g := range coverage {
+					if !kept(g) || k >= int(d.u16(sub+4)) {
+						continue
+					}
+					set := sub + int(d.u16(sub+6+2*k))
+					for l := 0; l < int(d.u16(set)); l++ {
+						lig := set + int(d.u16(set+2+2*l))
+						all := true
+						for c := 0; c < int(d.u16(lig+2))-1; c++ {
+							all = all && kept(d.u16(lig+4+2*c))
+						}
+						if all {
+							add(d.u16(lig))
+						}
+					}
+				}
+			case 8: // Reverse chaining single
+				off := sub + 4
+				off += 2 + 2*int(d.u16(off)) // backtrack coverages
+				off += 2 + 2*int(d.u16(off)) // lookahead coverages
+				for k, g := range coverage {
+					if kept(g) && k < int(d.u16(off)) {
+						add(d.u16(off + 2 + 2*k))
+					}
+				}
+			}
+			// Contextual lookups only apply the other lookups, which are all
+			// followed anyway
+		}
+	}
+	return
+}
+
+// coverageGlyphs returns the glyphs of a coverage table in coverage index
+// order.
+func coverageGlyphs(d fontData, off int) (glyphs []uint16) {
+	switch d.u16(off) {
+	case 1:
+		for i := 0; i < int(d.u16(off+2)); i++ {
+			glyphs = append(glyphs, d.u16(off+4+2*i))
+		}
+	case 2:
+		for i := 0; i < int(d.u16(off+2)); i++ {
+			start, end := int(d.u16(off+4+6*i)), int(d.u16(off+6+6*i))
+			for g := start; g <= end; g++ {
+				glyphs = append(glyphs, uint16(g))
+			}
+		}
+	}
+	return
+}
+
+// operators of CFF DICTs whose operands are offsets, escaped ones as 1200+n
+const (
+	cffCharset     = 15
+	cffEncoding    = 16
+	cffCharStrings = 17
+	cffPrivate     = 18
+	cffSubrs       = 19
+	cffFDArray     = 1236
+	cffFDSelect    = 1237
+)
+
+// cffEndchar is the charstring of an empty glyph
+var cffEndchar = []byte{14}
+
+type cffDictEntry struct {
+	op       int
+	operands []int  // integer operands, reals are read as 0
+	raw      []byte // the encoded operands and operator
+}
+
+// subsetCFF replaces the charstrings of the glyphs that aren't kept with empty
+// ones and lays out the structures of the CFF table again.
+func subsetCFF(cff []byte, keep []bool) ([]byte, error) {
+	d := fontData(cff)
+	if d.u8(0) != 1 {
+		return nil, errors.New("unsupported CFF version")
+	}
+	nameEnd, _, err := cffIndex(d, d.u8(2))
+	if err != nil {
+		return nil, err
+	}
+	topEnd, topDicts, err := cffIndex(d, nameEnd)
+	if err != nil {
+		return nil, err
+	}
+	if len(topDicts) != 1 {
+		return nil, errors.New("CFF font sets are not supported")
+	}
+	stringsEnd, _, err := cffIndex(d, topEnd)
+	if err != nil {
+		return nil, err
+	}
+	gsubrsEnd, _, err := cffIndex(d, stringsEnd)
+	if err != nil {
+		return nil, err
+	}
+	top, err := parseCFFDict(topDicts[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Offsets are written as 5 byte integers, so the size of the Top DICT is
+	// known before the offsets are
+	operands := make(map[int][]int)
+	values := make(map[int][]int)
+	for _, e := range top {
+		switch {
+		case e.op == cffCharset && len(e.operands) == 1 && e.operands[0] > 2,
+			e.op == cffEncoding && len(e.operands) == 1 && e.operands[0] > 1,
+			e.op == cffCharStrings && len(e.operands) == 1,
+			e.op == cffFDArray && len(e.operands) == 1,
+			e.op == cffFDSelect && len(e.operands) == 1,
+			e.op == cffPrivate && len(e.operands) == 2:
+			operands[e.op] = e.operands
+			values[e.op] = make([]int, len(e.operands))
+		}
+	}
+	if operands[cffCharStrings] == nil {
+		return nil, errors.New("no CFF charstrings")
+	}
+	topSize := len(writeCFFIndex([][]byte{encodeCFFDict(top, values)}))
+	rest := d[topEnd:gsubrsEnd]
+
+	var blocks []byte
+	blocksStart := nameEnd + topSize + len(rest)
+	addBlock := func(block []byte) int {
+		off := blocksStart + len(blocks)
+		blocks = append(blocks, block...)
+		return off
+	}
+	copyBlock := func(op int, size int) error {
+		off := operands[op][0]
+		block := d.slice(off, size)
+		if size < 0 || block == nil {
+			return errors.New("invalid CFF offset")
+		}
+		values[op][0] = addBlock(block)
+		return nil
+	}
+	// Private DICTs are followed by their local subrs, at an offset relative
+	// to the DICT
+	addPrivate := func(size int, off int) ([]int, error) {
+		entries, err := parseCFFDict(d.slice(off, size))
+		if err != nil {
+			return nil, err
+		}
+		privateValues := make(map[int][]int)
+		var subrs []byte
+		for _, e := range entries {
+			if e.op == cffSubrs && len(e.operands) == 1 {
+				end, _, err := cffIndex(d, off+e.operands[0])
+				if err != nil {
+					return nil, err
+				}
+				subrs = d[off+e.operands[0] : end]
+				privateValues[cffSubrs] = []int{0}
+			}
+		}
+		private := encodeCFFDict(entries, privateValues)
+		if subrs != nil {
+			privateValues[cffSubrs][0] = len(private)
+			private = encodeCFFDict(entries, privateValues)
+		}
+		privateOff := addBlock(private)
+		addBlock(subrs)
+		return []int{len(private), privateOff}, nil
+	}
+
+	_, charStrings, err := cffIndex(d, operands[cffCharStrings][0])
+	if err != nil {
+		return nil, err
+	}
+	numGlyphs := len(charStrings)
+
+	if operands[cffCharset] != nil {
+		if err := copyBlock(cffCharset, cffCharsetSize(d, operands[cffCharset][0], numGlyphs)); err != nil {
+			return nil, err
+		}
+	}
+	if operands[cffEncoding] != nil {
+		if err := copyBlock(cffEncoding, cffEncodingSize(d, operands[cffEncoding][0])); err != nil {
+			return nil, err
+		}
+	}
+	if operands[cffFDSelect] != nil {
+		if err := copyBlock(cffFDSelect, cffFDSelectSize(d, operands[cffFDSelect][0], numGlyphs)); err != nil {
+			return nil, err
+		}
+	}
+
+	for g := range charStrings {
+		if g >= len(keep) || !keep[g] {
+			charStrings[g] = cffEndchar
+		}
+	}
+	values[cffCharStrings][0] = addBlock(writeCFFIndex(charStrings))
+
+	if operands[cffPrivate] != nil {
+		values[cffPrivate], err = addPrivate(operands[cffPrivate][0], operands[cffPrivate][1])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// The Font DICTs of CID-keyed fonts each have a Private DICT
+	if operands[cffFDArray] != nil {
+		_, fontDicts, err := cffIndex(d, operands[cffFDArray][0])
+		if err != nil {
+			return nil, err
+		}
+		for i, fontDict := range fontDicts {
+			entries, err := parseCFFDict(fontDict)
+			if err != nil {
+				return nil, err
+			}
+			fontValues := make(map[int][]int)
+			for _, e := range entries {
+				if e.op == cffPrivate && len(e.operands) == 2 {
+					fontValues[cffPrivate], err = addPrivate(e.operands[0], e.operands[1])
+					if err != nil {
+						return nil, err
+					}
+				}
+			}
+			fontDicts[i] = encodeCFFDict(entries, fontValues)
+		}
+		values[cffFDArray][0] = addBlock(writeCFFIndex(fontDicts))
+	}
+
+	out := append([]byte(nil), d[:nameEnd]...)
+	out = append(out, writeCFFIndex([][]byte{encodeCFFDict(top, values)})...)
+	out = append(out, rest...)
+	return append(out, blocks...), nil
+}
+
+// cffIndex reads the INDEX at off and returns its end and items.
+func cffIndex(d fontData, off int) (int, [][]byte, error) {
+	count := int(d.u16(off))
+	if count == 0 {
+		return off + 2, nil, nil
+	}
+	offSize := d.u8(off + 2)
+	if offSize < 1 || offSize > 4 {
+		return 0, nil, errors.New("invalid CFF INDEX")
+	}
+	offset := func(i int) int {
+		o := 0
+		for _, b := range d.slice(off+3+i*offSize, offSize) {
+			o = o<<8 | int(b)
+		}
+		return o
+	}
+	// Offsets start at 1, from the byte before the data
+	dataStart := off + 3 + (count+1)*offSize - 1
+	items := make([][]byte, count)
+	for i := range items {
+		start, end := offset(i), offset(i+1)
+		if start < 1 || end < start || dataStart+end > len(d) {
+			return 0, nil, errors.New("invalid CFF INDEX")
+		}
+		items[i] = d[dataStart+start : dataStart+end]
+	}
+	return dataStart + offset(count), items, nil
+}
+
+func writeCFFIndex(items [][]byte) []byte {
+	if len(items) == 0 {
+		return []byte{0, 0}
+	}
+	size := 1
+	for _, item := range items {
+		size += len(item)
+	}
+	offSize := 1
+	for limit := 0xFF; size > limit && offSize < 4; limit = limit<<8 | 0xFF {
+		offSize++
+	}
+
+	out := []byte{byte(len(items) >> 8), byte(len(items)), byte(offSize)}
+	putOffset := func(o int) {
+		for i := offSize - 1; i >= 0; i-- {
+			out = append(out, byte(o>>(8*i)))
+		}
+	}
+	o := 1
+	putOffset(o)
+	for _, item := range items {
+		o += len(item)
+		putOffset(o)
+	}
+	for _, item := range items {
+		out = append(out, item...)
+	}
+	return out
+}
+
+func parseCFFDict(data []byte) ([]cffDictEntry, error) {
+	if data == nil {
+		return nil, errors.New("invalid CFF DICT offset")
+	}
+	d := fontData(data)
+	var entries []cffDictEntry
+	var operands []int
+	start := 0
+	for i := 0; i < len(d); {
+		b := int(d[i])
+		switch {
+		case b <= 21:
+			op := b
+			i++
+			if b == 12 {
+				op = 1200 + d.u8(i)
+				i++
+			}
+			if i > len(d) {
+				return nil, errors.New("truncated CFF DICT")
+			}
+			entries = append(entries, cffDictEntry{op: op, operands: operands, raw: d[start:i]})
+			operands = nil
+			start = i
+			continue
+		case b == 28:
+			operands = append(operands, int(int16(d.u16(i+1))))
+			i += 3
+		case b == 29:
+			operands = append(operands, int(int32(d.u32(i+1))))
+			i += 5
+		case b == 30:
+			// real numbers end with a 0xf nibble
+			for i++; i < len(d) && d[i]&0x0f != 0x0f && d[i]&0xf0 != 0xf0; i++ {
+			}
+			i++
+			operands = append(operands, 0)
+		case b >= 32 && b <= 246:
+			operands = append(operands, b-139)
+			i++
+		case b >= 247 && b <= 250:
+			operands = append(operands, (b-247)*256+d.u8(i+1)+108)
+			i += 2
+		case b >= 251 && b <= 254:
+			operands = append(operands, -(b-251)*256-d.u8(i+1)-108)
+			i += 2
+		default:
+			return nil, errors.New("invalid CFF DICT")
+		}
+	}
+	if operands != nil {
+		return nil, errors.New("truncated CFF DICT")
+	}
+	return entries, nil
+}
+
+// encodeCFFDict encodes a DICT, replacing the operands of the operators in
+// values with 5 byte integers.
+func encodeCFFDict(entries []cffDictEntry, values map[int][]int) []byte {
+	var out []byte
+	for _, e := range entries {
+		v, ok := values[e.op]
+		if !ok {
+			out = append(out, e.raw...)
+			continue
+		}
+		for _, n := range v {
+			out = append(out, 29, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		}
+		if e.op >= 1200 {
+			out = append(out, 12, byte(e.op-1200))
+		} else {
+			out = append(out, byte(e.op))
+		}
+	}
+	return out
+}
+
+func cffCharsetSize(d fontData, off int, numGlyphs int) int {
+	format := d.u8(off)
+	if format == 0 {
+		return 1 + 2*(numGlyphs-1)
+	}
+	if format != 1 && format != 2 {
+		return -1
+	}
+	// Ranges cover every glyph but .notdef
+	size := 1
+	for covered := 1; covered < numGlyphs && off+size < len(d); size += 2 + format {
+		if format == 1 {
+			covered += d.u8(off+size+2) + 1
+		} else {
+			covered += int(d.u16(off+size+2)) + 1
+		}
+	}
+	return size
+}
+
+func cffEncodingSize(d fontData, off int) int {
+	format := d.u8(off)
+	var size int
+	switch format & 0x7f {
+	case 0:
+		size = 2 + d.u8(off+1)
+	case 1:
+		size = 2 + 2*d.u8(off+1)
+	default:
+		return -1
+	}
+	if format&0x80 != 0 {
+		size += 1 + 3*d.u8(off+size)
+	}
+	return size
+}
+
+func cffFDSelectSize(d fontData, off int, numGlyphs int) int {
+	switch d.u8(off) {
+	case 0:
+		return 1 + numGlyphs
+	case 3:
+		return 5 + 3*int(d.u16(off+1))
+	}
+	return -1
+}
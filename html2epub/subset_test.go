@@ -0,0 +1,165 @@
+package html2epub
+
+import (
+	"math/rand"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+func runeSet(s string) map[rune]bool {
+	used := make(map[rune]bool)
+	for _, r := range s {
+		used[r] = true
+	}
+	return used
+}
+
+// glyphSegments returns the outline of the glyph of r, or nil if the font has
+// no glyph for it.
+func glyphSegments(t *testing.T, f *sfnt.Font, r rune) []sfnt.Segment {
+	t.Helper()
+	var b sfnt.Buffer
+	g, err := f.GlyphIndex(&b, r)
+	if err != nil {
+		t.Fatalf("GlyphIndex(%q): %s", r, err)
+	}
+	if g == 0 {
+		return nil
+	}
+	segments, err := f.LoadGlyph(&b, g, fixed.I(int(f.UnitsPerEm())), nil)
+	if err != nil {
+		t.Fatalf("LoadGlyph(%q): %s", r, err)
+	}
+	return append([]sfnt.Segment(nil), segments...)
+}
+
+// checkSubset subsets the font to kept and checks that the subset parses, that
+// the outlines of kept characters are unchanged and that those of dropped
+// characters are empty.
+func checkSubset(t *testing.T, data []byte, kept string, dropped string) []byte {
+	t.Helper()
+	orig, err := sfnt.Parse(data)
+	if err != nil {
+		t.Fatalf("parse original: %s", err)
+	}
+
+	out, err := subsetFont(data, runeSet(kept))
+	if err != nil {
+		t.Fatalf("subsetFont: %s", err)
+	}
+	sub, err := sfnt.Parse(out)
+	if err != nil {
+		t.Fatalf("parse subset: %s", err)
+	}
+	if orig.NumGlyphs() != sub.NumGlyphs() {
+		t.Errorf("subset has %d glyphs, want %d", sub.NumGlyphs(), orig.NumGlyphs())
+	}
+
+	for _, r := range kept {
+		want := glyphSegments(t, orig, r)
+		if want == nil {
+			t.Fatalf("original font has no glyph for %q", r)
+		}
+		if got := glyphSegments(t, sub, r); !reflect.DeepEqual(got, want) {
+			t.Errorf("outline of kept %q changed: got %d segments, want %d", r, len(got), len(want))
+		}
+	}
+	for _, r := range dropped {
+		if glyphSegments(t, orig, r) == nil {
+			t.Fatalf("original font has no glyph for %q", r)
+		}
+		if got := glyphSegments(t, sub, r); len(got) != 0 {
+			t.Errorf("outline of dropped %q kept: %d segments", r, len(got))
+		}
+	}
+
+	// Metrics stay those of the original font
+	var b sfnt.Buffer
+	ppem := fixed.I(int(orig.UnitsPerEm()))
+	for _, r := range kept {
+		g, _ := orig.GlyphIndex(&b, r)
+		want, _ := orig.GlyphAdvance(&b, g, ppem, font.HintingNone)
+		got, _ := sub.GlyphAdvance(&b, g, ppem, font.HintingNone)
+		if got != want {
+			t.Errorf("advance of %q: got %v, want %v", r, got, want)
+		}
+	}
+
+	return out
+}
+
+func TestSubsetFontTrueType(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"goregular", goregular.TTF},
+		{"gobold", gobold.TTF},
+		{"gomono", gomono.TTF},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			out := checkSubset(t, tt.data, "Hello,wrd!é", "ZQxyz§")
+			if len(out) >= len(tt.data) {
+				t.Errorf("subset is %d bytes, not smaller than the original %d", len(out), len(tt.data))
+			}
+		})
+	}
+}
+
+func TestSubsetFontCFF(t *testing.T) {
+	data, err := os.ReadFile("testdata/CFFTest.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSubset(t, data, "0中", "1Q")
+}
+
+func TestSubsetFontMalformed(t *testing.T) {
+	cff, err := os.ReadFile("testdata/CFFTest.otf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := runeSet("Hello")
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"text", []byte("not a font at all")},
+		{"collection", []byte("ttcf\x00\x01\x00\x00\x00\x00\x00\x01\x00\x00\x00\x0c")},
+		{"directory only", goregular.TTF[:12]},
+		{"truncated ttf", goregular.TTF[:len(goregular.TTF)/2]},
+		{"truncated otf", cff[:len(cff)/2]},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := subsetFont(tt.data, used); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+
+	// Whatever the damage, subsetting must fail or give a font, not panic
+	rnd := rand.New(rand.NewSource(1))
+	for _, data := range [][]byte{goregular.TTF, cff} {
+		for n := 0; n < len(data); n += 1 + rnd.Intn(97) {
+			subsetFont(data[:n], used)
+		}
+		for i := 0; i < 200; i++ {
+			damaged := append([]byte(nil), data...)
+			// Keep the table directory mostly intact so the tables get parsed
+			for j := 0; j < 1+rnd.Intn(32); j++ {
+				damaged[12+rnd.Intn(len(damaged)-12)] = byte(rnd.Intn(256))
+			}
+			subsetFont(damaged, used)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package html2epub
+
+import (
+	"fmt"
+
+	"github.com/gonejack/html-to-epub/go-epub"
+)
+
+func (h *HtmlToEpub) validate() error {
+	invalid := 0
+	for _, file := range h.Validate.EPUB {
+		findings, err := epub.ValidateFile(file)
+		if err != nil {
+			return fmt.Errorf("cannot validate %s: %s", file, err)
+		}
+		errs := 0
+		for _, f := range findings {
+			if f.Severity == epub.SeverityError {
+				errs++
+			} else if !h.Verbose {
+				continue
+			}
+			fmt.Printf("%s: %s\n", file, f)
+		}
+		if errs > 0 {
+			invalid++
+		}
+		fmt.Printf("%s: %d errors, %d warnings\n", file, errs, len(findings)-errs)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d epub files are invalid", invalid, len(h.Validate.EPUB))
+	}
+	return nil
+}